@@ -3,11 +3,15 @@ module github.com/wencan/fastrest
 go 1.19
 
 require (
+	github.com/alicebob/miniredis/v2 v2.30.4
 	github.com/go-playground/validator/v10 v10.11.1
 	github.com/golang/mock v1.6.0
 	github.com/gorilla/schema v1.2.0
 	github.com/json-iterator/go v1.1.12
+	github.com/klauspost/compress v1.16.7
+	github.com/redis/go-redis/v9 v9.0.5
 	github.com/stretchr/testify v1.8.1
+	github.com/vmihailenco/msgpack/v5 v5.3.5
 	github.com/wencan/gox v0.0.0-20231102070418-35ed5bfaa935
 	golang.org/x/exp v0.0.0-20230321023759-10a507213a29
 	google.golang.org/grpc v1.52.0
@@ -16,7 +20,10 @@ require (
 )
 
 require (
+	github.com/alicebob/gopher-json v0.0.0-20200520072559-a9ecdc9d1d3a // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 	github.com/go-playground/locales v0.14.0 // indirect
 	github.com/go-playground/universal-translator v0.18.0 // indirect
 	github.com/golang/protobuf v1.5.2 // indirect
@@ -24,7 +31,9 @@ require (
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
 	github.com/modern-go/reflect2 v1.0.2 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
 	github.com/wencan/freesync v0.0.0-20221116002138-ac341bb206c4 // indirect
+	github.com/yuin/gopher-lua v1.1.0 // indirect
 	golang.org/x/crypto v0.5.0 // indirect
 	golang.org/x/net v0.5.0 // indirect
 	golang.org/x/sys v0.4.0 // indirect
@@ -0,0 +1,185 @@
+package restcache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// runInBatches 将length个元素按batchSize分批，最多concurrency批同时执行f。
+// batchSize小于等于0，只有一批。concurrency小于等于0，逐批执行。
+// 出错或者ctx结束后，不再执行后面的批次。返回第一个错误。
+func runInBatches(ctx context.Context, length, batchSize, concurrency int, f func(batchIndex, begin, end int) error) error {
+	if batchSize <= 0 || batchSize >= length {
+		return f(0, 0, length)
+	}
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+	var failed = make(chan struct{})
+	setErr := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			close(failed)
+		})
+	}
+
+	semaphore := make(chan struct{}, concurrency)
+	for batchIndex, begin := 0, 0; begin < length; batchIndex, begin = batchIndex+1, begin+batchSize {
+		end := begin + batchSize
+		if end > length {
+			end = length
+		}
+
+		select {
+		case semaphore <- struct{}{}:
+		case <-failed:
+		case <-ctx.Done():
+			setErr(ctx.Err())
+		}
+		select {
+		case <-failed:
+		default:
+			wg.Add(1)
+			go func(batchIndex, begin, end int) {
+				defer wg.Done()
+				defer func() { <-semaphore }()
+
+				err := f(batchIndex, begin, end)
+				if err != nil {
+					setErr(err)
+				}
+			}(batchIndex, begin, end)
+			continue
+		}
+		break
+	}
+	wg.Wait()
+
+	return firstErr
+}
+
+// batchCount 分批的批数。
+func batchCount(length, batchSize int) int {
+	if batchSize <= 0 || batchSize >= length {
+		return 1
+	}
+	return (length + batchSize - 1) / batchSize
+}
+
+// batchMStorage 分批执行MGet、MSet的MStorage。
+type batchMStorage struct {
+	MStorage
+
+	batchSize int
+
+	concurrency int
+}
+
+// mstorage 返回查询、存储用的MStorage。如果设置了MaxBatchSize，分批执行。
+func (mcaching *MCaching) mstorage() MStorage {
+	if mcaching.MaxBatchSize <= 0 {
+		return mcaching.MStorage
+	}
+	return batchMStorage{
+		MStorage:    mcaching.MStorage,
+		batchSize:   mcaching.MaxBatchSize,
+		concurrency: mcaching.MaxConcurrency,
+	}
+}
+
+// unwrapStorage 返回分批执行的MStorage包装的MStorage。用于检查可选接口。
+func unwrapStorage(storage interface{}) interface{} {
+	if batch, ok := storage.(batchMStorage); ok {
+		return batch.MStorage
+	}
+	return storage
+}
+
+// MGet 实现MStorage接口。
+func (storage batchMStorage) MGet(ctx context.Context, keys []string, valueSlicePtr interface{}) (missIndexes []int, err error) {
+	count := batchCount(len(keys), storage.batchSize)
+	if count == 1 {
+		return storage.MStorage.MGet(ctx, keys, valueSlicePtr)
+	}
+
+	sliceType := reflect.TypeOf(valueSlicePtr).Elem()
+	batchValues := make([]reflect.Value, count)
+	batchMissIndexes := make([][]int, count)
+	err = runInBatches(ctx, len(keys), storage.batchSize, storage.concurrency, func(batchIndex, begin, end int) error {
+		valuesPtr := reflect.New(sliceType)
+		missIndexes, err := storage.MStorage.MGet(ctx, keys[begin:end], valuesPtr.Interface())
+		if err != nil {
+			return err
+		}
+		batchValues[batchIndex] = valuesPtr.Elem()
+		for _, missIndex := range missIndexes {
+			batchMissIndexes[batchIndex] = append(batchMissIndexes[batchIndex], begin+missIndex)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 按批次的顺序组合结果
+	valueSliceValue := reflect.ValueOf(valueSlicePtr).Elem()
+	for batchIndex := 0; batchIndex < count; batchIndex++ {
+		valueSliceValue.Set(reflect.AppendSlice(valueSliceValue, batchValues[batchIndex]))
+		missIndexes = append(missIndexes, batchMissIndexes[batchIndex]...)
+	}
+	return missIndexes, nil
+}
+
+// MSet 实现MStorage接口。
+func (storage batchMStorage) MSet(ctx context.Context, keys []string, valueSlice interface{}, ttl time.Duration) error {
+	valueSliceValue := reflect.ValueOf(valueSlice)
+	if len(keys) != valueSliceValue.Len() {
+		return errors.New("wrong arguments")
+	}
+	return runInBatches(ctx, len(keys), storage.batchSize, storage.concurrency, func(batchIndex, begin, end int) error {
+		return storage.MStorage.MSet(ctx, keys[begin:end], valueSliceValue.Slice(begin, end).Interface(), ttl)
+	})
+}
+
+// mqueryInBatches 按MaxBatchSize分批调用mquery，最多MaxConcurrency批同时。获得的分布式锁登记到locks。
+// 逻辑同MQuery，destSlicePtr元素的顺序同doKeys的顺序，返回没找到部分的下标。
+func (mcaching *MCaching) mqueryInBatches(ctx context.Context, locks *heldLocks, destSlicePtr interface{}, doKeys []string, doArgsSliceValue reflect.Value) (queryMissIndexes []int, err error) {
+	count := batchCount(len(doKeys), mcaching.MaxBatchSize)
+	if count == 1 {
+		return mcaching.mquery(ctx, locks, destSlicePtr, doKeys, doArgsSliceValue)
+	}
+
+	sliceType := reflect.TypeOf(destSlicePtr).Elem()
+	batchValues := make([]reflect.Value, count)
+	batchMissIndexes := make([][]int, count)
+	err = runInBatches(ctx, len(doKeys), mcaching.MaxBatchSize, mcaching.MaxConcurrency, func(batchIndex, begin, end int) error {
+		valuesPtr := reflect.New(sliceType)
+		missIndexes, err := mcaching.mquery(ctx, locks, valuesPtr.Interface(), doKeys[begin:end], doArgsSliceValue.Slice(begin, end))
+		if err != nil {
+			return err
+		}
+		batchValues[batchIndex] = valuesPtr.Elem()
+		for _, missIndex := range missIndexes {
+			batchMissIndexes[batchIndex] = append(batchMissIndexes[batchIndex], begin+missIndex)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 按批次的顺序组合结果
+	destSliceValue := reflect.ValueOf(destSlicePtr).Elem()
+	for batchIndex := 0; batchIndex < count; batchIndex++ {
+		destSliceValue.Set(reflect.AppendSlice(destSliceValue, batchValues[batchIndex]))
+		queryMissIndexes = append(queryMissIndexes, batchMissIndexes[batchIndex]...)
+	}
+	return queryMissIndexes, nil
+}
@@ -0,0 +1,119 @@
+package restcache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wencan/fastrest/restcache/lrucache"
+)
+
+// recordingMStorage 记录每次MGet、MSet的key数。
+type recordingMStorage struct {
+	MStorage
+
+	mu sync.Mutex
+
+	sizes []int
+}
+
+func (storage *recordingMStorage) MGet(ctx context.Context, keys []string, valueSlicePtr interface{}) (missIndexes []int, err error) {
+	storage.mu.Lock()
+	storage.sizes = append(storage.sizes, len(keys))
+	storage.mu.Unlock()
+	return storage.MStorage.MGet(ctx, keys, valueSlicePtr)
+}
+
+func (storage *recordingMStorage) MSet(ctx context.Context, keys []string, valueSlice interface{}, ttl time.Duration) error {
+	storage.mu.Lock()
+	storage.sizes = append(storage.sizes, len(keys))
+	storage.mu.Unlock()
+	return storage.MStorage.MSet(ctx, keys, valueSlice, ttl)
+}
+
+func TestMCaching_MaxBatchSize(t *testing.T) {
+	storage := &recordingMStorage{MStorage: lrucache.NewLRUCache(1000, 10)}
+	var mu sync.Mutex
+	var querySizes []int
+	mcaching := MCaching{
+		MStorage: storage,
+		MQuery: func(ctx context.Context, destSlicePtr, argsSlice interface{}) (missIndexes []int, err error) {
+			mu.Lock()
+			querySizes = append(querySizes, len(argsSlice.([]int)))
+			mu.Unlock()
+			for index, req := range argsSlice.([]int) {
+				if req%4 == 0 {
+					missIndexes = append(missIndexes, index)
+					continue
+				}
+				*destSlicePtr.(*[]string) = append(*destSlicePtr.(*[]string), "echo: "+strconv.Itoa(req))
+			}
+			return missIndexes, nil
+		},
+		TTLRange:       [2]time.Duration{time.Minute * 4, time.Minute * 6},
+		NotFoundTTL:    time.Minute,
+		MaxBatchSize:   3,
+		MaxConcurrency: 2,
+	}
+
+	// 部分已缓存
+	storage.MStorage.MSet(context.TODO(), []string{"1", "5"}, []string{"echo: 1", "echo: 5"}, time.Minute)
+
+	var keys []string
+	var argsSlice []int
+	var wants []string
+	var wantMissIndexes []int
+	for i := 0; i < 10; i++ {
+		keys = append(keys, strconv.Itoa(i))
+		argsSlice = append(argsSlice, i)
+		if i%4 == 0 {
+			wantMissIndexes = append(wantMissIndexes, i)
+		} else {
+			wants = append(wants, "echo: "+strconv.Itoa(i))
+		}
+	}
+	for i := 0; i < 2; i++ {
+		var resps []string
+		missIndexes, err := mcaching.MGet(context.TODO(), &resps, keys, argsSlice)
+		if assert.Nil(t, err) {
+			assert.Equal(t, wantMissIndexes, missIndexes)
+			assert.Equal(t, wants, resps)
+		}
+	}
+
+	// 8个未缓存的，分3批查询
+	assert.ElementsMatch(t, []int{3, 3, 2}, querySizes)
+	for _, size := range storage.sizes {
+		assert.LessOrEqual(t, size, 3)
+	}
+}
+
+func Test_runInBatches(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][2]int
+	err := runInBatches(context.TODO(), 10, 4, 2, func(batchIndex, begin, end int) error {
+		mu.Lock()
+		defer mu.Unlock()
+		batches = append(batches, [2]int{begin, end})
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, [][2]int{{0, 4}, {4, 8}, {8, 10}}, batches)
+
+	// 出错后不再执行后面的批次
+	batchErr := errors.New("batch error")
+	var count int
+	err = runInBatches(context.TODO(), 10, 2, 1, func(batchIndex, begin, end int) error {
+		count++
+		if batchIndex == 1 {
+			return batchErr
+		}
+		return nil
+	})
+	assert.Equal(t, batchErr, err)
+	assert.LessOrEqual(t, count, 3)
+}
@@ -0,0 +1,70 @@
+package restcache
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// CircuitBreaker 缓存存储的熔断器。
+// 缓存存储连续出错达到阈值后熔断，在冷却时间内跳过缓存存储。冷却时间过后，恢复使用缓存存储。
+// 可以被多个Caching、MCaching共用。
+type CircuitBreaker struct {
+	// FailureThreshold 连续出错次数的阈值。
+	FailureThreshold int64
+
+	// CoolDown 熔断的冷却时间。
+	CoolDown time.Duration
+
+	// failures 连续出错的次数。
+	failures int64
+
+	// openUntil 熔断结束的时间。单位纳秒。
+	openUntil int64
+}
+
+// Allow 是否允许使用缓存存储。熔断期间返回false。
+func (breaker *CircuitBreaker) Allow() bool {
+	return time.Now().UnixNano() >= atomic.LoadInt64(&breaker.openUntil)
+}
+
+// Success 记录一次缓存存储操作成功。
+func (breaker *CircuitBreaker) Success() {
+	if atomic.LoadInt64(&breaker.failures) != 0 {
+		atomic.StoreInt64(&breaker.failures, 0)
+	}
+}
+
+// Failure 记录一次缓存存储操作出错。连续出错达到阈值后熔断。
+func (breaker *CircuitBreaker) Failure() {
+	failures := atomic.AddInt64(&breaker.failures, 1)
+	if failures >= breaker.FailureThreshold {
+		atomic.StoreInt64(&breaker.openUntil, time.Now().Add(breaker.CoolDown).UnixNano())
+		atomic.StoreInt64(&breaker.failures, 0)
+	}
+}
+
+// allowStorage 是否允许使用缓存存储。
+func allowStorage(breaker *CircuitBreaker) bool {
+	return breaker == nil || breaker.Allow()
+}
+
+// handleStorageError 处理缓存存储操作的结果。记录熔断器，通知观察者。
+// 如果容忍错误，返回nil；否则返回原错误。
+func handleStorageError(ctx context.Context, observer Observer, breaker *CircuitBreaker, tolerate bool, keys []string, start time.Time, err error) error {
+	if err == nil {
+		if breaker != nil {
+			breaker.Success()
+		}
+		return nil
+	}
+
+	if breaker != nil {
+		breaker.Failure()
+	}
+	observe(ctx, observer, EventStorageError, keys, time.Since(start), err)
+	if tolerate {
+		return nil
+	}
+	return err
+}
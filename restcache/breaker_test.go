@@ -0,0 +1,112 @@
+package restcache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/wencan/fastrest/restcache/mock_restcache"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	breaker := &CircuitBreaker{FailureThreshold: 2, CoolDown: time.Millisecond * 100}
+	assert.True(t, breaker.Allow())
+
+	breaker.Failure()
+	breaker.Success()
+	breaker.Failure()
+	assert.True(t, breaker.Allow())
+
+	breaker.Failure()
+	assert.False(t, breaker.Allow())
+
+	time.Sleep(time.Millisecond * 150)
+	assert.True(t, breaker.Allow())
+}
+
+func TestCaching_TolerateStorageError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	storageErr := errors.New("storage error")
+	mockStorage := mock_restcache.NewMockStorage(ctrl)
+	// 连续出错两次后熔断，不再调用Storage
+	mockStorage.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(false, storageErr).Times(1)
+	mockStorage.EXPECT().Set(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storageErr).Times(1)
+
+	var queryCount int
+	var storageErrors int
+	caching := Caching{
+		Storage: mockStorage,
+		Query: func(ctx context.Context, destPtr, args interface{}) (found bool, err error) {
+			queryCount++
+			*destPtr.(*string) = "echo"
+			return true, nil
+		},
+		TTLRange: [2]time.Duration{time.Minute * 4, time.Minute * 6},
+		Observer: ObserverFunc(func(ctx context.Context, event Event) {
+			if event.Type == EventStorageError {
+				storageErrors++
+			}
+		}),
+		TolerateStorageError: true,
+		CircuitBreaker:       &CircuitBreaker{FailureThreshold: 2, CoolDown: time.Minute},
+	}
+
+	for i := 0; i < 3; i++ {
+		var resp string
+		found, err := caching.Get(context.TODO(), &resp, "key_"+strconv.Itoa(i), nil)
+		if assert.Nil(t, err) && assert.True(t, found) {
+			assert.Equal(t, "echo", resp)
+		}
+	}
+	assert.Equal(t, 3, queryCount)
+	assert.Equal(t, 2, storageErrors)
+}
+
+func TestMCaching_TolerateStorageError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	storageErr := errors.New("storage error")
+	mockStorage := mock_restcache.NewMockMStorage(ctrl)
+	mockStorage.EXPECT().MGet(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, keys []string, destSlicePtr interface{}) ([]int, error) {
+		// 返回部分数据和错误
+		*destSlicePtr.(*[]string) = append(*destSlicePtr.(*[]string), "dirty")
+		return nil, storageErr
+	}).AnyTimes()
+	mockStorage.EXPECT().MSet(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(storageErr).AnyTimes()
+
+	mcaching := MCaching{
+		MStorage: mockStorage,
+		MQuery: func(ctx context.Context, destSlicePtr, argsSlice interface{}) (missIndexes []int, err error) {
+			for index, req := range argsSlice.([]string) {
+				if req == "" {
+					missIndexes = append(missIndexes, index)
+					continue
+				}
+				*destSlicePtr.(*[]string) = append(*destSlicePtr.(*[]string), "echo: "+req)
+			}
+			return missIndexes, nil
+		},
+		TTLRange:             [2]time.Duration{time.Minute * 4, time.Minute * 6},
+		TolerateStorageError: true,
+	}
+
+	var resps []string
+	missIndexes, err := mcaching.MGet(context.TODO(), &resps, []string{"key_1", "key_2", "key_3"}, []string{"1", "", "3"})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []int{1}, missIndexes)
+		assert.Equal(t, []string{"echo: 1", "echo: 3"}, resps)
+	}
+
+	// 不容忍错误
+	mcaching2 := MCaching{MStorage: mockStorage, MQuery: mcaching.MQuery}
+	resps = nil
+	_, err = mcaching2.MGet(context.TODO(), &resps, []string{"key_1"}, []string{"1"})
+	assert.Equal(t, storageErr, err)
+}
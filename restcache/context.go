@@ -0,0 +1,41 @@
+package restcache
+
+import "context"
+
+type contextKeyBypass struct{}
+
+type contextKeyRefresh struct{}
+
+// WithBypass 返回跳过缓存的上下文。
+// Caching.Get、MCaching.MGet使用这个上下文时，直接调用查询函数，不读也不写Storage。
+func WithBypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKeyBypass{}, true)
+}
+
+// IsBypass 上下文是否要求跳过缓存。
+func IsBypass(ctx context.Context) bool {
+	bypass, _ := ctx.Value(contextKeyBypass{}).(bool)
+	return bypass
+}
+
+// WithRefresh 返回强制刷新缓存的上下文。
+// Caching.Get、MCaching.MGet使用这个上下文时，不读Storage，调用查询函数重新查询，用查询结果覆盖Storage存储的数据。
+// 如果重新查询没找到，删除Storage存储的数据（需要Storage支持删除）。
+func WithRefresh(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKeyRefresh{}, true)
+}
+
+// IsRefresh 上下文是否要求强制刷新缓存。
+func IsRefresh(ctx context.Context) bool {
+	refresh, _ := ctx.Value(contextKeyRefresh{}).(bool)
+	return refresh
+}
+
+// deleteRefreshedNotFound 删除强制刷新时没找到的数据。存储不支持删除，忽略。
+func deleteRefreshedNotFound(ctx context.Context, storage interface{}, keys []string) error {
+	err := deleteKeys(ctx, storage, keys)
+	if err == ErrDeleteNotSupported {
+		return nil
+	}
+	return err
+}
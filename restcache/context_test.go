@@ -0,0 +1,93 @@
+package restcache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wencan/fastrest/restcache/lrucache"
+)
+
+func TestCaching_BypassAndRefresh(t *testing.T) {
+	storage := lrucache.NewLRUCache(1000, 10)
+	var version int
+	caching := Caching{
+		Storage: storage,
+		Query: func(ctx context.Context, destPtr, args interface{}) (found bool, err error) {
+			version++
+			if version == 4 {
+				return false, nil
+			}
+			*destPtr.(*int) = version
+			return true, nil
+		},
+		TTLRange:    [2]time.Duration{time.Minute * 4, time.Minute * 6},
+		SentinelTTL: time.Minute,
+	}
+
+	var resp int
+	found, err := caching.Get(context.TODO(), &resp, "key", nil)
+	if assert.Nil(t, err) && assert.True(t, found) {
+		assert.Equal(t, 1, resp)
+	}
+
+	// 跳过缓存，不写回
+	found, err = caching.Get(WithBypass(context.TODO()), &resp, "key", nil)
+	if assert.Nil(t, err) && assert.True(t, found) {
+		assert.Equal(t, 2, resp)
+	}
+	found, err = caching.Get(context.TODO(), &resp, "key", nil)
+	if assert.Nil(t, err) && assert.True(t, found) {
+		assert.Equal(t, 1, resp)
+	}
+
+	// 强制刷新，不受哨兵影响，覆盖存储的数据
+	found, err = caching.Get(WithRefresh(context.TODO()), &resp, "key", nil)
+	if assert.Nil(t, err) && assert.True(t, found) {
+		assert.Equal(t, 3, resp)
+	}
+	var stored int
+	found, err = storage.Get(context.TODO(), "key", &stored)
+	if assert.Nil(t, err) && assert.True(t, found) {
+		assert.Equal(t, 3, stored)
+	}
+
+	// 强制刷新没找到，删除存储的数据
+	found, err = caching.Get(WithRefresh(context.TODO()), &resp, "key", nil)
+	if assert.Nil(t, err) {
+		assert.False(t, found)
+	}
+	found, err = storage.Get(context.TODO(), "key", &stored)
+	if assert.Nil(t, err) {
+		assert.False(t, found)
+	}
+}
+
+func TestMCaching_BypassAndRefresh(t *testing.T) {
+	var queried [][]string
+	mcaching := MCaching{
+		MStorage: lrucache.NewLRUCache(1000, 10),
+		MQuery: func(ctx context.Context, destSlicePtr, argsSlice interface{}) (missIndexes []int, err error) {
+			reqs := argsSlice.([]string)
+			queried = append(queried, reqs)
+			for _, req := range reqs {
+				*destSlicePtr.(*[]string) = append(*destSlicePtr.(*[]string), "echo: "+req)
+			}
+			return nil, nil
+		},
+		TTLRange:    [2]time.Duration{time.Minute * 4, time.Minute * 6},
+		SentinelTTL: time.Minute,
+	}
+
+	keys := []string{"key_1", "key_2"}
+	for _, ctx := range []context.Context{context.TODO(), WithBypass(context.TODO()), WithRefresh(context.TODO()), context.TODO()} {
+		var resps []string
+		missIndexes, err := mcaching.MGet(ctx, &resps, keys, keys)
+		if assert.Nil(t, err) {
+			assert.Empty(t, missIndexes)
+			assert.Equal(t, []string{"echo: key_1", "echo: key_2"}, resps)
+		}
+	}
+	assert.Equal(t, [][]string{keys, keys, keys}, queried)
+}
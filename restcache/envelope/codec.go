@@ -0,0 +1,145 @@
+package envelope
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/wencan/fastrest/restcodecs/restjson"
+)
+
+// Codec 缓存数据的序列化接口。同rediscache.Codec，rediscache的各个Codec实现都可以使用。
+type Codec interface {
+	// Marshal 序列化。
+	Marshal(v interface{}) ([]byte, error)
+
+	// Unmarshal 反序列化。
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// jsonCodec 基于restjson的json序列化。
+type jsonCodec struct{}
+
+// Marshal 实现Codec接口。
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return restjson.Marshal(v)
+}
+
+// Unmarshal 实现Codec接口。
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return restjson.Unmarshal(data, v)
+}
+
+// Compressor 压缩接口。
+type Compressor interface {
+	// ID 压缩算法的标识。写入信封，解压时用于选择压缩算法。0表示不压缩，不可使用。
+	ID() byte
+
+	// Compress 压缩。
+	Compress(data []byte) ([]byte, error)
+
+	// Decompress 解压。
+	Decompress(data []byte) ([]byte, error)
+}
+
+// GzipCompressorID gzip压缩的标识。
+const GzipCompressorID byte = 1
+
+// GzipCompressor gzip压缩。
+type GzipCompressor struct {
+	// Level 压缩级别。0表示gzip.DefaultCompression。
+	Level int
+}
+
+// ID 实现Compressor接口。
+func (compressor GzipCompressor) ID() byte {
+	return GzipCompressorID
+}
+
+// Compress 实现Compressor接口。
+func (compressor GzipCompressor) Compress(data []byte) ([]byte, error) {
+	level := compressor.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+
+	var buffer bytes.Buffer
+	writer, err := gzip.NewWriterLevel(&buffer, level)
+	if err != nil {
+		return nil, err
+	}
+	_, err = writer.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// Decompress 实现Compressor接口。
+func (compressor GzipCompressor) Decompress(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// ZstdCompressorID zstd压缩的标识。
+const ZstdCompressorID byte = 2
+
+// ZstdCompressor zstd压缩。比gzip更快，压缩率相近。
+type ZstdCompressor struct {
+	// Level 压缩级别。0表示zstd.SpeedDefault。
+	Level zstd.EncoderLevel
+}
+
+var (
+	// zstdEncoders 各压缩级别共用的zstd编码器。EncodeAll并发安全。
+	zstdEncoders sync.Map
+
+	// zstdDecoder 共用的zstd解码器。DecodeAll并发安全。
+	zstdDecoder     *zstd.Decoder
+	zstdDecoderErr  error
+	zstdDecoderOnce sync.Once
+)
+
+// ID 实现Compressor接口。
+func (compressor ZstdCompressor) ID() byte {
+	return ZstdCompressorID
+}
+
+// Compress 实现Compressor接口。
+func (compressor ZstdCompressor) Compress(data []byte) ([]byte, error) {
+	level := compressor.Level
+	if level == 0 {
+		level = zstd.SpeedDefault
+	}
+
+	encoder, ok := zstdEncoders.Load(level)
+	if !ok {
+		newEncoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(level), zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		encoder, _ = zstdEncoders.LoadOrStore(level, newEncoder)
+	}
+	return encoder.(*zstd.Encoder).EncodeAll(data, nil), nil
+}
+
+// Decompress 实现Compressor接口。
+func (compressor ZstdCompressor) Decompress(data []byte) ([]byte, error) {
+	zstdDecoderOnce.Do(func() {
+		zstdDecoder, zstdDecoderErr = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+	})
+	if zstdDecoderErr != nil {
+		return nil, zstdDecoderErr
+	}
+	return zstdDecoder.DecodeAll(data, nil)
+}
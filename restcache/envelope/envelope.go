@@ -0,0 +1,342 @@
+package envelope
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/wencan/fastrest/restcache"
+)
+
+// 信封格式：
+//
+//	版本(1字节) | 压缩算法标识(1字节，0为不压缩) | 密钥ID长度(1字节，0为不加密) | 密钥ID | nonce | 数据
+//
+// 加密时，信封头和缓存key作为AES-GCM的附加数据，避免密文被挪到其它key下。
+const envelopeVersion byte = 1
+
+var (
+	// ErrInvalidEnvelope 数据不是有效的信封。
+	ErrInvalidEnvelope = errors.New("invalid envelope")
+
+	// errUnknownKeyID 信封的密钥ID不在密钥中。比如密钥已经轮换下线。
+	errUnknownKeyID = errors.New("unknown key id")
+)
+
+// Config 信封缓存存储的配置。
+type Config struct {
+	// Codec 缓存数据的序列化。默认为json序列化。
+	Codec Codec
+
+	// Compressor 压缩算法。可选。为nil不压缩。比如GzipCompressor、ZstdCompressor。
+	Compressor Compressor
+
+	// MinCompressSize 序列化后小于MinCompressSize字节的数据不压缩。
+	MinCompressSize int
+
+	// Decompressors 额外的解压算法。可选。用于读取以前用其它压缩算法存储的数据。
+	Decompressors []Compressor
+
+	// Keys AES密钥。密钥ID -> 16、24或者32字节的密钥。可选。为空不加密。
+	// 轮换密钥时，先加入新密钥并设为PrimaryKeyID，旧数据过期后再移除旧密钥。
+	// 使用已移除的密钥加密的数据，等同没找到。
+	Keys map[string][]byte
+
+	// PrimaryKeyID 加密使用的密钥ID。Keys不为空时必须设置。
+	PrimaryKeyID string
+}
+
+// EnvelopeStorage 信封缓存存储。将数据序列化、压缩、加密后，存储到内层的缓存存储。
+// 内层缓存存储存取[]byte类型的数据。比如使用rediscache.BytesCodec的rediscache.RedisCache。
+// 实现了restcache的Storage接口和MStorage接口，以及Deleter接口、MDeleter接口和PrefixDeleter接口。
+type EnvelopeStorage struct {
+	inner restcache.Storage
+
+	codec Codec
+
+	compressor Compressor
+
+	minCompressSize int
+
+	decompressors map[byte]Compressor
+
+	aeads map[string]cipher.AEAD
+
+	primaryKeyID string
+}
+
+// NewEnvelopeStorage 创建信封缓存存储。inner为内层缓存存储。
+func NewEnvelopeStorage(inner restcache.Storage, config Config) (*EnvelopeStorage, error) {
+	storage := &EnvelopeStorage{
+		inner:           inner,
+		codec:           config.Codec,
+		compressor:      config.Compressor,
+		minCompressSize: config.MinCompressSize,
+		decompressors:   make(map[byte]Compressor),
+		aeads:           make(map[string]cipher.AEAD),
+		primaryKeyID:    config.PrimaryKeyID,
+	}
+	if storage.codec == nil {
+		storage.codec = jsonCodec{}
+	}
+
+	for _, compressor := range append(config.Decompressors, config.Compressor) {
+		if compressor == nil {
+			continue
+		}
+		if compressor.ID() == 0 {
+			return nil, errors.New("compressor id must not be 0")
+		}
+		storage.decompressors[compressor.ID()] = compressor
+	}
+
+	for keyID, key := range config.Keys {
+		if keyID == "" || len(keyID) > 255 {
+			return nil, fmt.Errorf("invalid key id [%s]", keyID)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key [%s]: %w", keyID, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		storage.aeads[keyID] = aead
+	}
+	if len(storage.aeads) > 0 && storage.aeads[storage.primaryKeyID] == nil {
+		return nil, fmt.Errorf("primary key id [%s] not found", storage.primaryKeyID)
+	}
+
+	return storage, nil
+}
+
+// seal 序列化、压缩、加密。
+func (storage *EnvelopeStorage) seal(key string, value interface{}) ([]byte, error) {
+	data, err := storage.codec.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var compressorID byte
+	if storage.compressor != nil && len(data) >= storage.minCompressSize {
+		data, err = storage.compressor.Compress(data)
+		if err != nil {
+			return nil, err
+		}
+		compressorID = storage.compressor.ID()
+	}
+
+	if len(storage.aeads) == 0 {
+		return append([]byte{envelopeVersion, compressorID, 0}, data...), nil
+	}
+
+	aead := storage.aeads[storage.primaryKeyID]
+	header := make([]byte, 0, 3+len(storage.primaryKeyID))
+	header = append(header, envelopeVersion, compressorID, byte(len(storage.primaryKeyID)))
+	header = append(header, storage.primaryKeyID...)
+
+	envelope := make([]byte, len(header)+aead.NonceSize(), len(header)+aead.NonceSize()+len(data)+aead.Overhead())
+	copy(envelope, header)
+	nonce := envelope[len(header):]
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(envelope, nonce, data, additionalData(header, key)), nil
+}
+
+// open 解密、解压、反序列化。
+func (storage *EnvelopeStorage) open(key string, envelope []byte, valuePtr interface{}) error {
+	if len(envelope) < 3 || envelope[0] != envelopeVersion {
+		return ErrInvalidEnvelope
+	}
+	compressorID := envelope[1]
+	keyIDLength := int(envelope[2])
+	if len(envelope) < 3+keyIDLength {
+		return ErrInvalidEnvelope
+	}
+	header := envelope[:3+keyIDLength]
+	data := envelope[3+keyIDLength:]
+
+	if keyIDLength > 0 {
+		aead := storage.aeads[string(header[3:])]
+		if aead == nil {
+			return errUnknownKeyID
+		}
+		if len(data) < aead.NonceSize() {
+			return ErrInvalidEnvelope
+		}
+		var err error
+		data, err = aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], additionalData(header, key))
+		if err != nil {
+			return err
+		}
+	}
+
+	if compressorID != 0 {
+		compressor := storage.decompressors[compressorID]
+		if compressor == nil {
+			return fmt.Errorf("unknown compressor id [%d]", compressorID)
+		}
+		var err error
+		data, err = compressor.Decompress(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return storage.codec.Unmarshal(data, valuePtr)
+}
+
+// additionalData AES-GCM的附加数据。
+func additionalData(header []byte, key string) []byte {
+	ad := make([]byte, 0, len(header)+len(key))
+	ad = append(ad, header...)
+	return append(ad, key...)
+}
+
+// Get 实现restcache的Storage接口。
+func (storage *EnvelopeStorage) Get(ctx context.Context, key string, valuePtr interface{}) (found bool, err error) {
+	var envelope []byte
+	found, err = storage.inner.Get(ctx, key, &envelope)
+	if err != nil || !found {
+		return false, err
+	}
+
+	err = storage.open(key, envelope, valuePtr)
+	if err == errUnknownKeyID {
+		// 密钥已经下线，等同没找到
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Set 实现restcache的Storage接口。
+func (storage *EnvelopeStorage) Set(ctx context.Context, key string, value interface{}, TTL time.Duration) error {
+	envelope, err := storage.seal(key, value)
+	if err != nil {
+		return err
+	}
+	return storage.inner.Set(ctx, key, envelope, TTL)
+}
+
+// MGet 实现restcache的MStorage接口。如果内层缓存存储没实现MStorage接口，逐个查询。
+func (storage *EnvelopeStorage) MGet(ctx context.Context, keys []string, valueSlicePtr interface{}) (missIndexes []int, err error) {
+	var envelopes [][]byte
+	var envelopeMissIndexes []int
+	if mstorage, ok := storage.inner.(restcache.MStorage); ok {
+		envelopeMissIndexes, err = mstorage.MGet(ctx, keys, &envelopes)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		for index, key := range keys {
+			var envelope []byte
+			found, err := storage.inner.Get(ctx, key, &envelope)
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				envelopeMissIndexes = append(envelopeMissIndexes, index)
+				continue
+			}
+			envelopes = append(envelopes, envelope)
+		}
+	}
+
+	valueSliceValue := reflect.ValueOf(valueSlicePtr).Elem()
+	elemType := valueSliceValue.Type().Elem()
+	var envelopeCount, missCount int
+	for index, key := range keys {
+		if missCount < len(envelopeMissIndexes) && envelopeMissIndexes[missCount] == index {
+			missIndexes = append(missIndexes, index)
+			missCount++
+			continue
+		}
+		if envelopeCount >= len(envelopes) {
+			return nil, errors.New("not enough cache results")
+		}
+		envelope := envelopes[envelopeCount]
+		envelopeCount++
+
+		elemPtr := reflect.New(elemType)
+		err = storage.open(key, envelope, elemPtr.Interface())
+		if err == errUnknownKeyID {
+			// 密钥已经下线，等同没找到
+			missIndexes = append(missIndexes, index)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		valueSliceValue.Set(reflect.Append(valueSliceValue, elemPtr.Elem()))
+	}
+	return missIndexes, nil
+}
+
+// MSet 实现restcache的MStorage接口。如果内层缓存存储没实现MStorage接口，逐个存储。
+func (storage *EnvelopeStorage) MSet(ctx context.Context, keys []string, valueSlice interface{}, ttl time.Duration) error {
+	valueSliceValue := reflect.ValueOf(valueSlice)
+	if len(keys) != valueSliceValue.Len() {
+		return errors.New("wrong arguments")
+	}
+
+	envelopes := make([][]byte, 0, len(keys))
+	for index, key := range keys {
+		envelope, err := storage.seal(key, valueSliceValue.Index(index).Interface())
+		if err != nil {
+			return err
+		}
+		envelopes = append(envelopes, envelope)
+	}
+
+	if mstorage, ok := storage.inner.(restcache.MStorage); ok {
+		return mstorage.MSet(ctx, keys, envelopes, ttl)
+	}
+	for index, key := range keys {
+		err := storage.inner.Set(ctx, key, envelopes[index], ttl)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete 实现restcache的Deleter接口。内层缓存存储需要支持删除。
+func (storage *EnvelopeStorage) Delete(ctx context.Context, key string) error {
+	return storage.MDelete(ctx, []string{key})
+}
+
+// MDelete 实现restcache的MDeleter接口。内层缓存存储需要支持删除。
+func (storage *EnvelopeStorage) MDelete(ctx context.Context, keys []string) error {
+	switch deleter := storage.inner.(type) {
+	case restcache.MDeleter:
+		return deleter.MDelete(ctx, keys)
+	case restcache.Deleter:
+		for _, key := range keys {
+			err := deleter.Delete(ctx, key)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return restcache.ErrDeleteNotSupported
+	}
+}
+
+// DeletePrefix 实现restcache的PrefixDeleter接口。内层缓存存储需要支持按前缀删除。
+func (storage *EnvelopeStorage) DeletePrefix(ctx context.Context, prefix string) error {
+	deleter, ok := storage.inner.(restcache.PrefixDeleter)
+	if !ok {
+		return restcache.ErrDeleteNotSupported
+	}
+	return deleter.DeletePrefix(ctx, prefix)
+}
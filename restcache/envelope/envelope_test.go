@@ -0,0 +1,204 @@
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/wencan/fastrest/restcache"
+	"github.com/wencan/fastrest/restcache/lrucache"
+	"github.com/wencan/fastrest/restcache/rediscache"
+)
+
+var _ restcache.Storage = (*EnvelopeStorage)(nil)
+var _ restcache.MStorage = (*EnvelopeStorage)(nil)
+var _ restcache.MDeleter = (*EnvelopeStorage)(nil)
+var _ restcache.PrefixDeleter = (*EnvelopeStorage)(nil)
+
+type testResponse struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+var (
+	testKey1 = []byte("0123456789abcdef0123456789abcdef")
+	testKey2 = []byte("fedcba9876543210fedcba9876543210")
+)
+
+func TestEnvelopeStorage(t *testing.T) {
+	configs := map[string]Config{
+		"plain":    {},
+		"compress": {Compressor: GzipCompressor{}},
+		"zstd":     {Compressor: ZstdCompressor{}},
+		"encrypt":  {Keys: map[string][]byte{"k1": testKey1}, PrimaryKeyID: "k1"},
+		"both":     {Compressor: GzipCompressor{}, Keys: map[string][]byte{"k1": testKey1}, PrimaryKeyID: "k1"},
+	}
+	for name, config := range configs {
+		t.Run(name, func(t *testing.T) {
+			inner := lrucache.NewLRUCache(100, 10)
+			storage, err := NewEnvelopeStorage(inner, config)
+			if !assert.Nil(t, err) {
+				return
+			}
+
+			ctx := context.TODO()
+			value := testResponse{Name: "alice", Email: strings.Repeat("alice@example.com", 10)}
+			err = storage.Set(ctx, "user_1", value, time.Minute)
+			assert.Nil(t, err)
+
+			var resp testResponse
+			found, err := storage.Get(ctx, "user_1", &resp)
+			if assert.Nil(t, err) && assert.True(t, found) {
+				assert.Equal(t, value, resp)
+			}
+
+			// 内层存储的数据
+			var envelope []byte
+			found, err = inner.Get(ctx, "user_1", &envelope)
+			if assert.Nil(t, err) && assert.True(t, found) {
+				plaintext := bytes.Contains(envelope, []byte("alice"))
+				assert.Equal(t, name == "plain", plaintext)
+			}
+
+			// 批量
+			err = storage.MSet(ctx, []string{"user_2", "user_3"}, []testResponse{{Name: "bob"}, {Name: "carol"}}, time.Minute)
+			assert.Nil(t, err)
+			var resps []testResponse
+			missIndexes, err := storage.MGet(ctx, []string{"user_2", "user_miss", "user_3"}, &resps)
+			if assert.Nil(t, err) {
+				assert.Equal(t, []int{1}, missIndexes)
+				assert.Equal(t, []testResponse{{Name: "bob"}, {Name: "carol"}}, resps)
+			}
+
+			err = storage.Delete(ctx, "user_2")
+			assert.Nil(t, err)
+			found, err = storage.Get(ctx, "user_2", &resp)
+			if assert.Nil(t, err) {
+				assert.False(t, found)
+			}
+		})
+	}
+}
+
+func TestEnvelopeStorage_Redis(t *testing.T) {
+	s := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	defer client.Close()
+
+	// 内层存储原样存取信封
+	inner := rediscache.NewRedisCache(client, rediscache.BytesCodec, "test:")
+	storage, err := NewEnvelopeStorage(inner, Config{
+		Compressor:    ZstdCompressor{},
+		Decompressors: []Compressor{GzipCompressor{}},
+		Keys:          map[string][]byte{"k1": testKey1},
+		PrimaryKeyID:  "k1",
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	ctx := context.TODO()
+	value := testResponse{Name: "alice", Email: strings.Repeat("alice@example.com", 10)}
+	err = storage.Set(ctx, "user_1", value, time.Minute)
+	assert.Nil(t, err)
+	stored, err := s.Get("test:user_1")
+	if assert.Nil(t, err) {
+		assert.Equal(t, envelopeVersion, stored[0])
+		assert.Equal(t, ZstdCompressorID, stored[1])
+	}
+
+	var resp testResponse
+	found, err := storage.Get(ctx, "user_1", &resp)
+	if assert.Nil(t, err) && assert.True(t, found) {
+		assert.Equal(t, value, resp)
+	}
+
+	// 读取以前用gzip压缩存储的数据
+	gzipStorage, err := NewEnvelopeStorage(inner, Config{Compressor: GzipCompressor{}, Keys: map[string][]byte{"k1": testKey1}, PrimaryKeyID: "k1"})
+	if !assert.Nil(t, err) {
+		return
+	}
+	err = gzipStorage.Set(ctx, "user_2", value, time.Minute)
+	assert.Nil(t, err)
+	found, err = storage.Get(ctx, "user_2", &resp)
+	if assert.Nil(t, err) && assert.True(t, found) {
+		assert.Equal(t, value, resp)
+	}
+}
+
+func TestEnvelopeStorage_KeyRotation(t *testing.T) {
+	inner := lrucache.NewLRUCache(100, 10)
+	ctx := context.TODO()
+
+	storage1, err := NewEnvelopeStorage(inner, Config{Keys: map[string][]byte{"k1": testKey1}, PrimaryKeyID: "k1"})
+	if !assert.Nil(t, err) {
+		return
+	}
+	err = storage1.Set(ctx, "old", testResponse{Name: "old"}, time.Minute)
+	assert.Nil(t, err)
+
+	// 加入新密钥，旧数据仍可读取
+	storage2, err := NewEnvelopeStorage(inner, Config{Keys: map[string][]byte{"k1": testKey1, "k2": testKey2}, PrimaryKeyID: "k2"})
+	if !assert.Nil(t, err) {
+		return
+	}
+	err = storage2.Set(ctx, "new", testResponse{Name: "new"}, time.Minute)
+	assert.Nil(t, err)
+	var resp testResponse
+	found, err := storage2.Get(ctx, "old", &resp)
+	if assert.Nil(t, err) && assert.True(t, found) {
+		assert.Equal(t, "old", resp.Name)
+	}
+
+	// 移除旧密钥，旧数据等同没找到
+	storage3, err := NewEnvelopeStorage(inner, Config{Keys: map[string][]byte{"k2": testKey2}, PrimaryKeyID: "k2"})
+	if !assert.Nil(t, err) {
+		return
+	}
+	var resps []testResponse
+	missIndexes, err := storage3.MGet(ctx, []string{"old", "new"}, &resps)
+	if assert.Nil(t, err) {
+		assert.Equal(t, []int{0}, missIndexes)
+		assert.Equal(t, []testResponse{{Name: "new"}}, resps)
+	}
+}
+
+func TestEnvelopeStorage_Tamper(t *testing.T) {
+	inner := lrucache.NewLRUCache(100, 10)
+	ctx := context.TODO()
+	storage, err := NewEnvelopeStorage(inner, Config{Keys: map[string][]byte{"k1": testKey1}, PrimaryKeyID: "k1"})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	err = storage.Set(ctx, "user_1", testResponse{Name: "alice"}, time.Minute)
+	assert.Nil(t, err)
+
+	// 密文挪到其它key下，不能解密
+	var envelope []byte
+	inner.Get(ctx, "user_1", &envelope)
+	inner.Set(ctx, "user_2", envelope, time.Minute)
+	var resp testResponse
+	_, err = storage.Get(ctx, "user_2", &resp)
+	assert.NotNil(t, err)
+
+	// 不是信封
+	inner.Set(ctx, "user_3", []byte("x"), time.Minute)
+	_, err = storage.Get(ctx, "user_3", &resp)
+	assert.Equal(t, ErrInvalidEnvelope, err)
+}
+
+func TestNewEnvelopeStorage_InvalidConfig(t *testing.T) {
+	inner := lrucache.NewLRUCache(100, 10)
+
+	_, err := NewEnvelopeStorage(inner, Config{Keys: map[string][]byte{"k1": []byte("short")}, PrimaryKeyID: "k1"})
+	assert.NotNil(t, err)
+
+	_, err = NewEnvelopeStorage(inner, Config{Keys: map[string][]byte{"k1": testKey1}, PrimaryKeyID: "k2"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,58 @@
+package envelope_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/wencan/fastrest/restcache"
+	"github.com/wencan/fastrest/restcache/envelope"
+	"github.com/wencan/fastrest/restcache/rediscache"
+)
+
+func ExampleNewEnvelopeStorage() {
+	s, err := miniredis.Run()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer s.Close()
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	defer client.Close()
+
+	// 内层的redis存储原样存取信封
+	inner := rediscache.NewRedisCache(client, rediscache.BytesCodec, "example:")
+	storage, err := envelope.NewEnvelopeStorage(inner, envelope.Config{
+		Compressor:   envelope.ZstdCompressor{},
+		Keys:         map[string][]byte{"k1": []byte("0123456789abcdef0123456789abcdef")},
+		PrimaryKeyID: "k1",
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	caching := restcache.Caching{
+		Storage: storage,
+		Query: func(ctx context.Context, destPtr interface{}, args interface{}) (found bool, err error) {
+			*destPtr.(*string) = "echo: " + args.(string)
+			return true, nil
+		},
+		TTLRange:    [2]time.Duration{time.Minute * 4, time.Minute * 6},
+		SentinelTTL: time.Second,
+	}
+
+	var resp string
+	found, err := caching.Get(context.TODO(), &resp, "key:hello", "hello")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if found {
+		fmt.Println(resp)
+	}
+
+	// Output: echo: hello
+}
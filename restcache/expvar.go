@@ -0,0 +1,36 @@
+package restcache
+
+import (
+	"context"
+	"expvar"
+)
+
+// ExpvarObserver 基于expvar的缓存事件观察者。
+// 统计各类事件的次数，和查询函数的累计耗时。
+type ExpvarObserver struct {
+	vars *expvar.Map
+}
+
+// NewExpvarObserver 创建基于expvar的缓存事件观察者。统计数据发布为名为name的expvar.Map。
+// 同expvar.Publish，name重复会panic。
+func NewExpvarObserver(name string) *ExpvarObserver {
+	return &ExpvarObserver{vars: expvar.NewMap(name)}
+}
+
+// Observe 实现Observer接口。
+// 每个key计数一次。查询函数返回的错误计入query_error，查询函数的耗时累加到query_nanoseconds。
+func (observer *ExpvarObserver) Observe(ctx context.Context, event Event) {
+	observer.vars.Add(event.Type.String(), int64(len(event.Keys)))
+
+	if event.Type == EventQueryFinish {
+		observer.vars.Add("query_nanoseconds", int64(event.Duration))
+		if event.Err != nil {
+			observer.vars.Add("query_error", int64(len(event.Keys)))
+		}
+	}
+}
+
+// Vars 返回统计数据。
+func (observer *ExpvarObserver) Vars() *expvar.Map {
+	return observer.vars
+}
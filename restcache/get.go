@@ -3,6 +3,7 @@ package restcache
 import (
 	"context"
 	"reflect"
+	"sync"
 	"time"
 
 	"github.com/wencan/fastrest/resterror"
@@ -34,6 +35,7 @@ type Caching struct {
 	Query QueryFunc
 
 	// TTLRange 缓存生存时间区间。每次随机取一个区间内的值。
+	// 如果缓存对象实现了TTLer接口，优先使用缓存对象的TTL。
 	TTLRange [2]time.Duration
 
 	// sentinelGroup  哨兵机制。
@@ -42,61 +44,303 @@ type Caching struct {
 	// SentinelTTL 哨兵和哨兵持有的临时缓存的生存时间。用来省去双重检查。一般1s即可。
 	// “副作用”是可以避免高频查询找不到的数据。
 	SentinelTTL time.Duration
+
+	// NotFoundTTL 没找到的标记（墓碑）的生存时间。可选。
+	// 如果大于0，Query没找到的数据，会通过Storage存储一个没找到的标记。
+	// 在标记的生存时间内，再查询这个数据，直接返回没找到，不再调用Query。用于避免缓存穿透。
+	NotFoundTTL time.Duration
+
+	// SoftTTL 缓存数据的新鲜时间。可选。应该小于TTLRange。
+	// 如果大于0，缓存数据存储超过SoftTTL后，在TTLRange的生存时间内，Get直接返回已过时的数据，同时在后台刷新一次。
+	// 超过TTLRange的生存时间后，同未设置SoftTTL，等待查询。
+	SoftTTL time.Duration
+
+	// Observer 缓存事件观察者。可选。
+	Observer Observer
+
+	// TolerateStorageError 是否容忍Storage的错误。可选。
+	// 如果为true，读Storage出错时，调用Query查询；写Storage出错时，照常返回查询结果。错误通过Observer通知。
+	TolerateStorageError bool
+
+	// CircuitBreaker Storage的熔断器。可选。
+	// 熔断期间跳过Storage，直接调用Query查询。一般同TolerateStorageError一起使用。
+	CircuitBreaker *CircuitBreaker
+
+	// Locker 分布式锁。可选。
+	// 如果不为nil，缓存没命中时，多个进程中只有获得锁的进程调用Query查询，其它进程在LockWait时间内轮询Storage，等待查询结果。
+	// 锁出错、等待超时，照常调用Query查询。
+	Locker Locker
+
+	// LockTTL 分布式锁的生存时间。应该大于Query的耗时。默认为5s。
+	LockTTL time.Duration
+
+	// LockWait 没获得锁时，等待查询结果的最长时间。默认为1s。
+	LockWait time.Duration
+
+	// Namespace 缓存key的命名空间。可选。
+	// 如果不为空，存储时key加上前缀“Namespace:Version:世代:”。BumpNamespace改变世代，使命名空间内的全部缓存失效，不需要批量删除。
+	// 世代存储在Storage里，key为Namespace+"#generation"。Storage应该避免淘汰这个key，否则命名空间内的全部缓存失效。
+	// 读取世代出错的，使用已知的世代；没有已知的世代，容忍错误（TolerateStorageError）或者熔断期间，不使用缓存直接查询。
+	Namespace string
+
+	// Version 缓存数据的版本。可选。
+	// 如果不为空，存储时key加上版本前缀。缓存数据的结构改变后，修改Version，不再使用旧版本的数据。
+	Version string
+
+	// NamespaceCheckInterval 检查命名空间世代的间隔。默认为1s。
+	// 其它进程调用BumpNamespace后，最多在这个间隔后使用新的世代。
+	NamespaceCheckInterval time.Duration
+
+	// namespaceGeneration 命名空间的世代。
+	namespaceGeneration namespaceGeneration
+
+	// refreshingKeys 正在后台刷新的key。
+	refreshingKeys sync.Map
+
+	// inflightQueries 正在执行的查询。用于失效。
+	inflightQueries inflightQueryGroup
 }
 
 // Get 查询。destPtr为结果对象指针，key为缓存key，args为查询函数参数。
 // destPtr的值是共享的，内容数据不可修改。
 func (caching *Caching) Get(ctx context.Context, destPtr interface{}, key string, args interface{}) (found bool, err error) {
-	// 先查缓存
+	if IsBypass(ctx) {
+		// 跳过缓存
+		return caching.bypass(ctx, destPtr, key, args)
+	}
+
+	storageKey, err := caching.storageKey(ctx, key)
+	if err == errNoGeneration {
+		// 不能取得命名空间的世代，不使用缓存
+		return caching.bypass(ctx, destPtr, key, args)
+	}
+	if err != nil {
+		return false, err
+	}
+	key = storageKey
+
+	if IsRefresh(ctx) {
+		// 强制刷新。不使用哨兵持有的临时缓存
+		caching.sentinelGroup.Delete(key)
+	} else if allowStorage(caching.CircuitBreaker) { // 先查缓存
+		found, notFound, err := caching.getStored(ctx, destPtr, key, args)
+		if err != nil {
+			return false, err
+		}
+		if found {
+			return true, nil
+		}
+		if notFound {
+			return false, nil
+		}
+	}
+
+	err = caching.query(ctx, destPtr, key, args)
+	if err != nil {
+		if resterror.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// bypass 跳过缓存，直接调用Query查询。
+func (caching *Caching) bypass(ctx context.Context, destPtr interface{}, key string, args interface{}) (found bool, err error) {
+	keys := []string{key} // 用于通知事件
+	observe(ctx, caching.Observer, EventQueryStart, keys, 0, nil)
+	start := time.Now()
+	found, err = caching.Query(ctx, destPtr, args)
+	observe(ctx, caching.Observer, EventQueryFinish, keys, time.Since(start), err)
+	if err != nil {
+		if resterror.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return found, nil
+}
+
+// getStored 从Storage查询。found表示找到有效的缓存数据，notFound表示找到没找到的标记。
+func (caching *Caching) getStored(ctx context.Context, destPtr interface{}, key string, args interface{}) (found, notFound bool, err error) {
+	keys := []string{key} // 用于通知事件
+	start := time.Now()
 	found, err = caching.Storage.Get(ctx, key, destPtr)
 	if err != nil {
 		// 错误
 		// Storage的实现逻辑应该处理掉需要忽略的错误
-		return false, err
-	} else if found {
-		// 通过可选的Validatable接口检查是否失效
-		// 如果已经失效， destPtr指向已污染的数据。如果实现了Reset方法，执行Reset
-		valid := validateAndReset(destPtr)
-		if valid {
-			return true, nil
+		// 如果容忍错误，等同没找到
+		return false, false, caching.handleStorageError(ctx, keys, start, err)
+	}
+	caching.handleStorageError(ctx, keys, start, nil)
+
+	if !found {
+		observe(ctx, caching.Observer, EventMiss, keys, time.Since(start), nil)
+
+		if caching.NotFoundTTL > 0 {
+			// 检查没找到的标记
+			start := time.Now()
+			notFound, err = getNotFoundTombstone(ctx, caching.Storage, key)
+			if err != nil {
+				// 如果容忍错误，等同没有标记
+				return false, false, caching.handleStorageError(ctx, keys, start, err)
+			}
+		}
+		return false, notFound, nil
+	}
+	observe(ctx, caching.Observer, EventHit, keys, time.Since(start), nil)
+
+	// 通过可选的Validatable接口检查是否失效
+	// 如果已经失效， destPtr指向已污染的数据。如果实现了Reset方法，执行Reset
+	valid := validateAndReset(destPtr)
+	if !valid {
+		observe(ctx, caching.Observer, EventInvalid, keys, 0, nil)
+		return false, false, nil
+	}
+
+	if caching.SoftTTL > 0 {
+		// 检查是否过时。过时的数据照常返回，后台刷新
+		start := time.Now()
+		fresh, err := getFreshMarker(ctx, caching.Storage, key)
+		if err != nil {
+			// 如果容忍错误，等同新鲜
+			err = caching.handleStorageError(ctx, keys, start, err)
+			return err == nil, false, err
+		}
+		if !fresh {
+			caching.refreshInBackground(reflect.TypeOf(destPtr).Elem(), key, args)
 		}
 	}
+	return true, false, nil
+}
+
+// query 通过哨兵机制调用Query查询，并保存查询结果。
+func (caching *Caching) query(ctx context.Context, destPtr interface{}, key string, args interface{}) error {
+	keys := []string{key} // 用于通知事件
+	var queried bool      // 是否调用了查询函数。如果没有，是等待了其它调用的查询结果
+	start := time.Now()
 
 	// 哨兵机制。同一进程内，同一时间，不同查询同key的数据
-	err = caching.sentinelGroup.Do(ctx, destPtr, key, args, func(ctx context.Context, destPtr interface{}, args interface{}) error {
+	err := caching.sentinelGroup.Do(ctx, destPtr, key, args, func(ctx context.Context, destPtr interface{}, args interface{}) error {
+		queried = true
+
+		// 登记查询。如果查询期间缓存被失效，查询结果不写回Storage
+		inflight := caching.inflightQueries.begin(key)
+		defer caching.inflightQueries.end(inflight, key)
+
+		if caching.Locker != nil && !IsRefresh(ctx) {
+			// 分布式锁。没获得锁的，等待获得锁的进程查询并存储
+			var loaded, loadedNotFound bool
+			queryIndexes, unlock := lockOrWait(ctx, caching.Locker, keys, caching.LockTTL, caching.LockWait, func(indexes []int) ([]int, error) {
+				if !allowStorage(caching.CircuitBreaker) {
+					return nil, errStopWaiting
+				}
+				found, notFound, err := caching.getStored(ctx, destPtr, key, args)
+				if err != nil {
+					return nil, err
+				}
+				if found || notFound {
+					loaded, loadedNotFound = true, notFound
+					return nil, nil
+				}
+				return indexes, nil
+			})
+			defer unlock()
+			if len(queryIndexes) == 0 && loaded {
+				if loadedNotFound {
+					return resterror.FormatNotFoundError("not found [%s]", key)
+				}
+				return nil
+			}
+		}
+
+		observe(ctx, caching.Observer, EventQueryStart, keys, 0, nil)
+		start := time.Now()
 		found, err := caching.Query(ctx, destPtr, args)
+		observe(ctx, caching.Observer, EventQueryFinish, keys, time.Since(start), err)
 		if err != nil {
 			// Query的实现逻辑应该处理掉需要忽略的错误
 			return err
 		}
+
+		start = time.Now()
 		if !found {
+			if IsRefresh(ctx) && allowStorage(caching.CircuitBreaker) {
+				// 强制刷新没找到，删除存储的数据
+				err = inflight.write(keys, func(validIndexes []int) error {
+					if len(validIndexes) == 0 { // 已失效
+						return nil
+					}
+					return deleteRefreshedNotFound(ctx, caching.Storage, withCompanionKeys(keys, false, caching.SoftTTL > 0))
+				})
+				err = caching.handleStorageError(ctx, keys, start, err)
+				if err != nil {
+					return err
+				}
+			}
+			if caching.NotFoundTTL > 0 && allowStorage(caching.CircuitBreaker) {
+				// 存储没找到的标记
+				err = inflight.write(keys, func(validIndexes []int) error {
+					if len(validIndexes) == 0 { // 已失效
+						return nil
+					}
+					return setNotFoundTombstone(ctx, caching.Storage, key, caching.NotFoundTTL)
+				})
+				err = caching.handleStorageError(ctx, keys, start, err)
+				if err != nil {
+					return err
+				}
+			}
 			return resterror.FormatNotFoundError("not found [%s]", key)
 		}
 
 		// 保存
-		dest := reflect.ValueOf(destPtr).Elem().Interface() // 传入指针，是为了取得值。这里存指针指向的内容。
-		err = caching.Storage.Set(ctx, key, dest, getTTL(caching.TTLRange))
-		if err != nil {
-			// Storage的实现逻辑应该处理掉需要忽略的错误
-			return err
+		if !allowStorage(caching.CircuitBreaker) {
+			return nil
 		}
+		dest := reflect.ValueOf(destPtr).Elem().Interface() // 传入指针，是为了取得值。这里存指针指向的内容。
+		err = inflight.write(keys, func(validIndexes []int) error {
+			if len(validIndexes) == 0 || inflight.tagCanceled(dest) { // 已失效
+				return nil
+			}
 
-		return nil
+			ttl := getValueTTL(dest, getTTL(caching.TTLRange))
+			err := caching.Storage.Set(ctx, key, dest, ttl)
+			if err != nil {
+				// Storage的实现逻辑应该处理掉需要忽略的错误
+				return err
+			}
+			err = addValueTags(ctx, caching.Storage, key, dest, ttl)
+			if err != nil {
+				return err
+			}
+			if caching.SoftTTL > 0 {
+				err = setFreshMarker(ctx, caching.Storage, key, caching.SoftTTL)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		// 如果容忍错误，照常返回查询结果
+		return caching.handleStorageError(ctx, keys, start, err)
 	})
+	if !queried {
+		observe(ctx, caching.Observer, EventCoalesced, keys, time.Since(start), err)
+	}
 	// 延迟删除哨兵（和哨兵持有的临时缓存）
 	// 省去双重检查。
 	time.AfterFunc(caching.SentinelTTL, func() {
 		caching.sentinelGroup.Delete(key)
 	})
 
-	if err != nil {
-		if resterror.IsNotFound(err) {
-			return false, nil
-		}
-		return false, err
-	}
+	return err
+}
 
-	return true, nil
+// handleStorageError 处理Storage操作的结果。如果容忍错误，返回nil。
+func (caching *Caching) handleStorageError(ctx context.Context, keys []string, start time.Time, err error) error {
+	return handleStorageError(ctx, caching.Observer, caching.CircuitBreaker, caching.TolerateStorageError, keys, start, err)
 }
 
 // validateAndReset 校验缓存对象。如果失效且支持Reset，就Reset
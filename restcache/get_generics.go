@@ -0,0 +1,142 @@
+//go:build go1.18
+// +build go1.18
+
+package restcache
+
+import (
+	"context"
+	"time"
+
+	"github.com/wencan/fastrest/resterror"
+	"github.com/wencan/gox/xsync/sentinel"
+)
+
+// GenericsStorage 基于范型的缓存存储接口。一般是对接redis、lru，透明处理业务数据。
+// 可以使用NewGenericsStorage，将Storage实现转为GenericsStorage实现。
+type GenericsStorage[VALUE any] interface {
+	// Get 查询存储的数据。
+	// 实现逻辑应该处理掉需要忽略的错误。
+	Get(ctx context.Context, key string) (value VALUE, found bool, err error)
+
+	// Set 存储数据。
+	// 实现逻辑应该处理掉需要忽略的错误。
+	Set(ctx context.Context, key string, value VALUE, TTL time.Duration) error
+}
+
+// GenericsQueryFunc 基于范型的查询函数，查询未缓存的数据。一般是调http/rpc接口、查持久化数据库。
+type GenericsQueryFunc[ARGS, VALUE any] func(ctx context.Context, args ARGS) (value VALUE, found bool, err error)
+
+// GenericsCaching 基于范型的缓存。逻辑同Caching。
+// ARGS为查询函数参数类型，VALUE为缓存数据类型。
+type GenericsCaching[ARGS, VALUE any] struct {
+	// Storage 存储接口。
+	Storage GenericsStorage[VALUE]
+
+	// Query 如果没从Storage里找到，调用Query查询。
+	Query GenericsQueryFunc[ARGS, VALUE]
+
+	// TTLRange 缓存生存时间区间。每次随机取一个区间内的值。
+	// 如果缓存对象实现了TTLer接口，优先使用缓存对象的TTL。
+	TTLRange [2]time.Duration
+
+	// sentinelGroup  哨兵机制。
+	sentinelGroup sentinel.SentinelGroup
+
+	// SentinelTTL 哨兵和哨兵持有的临时缓存的生存时间。用来省去双重检查。一般1s即可。
+	// “副作用”是可以避免高频查询找不到的数据。
+	SentinelTTL time.Duration
+}
+
+// Get 查询。key为缓存key，args为查询函数参数。
+// 返回的value是共享的，内容数据不可修改。
+func (caching *GenericsCaching[ARGS, VALUE]) Get(ctx context.Context, key string, args ARGS) (value VALUE, found bool, err error) {
+	// 先查缓存
+	value, found, err = caching.Storage.Get(ctx, key)
+	if err != nil {
+		// Storage的实现逻辑应该处理掉需要忽略的错误
+		return value, false, err
+	} else if found {
+		// 通过可选的Validatable接口检查是否失效
+		valid := validateAndResetGenerics(&value)
+		if valid {
+			return value, true, nil
+		}
+	}
+
+	// 哨兵机制。同一进程内，同一时间，不同查询同key的数据
+	// 查询参数通过闭包传递，避免类型断言
+	err = caching.sentinelGroup.Do(ctx, &value, key, nil, func(ctx context.Context, destPtr interface{}, _ interface{}) error {
+		queried, found, err := caching.Query(ctx, args)
+		if err != nil {
+			// Query的实现逻辑应该处理掉需要忽略的错误
+			return err
+		}
+		if !found {
+			return resterror.FormatNotFoundError("not found [%s]", key)
+		}
+		*destPtr.(*VALUE) = queried
+
+		// 保存
+		err = caching.Storage.Set(ctx, key, queried, getValueTTL(queried, getTTL(caching.TTLRange)))
+		if err != nil {
+			// Storage的实现逻辑应该处理掉需要忽略的错误
+			return err
+		}
+
+		return nil
+	})
+	// 延迟删除哨兵（和哨兵持有的临时缓存）
+	// 省去双重检查。
+	time.AfterFunc(caching.SentinelTTL, func() {
+		caching.sentinelGroup.Delete(key)
+	})
+
+	if err != nil {
+		var zero VALUE
+		if resterror.IsNotFound(err) {
+			return zero, false, nil
+		}
+		return zero, false, err
+	}
+
+	return value, true, nil
+}
+
+// genericsStorage 将Storage实现适配为GenericsStorage实现。
+type genericsStorage[VALUE any] struct {
+	storage Storage
+}
+
+// NewGenericsStorage 将Storage实现（比如lrucache.LRUCache）转为GenericsStorage实现。
+func NewGenericsStorage[VALUE any](storage Storage) GenericsStorage[VALUE] {
+	return genericsStorage[VALUE]{storage: storage}
+}
+
+// Get 实现GenericsStorage接口。
+func (s genericsStorage[VALUE]) Get(ctx context.Context, key string) (value VALUE, found bool, err error) {
+	found, err = s.storage.Get(ctx, key, &value)
+	return value, found, err
+}
+
+// Set 实现GenericsStorage接口。
+func (s genericsStorage[VALUE]) Set(ctx context.Context, key string, value VALUE, TTL time.Duration) error {
+	return s.storage.Set(ctx, key, value, TTL)
+}
+
+// isGenericsValidatable 缓存对象类型是否实现了Validatable接口。方法的接收者可以是值，也可以是指针。
+func isGenericsValidatable[VALUE any](valuePtr *VALUE) bool {
+	if _, ok := any(valuePtr).(Validatable); ok {
+		return true
+	}
+	_, ok := any(*valuePtr).(Validatable)
+	return ok
+}
+
+// validateAndResetGenerics 校验缓存对象。如果失效且支持Reset，就Reset。
+// 先按指针检查Validatable接口，VALUE本身是指针类型的，再按值检查。
+func validateAndResetGenerics[VALUE any](valuePtr *VALUE) (valid bool) {
+	if _, ok := any(valuePtr).(Validatable); ok {
+		return validateAndReset(valuePtr)
+	}
+	return validateAndReset(*valuePtr)
+}
@@ -0,0 +1,88 @@
+//go:build go1.18
+// +build go1.18
+
+package restcache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wencan/fastrest/restcache/lrucache"
+)
+
+func TestGenericsCaching_Get(t *testing.T) {
+	type Response struct {
+		Echo string
+	}
+
+	var queryCount int64
+	caching := GenericsCaching[int, Response]{
+		Storage: NewGenericsStorage[Response](lrucache.NewLRUCache(1000, 10)),
+		Query: func(ctx context.Context, args int) (value Response, found bool, err error) {
+			atomic.AddInt64(&queryCount, 1)
+			if args < 0 {
+				return value, false, nil
+			}
+			if args == 0 {
+				return value, false, errors.New("zero")
+			}
+			return Response{Echo: "echo: " + strconv.Itoa(args)}, true, nil
+		},
+		TTLRange:    [2]time.Duration{time.Minute * 4, time.Minute * 6},
+		SentinelTTL: time.Second,
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for index := 1; index <= 100; index++ {
+				value, found, err := caching.Get(context.TODO(), "key_"+strconv.Itoa(index), index)
+				if assert.Nil(t, err) && assert.True(t, found) {
+					assert.Equal(t, "echo: "+strconv.Itoa(index), value.Echo)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(100), atomic.LoadInt64(&queryCount)) // 每个key只查询一次
+
+	_, found, err := caching.Get(context.TODO(), "key_notfound", -1)
+	if assert.Nil(t, err) {
+		assert.False(t, found)
+	}
+
+	_, _, err = caching.Get(context.TODO(), "key_error", 0)
+	assert.NotNil(t, err)
+}
+
+func TestGenericsCaching_GetWithValid(t *testing.T) {
+	storage := lrucache.NewLRUCache(1000, 10)
+	storage.Set(context.TODO(), "valid", testResponseWithValid{Valid: true}, time.Minute)
+	storage.Set(context.TODO(), "invalid", testResponseWithValid{Valid: false}, time.Minute)
+
+	caching := GenericsCaching[string, testResponseWithValid]{
+		Storage: NewGenericsStorage[testResponseWithValid](storage),
+		Query: func(ctx context.Context, args string) (value testResponseWithValid, found bool, err error) {
+			return value, false, nil
+		},
+		TTLRange: [2]time.Duration{time.Minute * 4, time.Minute * 6},
+	}
+
+	_, found, err := caching.Get(context.TODO(), "valid", "valid")
+	if assert.Nil(t, err) {
+		assert.True(t, found)
+	}
+	_, found, err = caching.Get(context.TODO(), "invalid", "invalid")
+	if assert.Nil(t, err) {
+		assert.False(t, found)
+	}
+}
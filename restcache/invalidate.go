@@ -0,0 +1,325 @@
+package restcache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// Deleter 支持删除的缓存存储接口。Storage实现可选实现。
+type Deleter interface {
+	// Delete 删除存储的数据。数据不存在，不返回错误。
+	Delete(ctx context.Context, key string) error
+}
+
+// MDeleter 支持批量删除的缓存存储接口。Storage、MStorage实现可选实现。
+type MDeleter interface {
+	// MDelete 批量删除存储的数据。数据不存在，不返回错误。
+	MDelete(ctx context.Context, keys []string) error
+}
+
+// PrefixDeleter 支持按前缀删除的缓存存储接口。Storage、MStorage实现可选实现。
+type PrefixDeleter interface {
+	// DeletePrefix 删除key具有指定前缀的全部数据。
+	DeletePrefix(ctx context.Context, prefix string) error
+}
+
+// ErrDeleteNotSupported 缓存存储不支持删除。
+var ErrDeleteNotSupported = errors.New("storage does not support delete")
+
+// Invalidate 使缓存失效。删除Storage存储的数据，并阻止正在执行的查询将结果写回Storage。
+// Storage需要实现MDeleter接口或者Deleter接口。
+func (caching *Caching) Invalidate(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	keys, err := caching.storageKeys(ctx, keys)
+	if err == errNoGeneration {
+		// 不能取得命名空间的世代，容忍错误或者熔断期间，跳过
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return caching.invalidate(ctx, keys)
+}
+
+// invalidate 使Storage使用的key失效。
+func (caching *Caching) invalidate(ctx context.Context, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	// 先阻止正在执行的查询写回，再删除存储的数据
+	caching.inflightQueries.cancel(keys...)
+	caching.sentinelGroup.Delete(keys...)
+
+	return deleteKeys(ctx, caching.Storage, withCompanionKeys(keys, caching.NotFoundTTL > 0, caching.SoftTTL > 0))
+}
+
+// InvalidatePrefix 使key具有指定前缀的全部缓存失效。
+// Storage需要实现PrefixDeleter接口。
+func (caching *Caching) InvalidatePrefix(ctx context.Context, prefix string) error {
+	deleter, ok := caching.Storage.(PrefixDeleter)
+	if !ok {
+		return ErrDeleteNotSupported
+	}
+
+	prefix, err := caching.storageKey(ctx, prefix)
+	if err == errNoGeneration {
+		// 不能取得命名空间的世代，容忍错误或者熔断期间，跳过
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	keys := caching.inflightQueries.cancelPrefix(prefix)
+	caching.sentinelGroup.Delete(keys...)
+
+	return deleter.DeletePrefix(ctx, prefix)
+}
+
+// Invalidate 使缓存失效。删除MStorage存储的数据，并阻止正在执行的查询将结果写回MStorage。
+// MStorage需要实现MDeleter接口或者Deleter接口。
+func (mcaching *MCaching) Invalidate(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	keys, err := mcaching.storageKeys(ctx, keys)
+	if err == errNoGeneration {
+		// 不能取得命名空间的世代，容忍错误或者熔断期间，跳过
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return mcaching.invalidate(ctx, keys)
+}
+
+// invalidate 使MStorage使用的key失效。
+func (mcaching *MCaching) invalidate(ctx context.Context, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	// 先阻止正在执行的查询写回，再删除存储的数据
+	mcaching.inflightQueries.cancel(keys...)
+	mcaching.sentinelGroup.Delete(keys...)
+	mcaching.refreshAhead.remove(keys...)
+
+	return deleteKeys(ctx, mcaching.MStorage, withCompanionKeys(keys, mcaching.NotFoundTTL > 0, false))
+}
+
+// InvalidatePrefix 使key具有指定前缀的全部缓存失效。
+// MStorage需要实现PrefixDeleter接口。
+func (mcaching *MCaching) InvalidatePrefix(ctx context.Context, prefix string) error {
+	deleter, ok := mcaching.MStorage.(PrefixDeleter)
+	if !ok {
+		return ErrDeleteNotSupported
+	}
+
+	prefixes, err := mcaching.storageKeys(ctx, []string{prefix})
+	if err == errNoGeneration {
+		// 不能取得命名空间的世代，容忍错误或者熔断期间，跳过
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	prefix = prefixes[0]
+	keys := mcaching.inflightQueries.cancelPrefix(prefix)
+	mcaching.sentinelGroup.Delete(keys...)
+	mcaching.refreshAhead.removePrefix(prefix)
+
+	return deleter.DeletePrefix(ctx, prefix)
+}
+
+// withCompanionKeys 加上数据key对应的标记key。
+func withCompanionKeys(keys []string, notFound, fresh bool) []string {
+	if !notFound && !fresh {
+		return keys
+	}
+
+	allKeys := make([]string, 0, len(keys)*3)
+	allKeys = append(allKeys, keys...)
+	for _, key := range keys {
+		if notFound {
+			allKeys = append(allKeys, notFoundKey(key))
+		}
+		if fresh {
+			allKeys = append(allKeys, freshKey(key))
+		}
+	}
+	return allKeys
+}
+
+// deleteKeys 通过可选的MDeleter接口或者Deleter接口删除存储的数据。
+func deleteKeys(ctx context.Context, storage interface{}, keys []string) error {
+	switch deleter := storage.(type) {
+	case MDeleter:
+		return deleter.MDelete(ctx, keys)
+	case Deleter:
+		for _, key := range keys {
+			err := deleter.Delete(ctx, key)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return ErrDeleteNotSupported
+	}
+}
+
+// inflightQuery 一次正在执行的查询。
+type inflightQuery struct {
+	mu sync.Mutex
+
+	// canceledKeys 已经失效的key。这些key的查询结果不再写回存储。
+	canceledKeys map[string]bool
+
+	// canceledTags 已经失效的标签。带有这些标签的查询结果不再写回存储。
+	canceledTags map[string]bool
+}
+
+// inflightQueryGroup 正在执行的查询。用于在失效时，阻止查询结果写回存储。
+type inflightQueryGroup struct {
+	mu sync.Mutex
+
+	queries map[string]map[*inflightQuery]struct{}
+}
+
+// begin 登记查询。查询结束后，需要调用end。
+func (group *inflightQueryGroup) begin(keys ...string) *inflightQuery {
+	query := &inflightQuery{}
+
+	group.mu.Lock()
+	defer group.mu.Unlock()
+	if group.queries == nil {
+		group.queries = make(map[string]map[*inflightQuery]struct{})
+	}
+	for _, key := range keys {
+		queries := group.queries[key]
+		if queries == nil {
+			queries = make(map[*inflightQuery]struct{})
+			group.queries[key] = queries
+		}
+		queries[query] = struct{}{}
+	}
+	return query
+}
+
+// end 注销查询。
+func (group *inflightQueryGroup) end(query *inflightQuery, keys ...string) {
+	group.mu.Lock()
+	defer group.mu.Unlock()
+	for _, key := range keys {
+		queries := group.queries[key]
+		delete(queries, query)
+		if len(queries) == 0 {
+			delete(group.queries, key)
+		}
+	}
+}
+
+// cancel 标记查询结果失效。如果查询结果正在写回存储，等待写完。
+func (group *inflightQueryGroup) cancel(keys ...string) {
+	var queries = make(map[*inflightQuery][]string)
+	group.mu.Lock()
+	for _, key := range keys {
+		for query := range group.queries[key] {
+			queries[query] = append(queries[query], key)
+		}
+	}
+	group.mu.Unlock()
+
+	// 不持有group的锁等待写完
+	for query, keys := range queries {
+		query.cancel(keys...)
+	}
+}
+
+// cancelPrefix 标记key具有指定前缀的查询结果失效。返回标记的key。
+func (group *inflightQueryGroup) cancelPrefix(prefix string) (keys []string) {
+	group.mu.Lock()
+	for key := range group.queries {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	group.mu.Unlock()
+
+	group.cancel(keys...)
+	return keys
+}
+
+// cancelTag 标记带有标签的查询结果失效。
+// 查询结束前不知道查询结果的标签，标记全部正在执行的查询。
+func (group *inflightQueryGroup) cancelTag(tag string) {
+	var queries = make(map[*inflightQuery]struct{})
+	group.mu.Lock()
+	for _, keyQueries := range group.queries {
+		for query := range keyQueries {
+			queries[query] = struct{}{}
+		}
+	}
+	group.mu.Unlock()
+
+	// 不持有group的锁等待写完
+	for query := range queries {
+		query.cancelTag(tag)
+	}
+}
+
+// cancelTag 标记带有标签的查询结果失效。
+func (query *inflightQuery) cancelTag(tag string) {
+	query.mu.Lock()
+	defer query.mu.Unlock()
+	if query.canceledTags == nil {
+		query.canceledTags = make(map[string]bool)
+	}
+	query.canceledTags[tag] = true
+}
+
+// tagCanceled 查询结果是否带有已失效的标签。只能在write的f中调用。
+func (query *inflightQuery) tagCanceled(value interface{}) bool {
+	if len(query.canceledTags) == 0 {
+		return false
+	}
+	for _, tag := range getValueTags(value) {
+		if query.canceledTags[tag] {
+			return true
+		}
+	}
+	return false
+}
+
+// cancel 标记查询结果失效。
+func (query *inflightQuery) cancel(keys ...string) {
+	query.mu.Lock()
+	defer query.mu.Unlock()
+	if query.canceledKeys == nil {
+		query.canceledKeys = make(map[string]bool)
+	}
+	for _, key := range keys {
+		query.canceledKeys[key] = true
+	}
+}
+
+// write 执行写回存储的函数。写回期间，失效操作会等待。
+// f的参数是没有失效的key的下标。
+func (query *inflightQuery) write(keys []string, f func(validIndexes []int) error) error {
+	query.mu.Lock()
+	defer query.mu.Unlock()
+
+	validIndexes := make([]int, 0, len(keys))
+	for index, key := range keys {
+		if !query.canceledKeys[key] {
+			validIndexes = append(validIndexes, index)
+		}
+	}
+	return f(validIndexes)
+}
@@ -0,0 +1,146 @@
+package restcache
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/wencan/fastrest/restcache/lrucache"
+	"github.com/wencan/fastrest/restcache/mock_restcache"
+)
+
+func TestCaching_Invalidate(t *testing.T) {
+	var version int
+	caching := Caching{
+		Storage: lrucache.NewLRUCache(1000, 10),
+		Query: func(ctx context.Context, destPtr, args interface{}) (found bool, err error) {
+			version++
+			if version == 2 {
+				return false, nil
+			}
+			*destPtr.(*int) = version
+			return true, nil
+		},
+		TTLRange:    [2]time.Duration{time.Minute * 4, time.Minute * 6},
+		SentinelTTL: time.Minute,
+		NotFoundTTL: time.Minute,
+	}
+
+	var resp int
+	found, err := caching.Get(context.TODO(), &resp, "key", nil)
+	if assert.Nil(t, err) && assert.True(t, found) {
+		assert.Equal(t, 1, resp)
+	}
+
+	// 失效后，重新查询。不受哨兵影响
+	err = caching.Invalidate(context.TODO(), "key")
+	assert.Nil(t, err)
+	found, err = caching.Get(context.TODO(), &resp, "key", nil)
+	if assert.Nil(t, err) {
+		assert.False(t, found)
+	}
+
+	// 没找到的标记也被删除
+	err = caching.Invalidate(context.TODO(), "key")
+	assert.Nil(t, err)
+	found, err = caching.Get(context.TODO(), &resp, "key", nil)
+	if assert.Nil(t, err) && assert.True(t, found) {
+		assert.Equal(t, 3, resp)
+	}
+}
+
+func TestCaching_InvalidateInflight(t *testing.T) {
+	storage := lrucache.NewLRUCache(1000, 10)
+	querying := make(chan struct{})
+	invalidated := make(chan struct{})
+	caching := Caching{
+		Storage: storage,
+		Query: func(ctx context.Context, destPtr, args interface{}) (found bool, err error) {
+			close(querying)
+			<-invalidated
+			*destPtr.(*string) = "stale"
+			return true, nil
+		},
+		TTLRange:    [2]time.Duration{time.Minute * 4, time.Minute * 6},
+		SentinelTTL: time.Minute,
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		var resp string
+		found, err := caching.Get(context.TODO(), &resp, "key", nil)
+		if assert.Nil(t, err) && assert.True(t, found) {
+			assert.Equal(t, "stale", resp)
+		}
+	}()
+
+	<-querying
+	err := caching.Invalidate(context.TODO(), "key")
+	assert.Nil(t, err)
+	close(invalidated)
+	wg.Wait()
+
+	// 查询结果没有写回
+	var resp string
+	found, err := storage.Get(context.TODO(), "key", &resp)
+	if assert.Nil(t, err) {
+		assert.False(t, found)
+	}
+}
+
+func TestCaching_InvalidateNotSupported(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	caching := Caching{Storage: mock_restcache.NewMockStorage(ctrl)}
+	err := caching.Invalidate(context.TODO(), "key")
+	assert.Equal(t, ErrDeleteNotSupported, err)
+
+	// 只支持删除单个key
+	lruCache := lrucache.NewLRUCache(1000, 10)
+	caching = Caching{Storage: struct {
+		Storage
+		Deleter
+	}{lruCache, lruCache}}
+	err = caching.InvalidatePrefix(context.TODO(), "key")
+	assert.Equal(t, ErrDeleteNotSupported, err)
+}
+
+func TestMCaching_Invalidate(t *testing.T) {
+	var queried [][]string
+	mcaching := MCaching{
+		MStorage: lrucache.NewLRUCache(1000, 10),
+		MQuery: func(ctx context.Context, destSlicePtr, argsSlice interface{}) (missIndexes []int, err error) {
+			reqs := argsSlice.([]string)
+			queried = append(queried, reqs)
+			for _, req := range reqs {
+				*destSlicePtr.(*[]string) = append(*destSlicePtr.(*[]string), "echo: "+req)
+			}
+			return nil, nil
+		},
+		TTLRange:    [2]time.Duration{time.Minute * 4, time.Minute * 6},
+		SentinelTTL: time.Minute,
+	}
+
+	keys := []string{"key_1", "key_2", "key_3"}
+	for i := 0; i < 2; i++ {
+		var resps []string
+		missIndexes, err := mcaching.MGet(context.TODO(), &resps, keys, keys)
+		if assert.Nil(t, err) {
+			assert.Empty(t, missIndexes)
+			assert.Equal(t, []string{"echo: key_1", "echo: key_2", "echo: key_3"}, resps)
+		}
+
+		if i == 0 {
+			err = mcaching.Invalidate(context.TODO(), "key_1", "key_3")
+			assert.Nil(t, err)
+		}
+	}
+	assert.Equal(t, [][]string{{"key_1", "key_2", "key_3"}, {"key_1", "key_3"}}, queried)
+}
@@ -0,0 +1,202 @@
+package restcache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultLoaderWait 默认的收集查询的时间。
+	defaultLoaderWait = time.Millisecond
+)
+
+// Loader 将短时间内的单个key的查询，合并为一次MCaching.MGet批量查询。DataLoader风格。
+// 用于避免N+1查询。零值不可用，需要设置MCaching。
+type Loader struct {
+	// MCaching 批量缓存。
+	MCaching *MCaching
+
+	// Wait 收集查询的最长时间。从收到一批的第一个查询开始计时。默认为1ms。
+	Wait time.Duration
+
+	// MaxBatch 每批最多的key数。达到后立即批量查询。0表示不限制。
+	MaxBatch int
+
+	mu sync.Mutex
+
+	// batches 正在收集的批次。按数据类型和参数类型区分。
+	batches map[loaderBatchKey]*loaderBatch
+}
+
+// loaderBatchKey 批次的类型。
+type loaderBatchKey struct {
+	destType reflect.Type
+
+	argsType reflect.Type
+}
+
+// loaderBatch 一批查询。
+type loaderBatch struct {
+	// ctx 批次第一个查询的ctx。批量查询使用其中的值，不受其取消的影响。
+	ctx context.Context
+
+	keys []string
+
+	argsValues []reflect.Value
+
+	// indexes key -> 在keys中的下标。重复的key只查询一次。
+	indexes map[string]int
+
+	timer *time.Timer
+
+	// dispatched 是否已经开始查询。
+	dispatched bool
+
+	// done 查询结束后关闭。
+	done chan struct{}
+
+	// values 各个key的查询结果。没找到的为无效值。
+	values []reflect.Value
+
+	err error
+}
+
+// interfaceType interface{}类型。参数为nil时，参数序列的元素类型。
+var interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+// Load 查询。逻辑同Caching.Get，destPtr为结果对象指针，key为缓存key，args为查询函数参数。
+// 同一批次的查询，通过一次MCaching.MGet批量查询，参数序列的元素类型为args的类型。
+// 批量查询使用批次第一个查询的ctx中的值，但不受ctx取消的影响，ctx结束时，Load返回ctx的错误。
+func (loader *Loader) Load(ctx context.Context, destPtr interface{}, key string, args interface{}) (found bool, err error) {
+	batchKey := loaderBatchKey{destType: reflect.TypeOf(destPtr).Elem(), argsType: interfaceType}
+	if args != nil {
+		batchKey.argsType = reflect.TypeOf(args)
+	}
+
+	loader.mu.Lock()
+	if loader.batches == nil {
+		loader.batches = make(map[loaderBatchKey]*loaderBatch)
+	}
+	batch := loader.batches[batchKey]
+	if batch == nil {
+		batch = &loaderBatch{
+			ctx:     ctx,
+			indexes: make(map[string]int),
+			done:    make(chan struct{}),
+		}
+		loader.batches[batchKey] = batch
+		wait := loader.Wait
+		if wait <= 0 {
+			wait = defaultLoaderWait
+		}
+		batch.timer = time.AfterFunc(wait, func() {
+			loader.dispatch(batchKey, batch)
+		})
+	}
+	index, ok := batch.indexes[key]
+	if !ok {
+		index = len(batch.keys)
+		batch.indexes[key] = index
+		batch.keys = append(batch.keys, key)
+		argsValue := reflect.New(batchKey.argsType).Elem()
+		if args != nil {
+			argsValue.Set(reflect.ValueOf(args))
+		}
+		batch.argsValues = append(batch.argsValues, argsValue)
+	}
+	full := loader.MaxBatch > 0 && len(batch.keys) >= loader.MaxBatch
+	if full {
+		// 后面的查询进入新的批次
+		delete(loader.batches, batchKey)
+	}
+	loader.mu.Unlock()
+
+	if full {
+		// 达到最多的key数，立即查询
+		batch.timer.Stop()
+		go loader.dispatch(batchKey, batch)
+	}
+
+	select {
+	case <-batch.done:
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+
+	if batch.err != nil {
+		return false, batch.err
+	}
+	value := batch.values[index]
+	if !value.IsValid() {
+		return false, nil
+	}
+	reflect.ValueOf(destPtr).Elem().Set(value)
+	return true, nil
+}
+
+// dispatch 批量查询，并通知各个查询。只执行一次。
+func (loader *Loader) dispatch(batchKey loaderBatchKey, batch *loaderBatch) {
+	loader.mu.Lock()
+	if batch.dispatched {
+		loader.mu.Unlock()
+		return
+	}
+	batch.dispatched = true
+	if loader.batches[batchKey] == batch {
+		delete(loader.batches, batchKey)
+	}
+	loader.mu.Unlock()
+	defer close(batch.done)
+
+	destSlicePtrValue := reflect.New(reflect.SliceOf(batchKey.destType))
+	argsSliceValue := reflect.MakeSlice(reflect.SliceOf(batchKey.argsType), 0, len(batch.argsValues))
+	argsSliceValue = reflect.Append(argsSliceValue, batch.argsValues...)
+
+	// 各个查询的ctx可能提前结束，不影响批量查询
+	missIndexes, err := loader.MCaching.MGet(valueOnlyContext{batch.ctx}, destSlicePtrValue.Interface(), batch.keys, argsSliceValue.Interface())
+	if err != nil {
+		batch.err = err
+		return
+	}
+
+	// 分发结果
+	batch.values = make([]reflect.Value, len(batch.keys))
+	destSliceValue := destSlicePtrValue.Elem()
+	var destCount, missCount int
+	for index := range batch.keys {
+		if missCount < len(missIndexes) && missIndexes[missCount] == index {
+			missCount++
+			continue
+		}
+		if destCount >= destSliceValue.Len() {
+			batch.err = errors.New("not enough results")
+			return
+		}
+		batch.values[index] = destSliceValue.Index(destCount)
+		destCount++
+	}
+}
+
+// valueOnlyContext 只保留父ctx中的值，没有截止时间，也不会被取消。
+type valueOnlyContext struct {
+	parent context.Context
+}
+
+func (valueOnlyContext) Deadline() (deadline time.Time, ok bool) {
+	return time.Time{}, false
+}
+
+func (valueOnlyContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (valueOnlyContext) Err() error {
+	return nil
+}
+
+func (ctx valueOnlyContext) Value(key interface{}) interface{} {
+	return ctx.parent.Value(key)
+}
@@ -0,0 +1,166 @@
+package restcache
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wencan/fastrest/restcache/lrucache"
+)
+
+func TestLoader(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]int
+	loader := Loader{
+		MCaching: &MCaching{
+			MStorage: lrucache.NewLRUCache(1000, 10),
+			MQuery: func(ctx context.Context, destSlicePtr, argsSlice interface{}) (missIndexes []int, err error) {
+				mu.Lock()
+				batches = append(batches, argsSlice.([]int))
+				mu.Unlock()
+				for index, req := range argsSlice.([]int) {
+					if req%3 == 0 {
+						missIndexes = append(missIndexes, index)
+						continue
+					}
+					*destSlicePtr.(*[]string) = append(*destSlicePtr.(*[]string), "echo: "+strconv.Itoa(req))
+				}
+				return missIndexes, nil
+			},
+			TTLRange: [2]time.Duration{time.Minute * 4, time.Minute * 6},
+		},
+		Wait: time.Millisecond * 50,
+	}
+
+	// 重复的key只查询一次
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(req int) {
+			defer wg.Done()
+			var resp string
+			found, err := loader.Load(context.TODO(), &resp, strconv.Itoa(req), req)
+			if assert.Nil(t, err) {
+				if req%3 == 0 {
+					assert.False(t, found)
+				} else if assert.True(t, found) {
+					assert.Equal(t, "echo: "+strconv.Itoa(req), resp)
+				}
+			}
+		}(i % 10)
+	}
+	wg.Wait()
+	if assert.Len(t, batches, 1) {
+		assert.ElementsMatch(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, batches[0])
+	}
+}
+
+func TestLoader_MaxBatch(t *testing.T) {
+	var mu sync.Mutex
+	var batchSizes []int
+	loader := Loader{
+		MCaching: &MCaching{
+			MStorage: lrucache.NewLRUCache(1000, 10),
+			MQuery: func(ctx context.Context, destSlicePtr, argsSlice interface{}) (missIndexes []int, err error) {
+				mu.Lock()
+				batchSizes = append(batchSizes, len(argsSlice.([]string)))
+				mu.Unlock()
+				for _, req := range argsSlice.([]string) {
+					*destSlicePtr.(*[]string) = append(*destSlicePtr.(*[]string), "echo: "+req)
+				}
+				return nil, nil
+			},
+			TTLRange: [2]time.Duration{time.Minute * 4, time.Minute * 6},
+		},
+		Wait:     time.Second,
+		MaxBatch: 5,
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(req string) {
+			defer wg.Done()
+			var resp string
+			found, err := loader.Load(context.TODO(), &resp, req, req)
+			if assert.Nil(t, err) && assert.True(t, found) {
+				assert.Equal(t, "echo: "+req, resp)
+			}
+		}(strconv.Itoa(i))
+	}
+	wg.Wait()
+	// 达到MaxBatch，不等待Wait
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, []int{5, 5}, batchSizes)
+}
+
+func TestLoader_ContextDone(t *testing.T) {
+	loader := Loader{
+		MCaching: &MCaching{
+			MStorage: lrucache.NewLRUCache(1000, 10),
+			MQuery: func(ctx context.Context, destSlicePtr, argsSlice interface{}) (missIndexes []int, err error) {
+				*destSlicePtr.(*[]string) = append(*destSlicePtr.(*[]string), "echo")
+				return nil, nil
+			},
+			TTLRange: [2]time.Duration{time.Minute * 4, time.Minute * 6},
+		},
+		Wait: time.Millisecond * 200,
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Millisecond*50)
+	defer cancel()
+	var resp string
+	_, err := loader.Load(ctx, &resp, "key", "req")
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestLoader_ContextValues(t *testing.T) {
+	type testKey struct{}
+	var count int64
+	loader := Loader{
+		MCaching: &MCaching{
+			MStorage: lrucache.NewLRUCache(1000, 10),
+			MQuery: func(ctx context.Context, destSlicePtr, argsSlice interface{}) (missIndexes []int, err error) {
+				n := atomic.AddInt64(&count, 1)
+				// 批量查询带有第一个查询的ctx中的值，但不受其取消的影响
+				assert.Equal(t, "value", ctx.Value(testKey{}))
+				assert.Nil(t, ctx.Err())
+				*destSlicePtr.(*[]string) = append(*destSlicePtr.(*[]string), "echo: "+strconv.FormatInt(n, 10))
+				return nil, nil
+			},
+			TTLRange: [2]time.Duration{time.Minute * 4, time.Minute * 6},
+		},
+		Wait: time.Millisecond * 50,
+	}
+
+	ctx := context.WithValue(context.TODO(), testKey{}, "value")
+	var resp string
+	found, err := loader.Load(ctx, &resp, "key", "req")
+	if assert.Nil(t, err) && assert.True(t, found) {
+		assert.Equal(t, "echo: 1", resp)
+	}
+
+	// 绕过缓存
+	found, err = loader.Load(WithBypass(ctx), &resp, "key", "req")
+	if assert.Nil(t, err) && assert.True(t, found) {
+		assert.Equal(t, "echo: 2", resp)
+	}
+
+	// 取消的ctx
+	cancelCtx, cancel := context.WithCancel(WithRefresh(ctx))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := loader.Load(cancelCtx, &resp, "key", "req")
+		assert.Equal(t, context.Canceled, err)
+	}()
+	cancel()
+	<-done
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, int64(3), atomic.LoadInt64(&count))
+}
@@ -0,0 +1,224 @@
+package restcache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/wencan/fastrest/restutils"
+)
+
+// Locker 分布式锁接口。用于多个进程之间，同一时间只有一个进程查询同key的数据。
+type Locker interface {
+	// TryLock 尝试获得锁，不等待。获得锁返回locked为true，和解锁需要的token。
+	// 锁在TTL后自动释放。
+	TryLock(ctx context.Context, key string, TTL time.Duration) (token string, locked bool, err error)
+
+	// Unlock 释放锁。token为TryLock返回的token。锁已经释放或者被其它持有者获得，不返回错误。
+	Unlock(ctx context.Context, key string, token string) error
+}
+
+const (
+	// lockKeySuffix 锁key的后缀。
+	lockKeySuffix = "#lock"
+
+	// defaultLockTTL 默认的锁生存时间。
+	defaultLockTTL = time.Second * 5
+
+	// defaultLockWait 默认的等待查询结果的最长时间。
+	defaultLockWait = time.Second
+
+	// lockPollInterval 等待查询结果时，轮询存储的间隔。
+	lockPollInterval = time.Millisecond * 50
+)
+
+// errStopWaiting 停止等待查询结果。比如熔断期间。
+var errStopWaiting = errors.New("stop waiting")
+
+// lockKey 返回数据key对应的锁key。
+func lockKey(key string) string {
+	return key + lockKeySuffix
+}
+
+// lockOrWait 尝试获得keys的分布式锁。
+// 没获得锁的，在lockWait时间内轮询load，等待持有锁的进程查询并存储。load的参数是仍在等待的下标，返回仍未加载的下标；load返回错误，停止等待。
+// 获得锁的，会先调用一次load再检查存储，已加载的不需要再查询。
+// 返回需要自己查询的下标（获得锁且未加载的、锁出错的、等待超时的），和释放已获得的锁的函数。
+// 调用者应该在存储查询结果之后再释放锁。
+func lockOrWait(ctx context.Context, locker Locker, keys []string, lockTTL, lockWait time.Duration, load func(indexes []int) (remainIndexes []int, err error)) (queryIndexes []int, unlock func()) {
+	if lockTTL <= 0 {
+		lockTTL = defaultLockTTL
+	}
+	if lockWait <= 0 {
+		lockWait = defaultLockWait
+	}
+
+	type lockedKey struct {
+		key   string
+		token string
+	}
+	var lockedKeys []lockedKey
+	var lockedIndexes []int
+	var waitIndexes []int
+	for index, key := range keys {
+		token, locked, err := locker.TryLock(ctx, lockKey(key), lockTTL)
+		if err != nil || locked {
+			// 获得锁，或者锁出错，自己查询
+			queryIndexes = append(queryIndexes, index)
+			if locked {
+				lockedKeys = append(lockedKeys, lockedKey{key: lockKey(key), token: token})
+				lockedIndexes = append(lockedIndexes, index)
+			}
+			continue
+		}
+		waitIndexes = append(waitIndexes, index)
+	}
+	if len(lockedIndexes) > 0 {
+		// 获得锁之前，上一个持有锁的进程可能刚查询并存储完成，再检查一次存储
+		remainIndexes, err := load(lockedIndexes)
+		if err == nil && len(remainIndexes) != len(lockedIndexes) {
+			var indexes []int
+			for _, index := range queryIndexes {
+				if !restutils.IntSliceContains(lockedIndexes, index) || restutils.IntSliceContains(remainIndexes, index) {
+					indexes = append(indexes, index)
+				}
+			}
+			queryIndexes = indexes
+		}
+	}
+	unlock = func() {
+		// 不受调用者的ctx取消影响。释放失败的，等待自动释放
+		for _, locked := range lockedKeys {
+			locker.Unlock(context.Background(), locked.key, locked.token)
+		}
+	}
+
+	// 等待持有锁的进程查询并存储
+	timer := time.NewTimer(lockWait)
+	defer timer.Stop()
+	ticker := time.NewTicker(lockPollInterval)
+	defer ticker.Stop()
+	for len(waitIndexes) > 0 {
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+		case <-ticker.C:
+			remainIndexes, err := load(waitIndexes)
+			if err == nil {
+				waitIndexes = remainIndexes
+				continue
+			}
+		}
+		break
+	}
+
+	if len(waitIndexes) > 0 {
+		// 等待超时，自己查询
+		queryIndexes = append(queryIndexes, waitIndexes...)
+		sort.Ints(queryIndexes)
+	}
+	return queryIndexes, unlock
+}
+
+// heldLocks 收集已获得的分布式锁的释放函数。用于在存储查询结果之后才释放锁。并发安全。
+// 同时收集从存储加载到的（其它进程查询并存储的）key，这些key不需要再存储。
+type heldLocks struct {
+	mu sync.Mutex
+
+	unlocks []func()
+
+	// loadedKeys 从存储加载到的key。
+	loadedKeys map[string]bool
+}
+
+// add 登记释放函数。
+func (locks *heldLocks) add(unlock func()) {
+	locks.mu.Lock()
+	defer locks.mu.Unlock()
+	locks.unlocks = append(locks.unlocks, unlock)
+}
+
+// addLoaded 登记从存储加载到的key。
+func (locks *heldLocks) addLoaded(key string) {
+	locks.mu.Lock()
+	defer locks.mu.Unlock()
+	if locks.loadedKeys == nil {
+		locks.loadedKeys = make(map[string]bool)
+	}
+	locks.loadedKeys[key] = true
+}
+
+// isLoaded key是否是从存储加载到的。
+func (locks *heldLocks) isLoaded(key string) bool {
+	locks.mu.Lock()
+	defer locks.mu.Unlock()
+	return locks.loadedKeys[key]
+}
+
+// release 释放全部登记的锁。
+func (locks *heldLocks) release() {
+	locks.mu.Lock()
+	unlocks := locks.unlocks
+	locks.unlocks = nil
+	locks.mu.Unlock()
+	for _, unlock := range unlocks {
+		unlock()
+	}
+}
+
+// memoryLock 进程内的锁。
+type memoryLock struct {
+	token string
+
+	expire time.Time
+}
+
+// MemoryLocker 进程内的Locker实现。一般用于测试。
+type MemoryLocker struct {
+	mu sync.Mutex
+
+	locks map[string]memoryLock
+}
+
+// TryLock 实现Locker接口。
+func (locker *MemoryLocker) TryLock(ctx context.Context, key string, TTL time.Duration) (token string, locked bool, err error) {
+	token, err = NewLockToken()
+	if err != nil {
+		return "", false, err
+	}
+
+	locker.mu.Lock()
+	defer locker.mu.Unlock()
+	if locker.locks == nil {
+		locker.locks = make(map[string]memoryLock)
+	}
+	if lock, ok := locker.locks[key]; ok && time.Now().Before(lock.expire) {
+		return "", false, nil
+	}
+	locker.locks[key] = memoryLock{token: token, expire: time.Now().Add(TTL)}
+	return token, true, nil
+}
+
+// Unlock 实现Locker接口。
+func (locker *MemoryLocker) Unlock(ctx context.Context, key string, token string) error {
+	locker.mu.Lock()
+	defer locker.mu.Unlock()
+	if lock, ok := locker.locks[key]; ok && lock.token == token {
+		delete(locker.locks, key)
+	}
+	return nil
+}
+
+// NewLockToken 生成随机的锁token。用于Locker实现。
+func NewLockToken() (string, error) {
+	var b [16]byte
+	_, err := rand.Read(b[:])
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
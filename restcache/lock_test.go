@@ -0,0 +1,213 @@
+package restcache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wencan/fastrest/restcache/lrucache"
+)
+
+func TestMemoryLocker(t *testing.T) {
+	var locker MemoryLocker
+
+	token, locked, err := locker.TryLock(context.TODO(), "key", time.Millisecond*100)
+	if assert.Nil(t, err) {
+		assert.True(t, locked)
+	}
+	_, locked, err = locker.TryLock(context.TODO(), "key", time.Millisecond*100)
+	if assert.Nil(t, err) {
+		assert.False(t, locked)
+	}
+
+	// 错误的token不能释放
+	locker.Unlock(context.TODO(), "key", "wrong")
+	_, locked, err = locker.TryLock(context.TODO(), "key", time.Millisecond*100)
+	if assert.Nil(t, err) {
+		assert.False(t, locked)
+	}
+
+	locker.Unlock(context.TODO(), "key", token)
+	_, locked, err = locker.TryLock(context.TODO(), "key", time.Millisecond*100)
+	if assert.Nil(t, err) {
+		assert.True(t, locked)
+	}
+
+	// 自动释放
+	time.Sleep(time.Millisecond * 200)
+	_, locked, err = locker.TryLock(context.TODO(), "key", time.Millisecond*100)
+	if assert.Nil(t, err) {
+		assert.True(t, locked)
+	}
+}
+
+func TestCaching_Locker(t *testing.T) {
+	// 多个Caching共享Storage和Locker，模拟多个进程
+	storage := lrucache.NewLRUCache(1000, 10)
+	locker := &MemoryLocker{}
+	var queryCount int32
+	query := func(ctx context.Context, destPtr, args interface{}) (found bool, err error) {
+		atomic.AddInt32(&queryCount, 1)
+		time.Sleep(time.Millisecond * 200)
+		if args.(string) == "" {
+			return false, nil
+		}
+		*destPtr.(*string) = "echo: " + args.(string)
+		return true, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		caching := &Caching{
+			Storage:     storage,
+			Query:       query,
+			TTLRange:    [2]time.Duration{time.Minute * 4, time.Minute * 6},
+			SentinelTTL: time.Second,
+			NotFoundTTL: time.Minute,
+			Locker:      locker,
+			LockWait:    time.Second,
+		}
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			var resp string
+			found, err := caching.Get(context.TODO(), &resp, "key", "key")
+			if assert.Nil(t, err) && assert.True(t, found) {
+				assert.Equal(t, "echo: key", resp)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			var resp string
+			found, err := caching.Get(context.TODO(), &resp, "notfound", "")
+			if assert.Nil(t, err) {
+				assert.False(t, found)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), queryCount)
+}
+
+func TestMCaching_Locker(t *testing.T) {
+	// 多个MCaching共享MStorage和Locker，模拟多个进程
+	storage := lrucache.NewLRUCache(1000, 10)
+	locker := &MemoryLocker{}
+	var mu sync.Mutex
+	var queried []string
+	mquery := func(ctx context.Context, destSlicePtr, argsSlice interface{}) (missIndexes []int, err error) {
+		mu.Lock()
+		queried = append(queried, argsSlice.([]string)...)
+		mu.Unlock()
+		time.Sleep(time.Millisecond * 200)
+		for index, req := range argsSlice.([]string) {
+			if req == "" {
+				missIndexes = append(missIndexes, index)
+				continue
+			}
+			*destSlicePtr.(*[]string) = append(*destSlicePtr.(*[]string), "echo: "+req)
+		}
+		return missIndexes, nil
+	}
+
+	keys := []string{"key_1", "notfound", "key_3"}
+	argsSlice := []string{"1", "", "3"}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		mcaching := &MCaching{
+			MStorage:    storage,
+			MQuery:      mquery,
+			TTLRange:    [2]time.Duration{time.Minute * 4, time.Minute * 6},
+			SentinelTTL: time.Second,
+			NotFoundTTL: time.Minute,
+			Locker:      locker,
+			LockWait:    time.Second,
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var resps []string
+			missIndexes, err := mcaching.MGet(context.TODO(), &resps, keys, argsSlice)
+			if assert.Nil(t, err) {
+				assert.Equal(t, []int{1}, missIndexes)
+				assert.Equal(t, []string{"echo: 1", "echo: 3"}, resps)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.ElementsMatch(t, []string{"1", "", "3"}, queried)
+}
+
+// slowMSetStorage MSet较慢的存储。
+type slowMSetStorage struct {
+	*lrucache.LRUCache
+
+	delay time.Duration
+}
+
+func (storage slowMSetStorage) MSet(ctx context.Context, keys []string, valueSlice interface{}, ttl time.Duration) error {
+	time.Sleep(storage.delay)
+	return storage.LRUCache.MSet(ctx, keys, valueSlice, ttl)
+}
+
+func TestMCaching_LockerHeldUntilStored(t *testing.T) {
+	// 两个MCaching共享MStorage和Locker，模拟两个进程
+	// 第二个在第一个查询完成、存储完成之前开始，不应该重复查询
+	storage := slowMSetStorage{LRUCache: lrucache.NewLRUCache(1000, 10), delay: time.Millisecond * 200}
+	locker := &MemoryLocker{}
+	var queryCount int32
+	queried := make(chan struct{})
+	mquery := func(ctx context.Context, destSlicePtr, argsSlice interface{}) (missIndexes []int, err error) {
+		if atomic.AddInt32(&queryCount, 1) == 1 {
+			defer close(queried)
+		}
+		for _, req := range argsSlice.([]string) {
+			*destSlicePtr.(*[]string) = append(*destSlicePtr.(*[]string), "echo: "+req)
+		}
+		return nil, nil
+	}
+	newMCaching := func() *MCaching {
+		return &MCaching{
+			MStorage:    storage,
+			MQuery:      mquery,
+			TTLRange:    [2]time.Duration{time.Minute * 4, time.Minute * 6},
+			SentinelTTL: time.Second,
+			Locker:      locker,
+			LockWait:    time.Second,
+		}
+	}
+
+	keys := []string{"key_1", "key_2"}
+	argsSlice := []string{"1", "2"}
+	var wg sync.WaitGroup
+	for _, mcaching := range []*MCaching{newMCaching(), newMCaching()} {
+		wg.Add(1)
+		go func(mcaching *MCaching) {
+			defer wg.Done()
+			var resps []string
+			missIndexes, err := mcaching.MGet(context.TODO(), &resps, keys, argsSlice)
+			if assert.Nil(t, err) {
+				assert.Empty(t, missIndexes)
+				assert.Equal(t, []string{"echo: 1", "echo: 2"}, resps)
+			}
+		}(mcaching)
+		<-queried
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), queryCount)
+}
+
+func TestLockOrWait_RecheckAfterLocked(t *testing.T) {
+	// 获得锁之后再检查一次存储，已经存储了的不需要查询
+	locker := &MemoryLocker{}
+	keys := []string{"key_1", "key_2", "key_3"}
+	queryIndexes, unlock := lockOrWait(context.TODO(), locker, keys, time.Second, time.Second, func(indexes []int) ([]int, error) {
+		assert.Equal(t, []int{0, 1, 2}, indexes)
+		return []int{1}, nil
+	})
+	defer unlock()
+	assert.Equal(t, []int{1}, queryIndexes)
+}
@@ -4,72 +4,313 @@ import (
 	"context"
 	"errors"
 	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/wencan/fastrest/restutils"
-	"github.com/wencan/gox/xsync"
 )
 
 type lruEntry struct {
+	key string
+
 	value interface{}
 
-	expire time.Time
+	// expireAt 过期时间戳。单位秒。
+	expireAt float64
+
+	// size 占用的字节数。
+	size int64
 }
 
 var lruEntryPool = sync.Pool{New: func() interface{} {
 	return &lruEntry{}
 }}
 
+// EvictionReason 数据项被移除的原因。
+type EvictionReason int
+
+const (
+	// EvictionExpired 已过期。
+	EvictionExpired EvictionReason = iota + 1
+
+	// EvictionCapacity 超出容量，最近不用的被移除。
+	EvictionCapacity
+
+	// EvictionExplicit 被删除。
+	EvictionExplicit
+)
+
+// String 实现fmt.Stringer接口。
+func (reason EvictionReason) String() string {
+	switch reason {
+	case EvictionExpired:
+		return "expired"
+	case EvictionCapacity:
+		return "capacity"
+	case EvictionExplicit:
+		return "explicit"
+	default:
+		return "unknown"
+	}
+}
+
+// Sizer 计算数据项占用的字节数。
+type Sizer func(key string, value interface{}) int64
+
+// Sizable 能计算自身占用字节数的缓存数据。可选实现。
+type Sizable interface {
+	// CacheSize 返回占用的字节数。
+	CacheSize() int64
+}
+
+// DefaultSizer 默认的数据项字节数计算函数。
+// 优先使用Sizable接口；字符串、字节切片按长度计算；其它类型只计算值本身的大小，不计算指向的数据。
+func DefaultSizer(key string, value interface{}) int64 {
+	size := int64(len(key))
+	switch v := value.(type) {
+	case Sizable:
+		size += v.CacheSize()
+	case string:
+		size += int64(len(v))
+	case []byte:
+		size += int64(len(v))
+	case nil:
+	default:
+		size += int64(reflect.TypeOf(value).Size())
+	}
+	return size
+}
+
+// Config LRU缓存的配置。
+type Config struct {
+	// MaxEntries 最多存储的数据项数。0表示不限制。
+	MaxEntries int
+
+	// MaxBytes 最多占用的字节数。0表示不限制。
+	// 每个分片的容量为MaxBytes/Shards，超出分片容量的数据项不会被存储。
+	MaxBytes int64
+
+	// Sizer 数据项字节数计算函数。默认为：DefaultSizer。
+	Sizer Sizer
+
+	// OnEvicted 数据项被移除时的回调函数。可选。被覆盖的数据项不回调。
+	// 回调时不持有锁，可以调用LRUCache的方法。
+	OnEvicted func(key string, value interface{}, reason EvictionReason)
+
+	// Shards 分片数。分片越多，并发性能越好，越不精确。默认为16。
+	Shards int
+
+	// CleanupInterval 后台清理过期数据项的间隔。可选。
+	// 如果大于0，启动后台清理协程，需要调用Close停止。否则过期的数据项只在被查询时移除。
+	CleanupInterval time.Duration
+}
+
+// Stats LRU缓存的统计数据。
+type Stats struct {
+	// Entries 数据项数。
+	Entries int64
+
+	// Bytes 占用的字节数。
+	Bytes int64
+
+	// Hits 命中次数。
+	Hits uint64
+
+	// Misses 未命中次数。
+	Misses uint64
+
+	// Evictions 因为过期、超出容量而被移除的次数。
+	Evictions uint64
+}
+
 // LRUCache 进程内的LRU缓存。只存储最近使用的。
-// 基于github.com/wencan/xsync/LRUMap，按批次（区块）清理最近不用的数据。
-// 实现了github.com/wencan/fastrest/restcache的Storage接口和MStorage接口。
+// 分片存储，每个分片独立清理最近不用的数据。支持按数据项数和按字节数限制容量。
+// 实现了github.com/wencan/fastrest/restcache的Storage接口和MStorage接口，以及Deleter接口、MDeleter接口、PrefixDeleter接口和TagIndexer接口。
 type LRUCache struct {
-	lruMap *xsync.LRUMap
+	shards []*lruShard
+
+	// tags 标签索引。
+	tags tagIndex
+
+	// stopCleanup 停止后台清理。
+	stopCleanup chan struct{}
+
+	// cleanupDone 后台清理已经停止。
+	cleanupDone chan struct{}
+
+	closeOnce sync.Once
+
+	sizer Sizer
+
+	onEvicted func(key string, value interface{}, reason EvictionReason)
+
+	hits uint64
+
+	misses uint64
+
+	evictions uint64
 }
 
-// NewLRUCache 创建lru缓存。chunkCapacity、chunkNum是github.com/wencan/xsync/LRUMap的区块参数。
+// NewLRUCache 创建lru缓存。最多存储chunkCapacity*chunkNum个数据项，分为chunkNum个分片。
 func NewLRUCache(chunkCapacity int, chunkNum int) *LRUCache {
-	return &LRUCache{
-		// LRUMap的清理逻辑有bug。暂时不用清理
-		// lruMap: xsync.NewLRUMapWithEvict(chunkCapacity, chunkNum, func(key, value interface{}) {
-		// 	lruEntryPool.Put(value)
-		// }),
-		lruMap: xsync.NewLRUMap(chunkCapacity, chunkNum),
+	return NewLRUCacheWithConfig(Config{
+		MaxEntries: chunkCapacity * chunkNum,
+		Shards:     chunkNum,
+	})
+}
+
+// NewLRUCacheWithConfig 根据配置创建lru缓存。
+func NewLRUCacheWithConfig(config Config) *LRUCache {
+	shardNum := config.Shards
+	if shardNum <= 0 {
+		shardNum = 16
+	}
+	sizer := config.Sizer
+	if sizer == nil {
+		sizer = DefaultSizer
+	}
+
+	// 每个分片的容量，向上取整
+	var shardMaxEntries int
+	if config.MaxEntries > 0 {
+		shardMaxEntries = (config.MaxEntries + shardNum - 1) / shardNum
+	}
+	var shardMaxBytes int64
+	if config.MaxBytes > 0 {
+		shardMaxBytes = (config.MaxBytes + int64(shardNum) - 1) / int64(shardNum)
+	}
+
+	lru := &LRUCache{
+		shards:    make([]*lruShard, 0, shardNum),
+		sizer:     sizer,
+		onEvicted: config.OnEvicted,
+	}
+	for i := 0; i < shardNum; i++ {
+		lru.shards = append(lru.shards, newLRUShard(shardMaxEntries, shardMaxBytes))
+	}
+
+	if config.CleanupInterval > 0 {
+		lru.stopCleanup = make(chan struct{})
+		lru.cleanupDone = make(chan struct{})
+		go lru.cleanupLoop(config.CleanupInterval)
 	}
+	return lru
+}
+
+// cleanupLoop 定时清理过期的数据项，直到Close。
+func (lru *LRUCache) cleanupLoop(interval time.Duration) {
+	defer close(lru.cleanupDone)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			lru.RemoveExpired()
+		case <-lru.stopCleanup:
+			return
+		}
+	}
+}
+
+// RemoveExpired 移除全部过期的数据项。后台清理定时调用，也可以手动调用。
+func (lru *LRUCache) RemoveExpired() {
+	now := restutils.CoarseTimestamp()
+	for _, shard := range lru.shards {
+		for _, entry := range shard.removeExpired(now) {
+			lru.evict(entry, EvictionExpired)
+		}
+	}
+}
+
+// Close 停止后台清理，并等待清理协程退出。不影响存储的数据。可以多次调用。
+func (lru *LRUCache) Close() error {
+	lru.closeOnce.Do(func() {
+		if lru.stopCleanup != nil {
+			close(lru.stopCleanup)
+			<-lru.cleanupDone
+		}
+	})
+	return nil
+}
+
+// shard 返回key所在的分片。
+func (lru *LRUCache) shard(key string) *lruShard {
+	// FNV-1a
+	var hash uint32 = 2166136261
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return lru.shards[hash%uint32(len(lru.shards))]
+}
+
+// load 查找没过期的数据。
+func (lru *LRUCache) load(key string) (value interface{}, ok bool) {
+	value, ok, expired := lru.shard(key).load(key, restutils.CoarseTimestamp())
+	if expired != nil {
+		lru.evict(expired, EvictionExpired)
+	}
+	if ok {
+		atomic.AddUint64(&lru.hits, 1)
+	} else {
+		atomic.AddUint64(&lru.misses, 1)
+	}
+	return value, ok
+}
+
+// evict 回调OnEvicted，回收数据项。
+func (lru *LRUCache) evict(entry *lruEntry, reason EvictionReason) {
+	if reason != EvictionExplicit {
+		atomic.AddUint64(&lru.evictions, 1)
+	}
+	lru.tags.remove(entry.key)
+	if lru.onEvicted != nil {
+		lru.onEvicted(entry.key, entry.value, reason)
+	}
+	releaseEntry(entry)
+}
+
+// releaseEntry 回收数据项。
+func releaseEntry(entry *lruEntry) {
+	*entry = lruEntry{}
+	lruEntryPool.Put(entry)
 }
 
 // Get 实现github.com/wencan/fastrest/restcache的Storage接口。
 func (lru *LRUCache) Get(ctx context.Context, key string, valuePtr interface{}) (found bool, err error) {
-	value, upgrade, ok := lru.lruMap.SilentLoad(key)
+	value, ok := lru.load(key)
 	if !ok {
 		return false, nil
 	}
 
-	entry := value.(*lruEntry)
-	// if time.Now().After(entry.expire) {
-	if restutils.CoarseTimestamp() > float64(entry.expire.UnixMilli())/1000 {
-		// 过期
-		return false, nil
-	}
-
 	// 赋值
-	v := reflect.ValueOf(entry.value)
+	v := reflect.ValueOf(value)
 	reflect.ValueOf(valuePtr).Elem().Set(v)
 
-	// 更新为最近使用
-	upgrade()
-
 	return true, nil
 }
 
 // Set 实现github.com/wencan/fastrest/restcache的Storage接口。
 func (lru *LRUCache) Set(ctx context.Context, key string, value interface{}, TTL time.Duration) error {
 	entry := lruEntryPool.Get().(*lruEntry)
+	entry.key = key
 	entry.value = value
-	entry.expire = time.Now().Add(TTL)
+	entry.expireAt = float64(time.Now().Add(TTL).UnixMilli()) / 1000
+	entry.size = lru.sizer(key, value)
 
-	lru.lruMap.Store(key, entry)
+	replaced, evicted := lru.shard(key).store(entry)
+	if replaced != nil {
+		// 覆盖的数据项的标签不再有效。新数据项的标签，由调用者在Set之后通过AddTags登记
+		lru.tags.remove(key)
+		releaseEntry(replaced)
+	}
+	for _, entry := range evicted {
+		lru.evict(entry, EvictionCapacity)
+	}
 	return nil
 }
 
@@ -78,26 +319,15 @@ func (lru *LRUCache) MGet(ctx context.Context, keys []string, destSlicePtr inter
 	destSliceValue := reflect.ValueOf(destSlicePtr).Elem()
 
 	for index, key := range keys {
-		value, upgrade, ok := lru.lruMap.SilentLoad(key)
+		value, ok := lru.load(key)
 		if !ok {
 			missIndexes = append(missIndexes, index)
 			continue
 		}
 
-		entry := value.(*lruEntry)
-		// if time.Now().After(entry.expire) {
-		if restutils.CoarseTimestamp() > float64(entry.expire.UnixMilli())/1000 {
-			// 过期
-			missIndexes = append(missIndexes, index)
-			continue
-		}
-
 		// 赋值
-		v := reflect.ValueOf(entry.value)
+		v := reflect.ValueOf(value)
 		destSliceValue.Set(reflect.Append(destSliceValue, v))
-
-		// 更新为最近使用
-		upgrade()
 	}
 
 	return missIndexes, nil
@@ -116,3 +346,94 @@ func (lru *LRUCache) MSet(ctx context.Context, keys []string, destSlice interfac
 
 	return nil
 }
+
+// Delete 实现github.com/wencan/fastrest/restcache的Deleter接口。
+func (lru *LRUCache) Delete(ctx context.Context, key string) error {
+	deleted := lru.shard(key).delete(key)
+	if deleted != nil {
+		lru.evict(deleted, EvictionExplicit)
+	}
+	return nil
+}
+
+// MDelete 实现github.com/wencan/fastrest/restcache的MDeleter接口。
+func (lru *LRUCache) MDelete(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		lru.Delete(ctx, key)
+	}
+	return nil
+}
+
+// DeletePrefix 实现github.com/wencan/fastrest/restcache的PrefixDeleter接口。
+func (lru *LRUCache) DeletePrefix(ctx context.Context, prefix string) error {
+	for _, shard := range lru.shards {
+		keys, _ := shard.snapshot(restutils.CoarseTimestamp())
+		for _, key := range keys {
+			if strings.HasPrefix(key, prefix) {
+				lru.Delete(ctx, key)
+			}
+		}
+	}
+	return nil
+}
+
+// RemainingTTLs 实现github.com/wencan/fastrest/restcache/tiered的TTLReader接口。
+// 返回keys的数据剩余的生存时间。不存在或者已经过期的，为0。
+func (lru *LRUCache) RemainingTTLs(ctx context.Context, keys []string) ([]time.Duration, error) {
+	now := time.Now()
+	nowTimestamp := float64(now.UnixMilli()) / 1000
+	ttls := make([]time.Duration, len(keys))
+	for index, key := range keys {
+		expireAt := lru.shard(key).expireAt(key, nowTimestamp)
+		if expireAt > 0 {
+			ttls[index] = time.Duration((expireAt - nowTimestamp) * float64(time.Second))
+		}
+	}
+	return ttls, nil
+}
+
+// Len 返回数据项数。包括还没被移除的过期数据项。
+func (lru *LRUCache) Len() int {
+	var length int
+	for _, shard := range lru.shards {
+		entries, _ := shard.stats()
+		length += entries
+	}
+	return length
+}
+
+// Range 遍历没过期的数据项。如果f返回false，停止遍历。
+// 遍历的是各个分片的快照，f内可以调用LRUCache的方法。遍历不更新最近使用。
+func (lru *LRUCache) Range(f func(key string, value interface{}) bool) {
+	for _, shard := range lru.shards {
+		keys, values := shard.snapshot(restutils.CoarseTimestamp())
+		for index, key := range keys {
+			if !f(key, values[index]) {
+				return
+			}
+		}
+	}
+}
+
+// Purge 移除全部数据项。移除的数据项按EvictionExplicit回调OnEvicted。
+func (lru *LRUCache) Purge() {
+	for _, shard := range lru.shards {
+		for _, entry := range shard.purge() {
+			lru.evict(entry, EvictionExplicit)
+		}
+	}
+}
+
+// Stats 返回统计数据。
+func (lru *LRUCache) Stats() Stats {
+	var stats Stats
+	for _, shard := range lru.shards {
+		entries, bytes := shard.stats()
+		stats.Entries += int64(entries)
+		stats.Bytes += bytes
+	}
+	stats.Hits = atomic.LoadUint64(&lru.hits)
+	stats.Misses = atomic.LoadUint64(&lru.misses)
+	stats.Evictions = atomic.LoadUint64(&lru.evictions)
+	return stats
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"math/rand"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -118,3 +119,235 @@ func TestLRUCache_Concurrently(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestLRUCache_Delete(t *testing.T) {
+	lruCache := NewLRUCache(100, 10)
+
+	keys := []string{"response_1", "response_2", "response_3"}
+	values := []string{"response_1", "response_2", "response_3"}
+	err := lruCache.MSet(context.TODO(), keys, values, time.Minute)
+	assert.Nil(t, err)
+
+	err = lruCache.Delete(context.TODO(), "response_1")
+	assert.Nil(t, err)
+	var value string
+	found, err := lruCache.Get(context.TODO(), "response_1", &value)
+	if assert.Nil(t, err) {
+		assert.False(t, found)
+	}
+
+	err = lruCache.MDelete(context.TODO(), []string{"response_2", "response_4"})
+	assert.Nil(t, err)
+	var results []string
+	missIndexes, err := lruCache.MGet(context.TODO(), keys, &results)
+	if assert.Nil(t, err) {
+		assert.Equal(t, []int{0, 1}, missIndexes)
+		assert.Equal(t, []string{"response_3"}, results)
+	}
+}
+
+func TestLRUCache_MaxBytes(t *testing.T) {
+	type eviction struct {
+		key    string
+		reason EvictionReason
+	}
+	var evictions []eviction
+	lruCache := NewLRUCacheWithConfig(Config{
+		MaxBytes: 100,
+		Sizer: func(key string, value interface{}) int64 {
+			return int64(len(value.(string)))
+		},
+		OnEvicted: func(key string, value interface{}, reason EvictionReason) {
+			evictions = append(evictions, eviction{key: key, reason: reason})
+		},
+		Shards: 1,
+	})
+
+	ctx := context.TODO()
+	lruCache.Set(ctx, "a", strings.Repeat("a", 40), time.Minute)
+	lruCache.Set(ctx, "b", strings.Repeat("b", 40), time.Minute)
+
+	// 访问a，b成为最近不用的
+	var value string
+	found, err := lruCache.Get(ctx, "a", &value)
+	if assert.Nil(t, err) {
+		assert.True(t, found)
+	}
+
+	// 超出容量，移除b
+	lruCache.Set(ctx, "c", strings.Repeat("c", 40), time.Minute)
+	found, err = lruCache.Get(ctx, "b", &value)
+	if assert.Nil(t, err) {
+		assert.False(t, found)
+	}
+
+	// 超出容量的数据项，不存储
+	lruCache.Set(ctx, "d", strings.Repeat("d", 200), time.Minute)
+	found, err = lruCache.Get(ctx, "d", &value)
+	if assert.Nil(t, err) {
+		assert.False(t, found)
+	}
+
+	// 过期
+	lruCache.Set(ctx, "e", "e", time.Millisecond*100)
+	time.Sleep(time.Millisecond * 300)
+	found, err = lruCache.Get(ctx, "e", &value)
+	if assert.Nil(t, err) {
+		assert.False(t, found)
+	}
+
+	// 删除
+	lruCache.Delete(ctx, "a")
+
+	assert.Equal(t, []eviction{
+		{key: "b", reason: EvictionCapacity},
+		{key: "d", reason: EvictionCapacity},
+		{key: "e", reason: EvictionExpired},
+		{key: "a", reason: EvictionExplicit},
+	}, evictions)
+
+	assert.Equal(t, Stats{
+		Entries:   1,
+		Bytes:     40,
+		Hits:      1,
+		Misses:    3,
+		Evictions: 3,
+	}, lruCache.Stats())
+}
+
+func TestLRUCache_Stats(t *testing.T) {
+	lruCache := NewLRUCache(2, 1)
+
+	ctx := context.TODO()
+	for i := 0; i < 3; i++ {
+		lruCache.Set(ctx, strconv.Itoa(i), "value", time.Minute)
+	}
+
+	var value string
+	for i := 0; i < 3; i++ {
+		lruCache.Get(ctx, strconv.Itoa(i), &value)
+	}
+
+	stats := lruCache.Stats()
+	assert.Equal(t, int64(2), stats.Entries)
+	assert.Equal(t, int64(2*len("0value")), stats.Bytes)
+	assert.Equal(t, uint64(2), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, uint64(1), stats.Evictions)
+}
+
+func TestLRUCache_Cleanup(t *testing.T) {
+	var expired []string
+	var mu sync.Mutex
+	lruCache := NewLRUCacheWithConfig(Config{
+		MaxEntries: 100,
+		OnEvicted: func(key string, value interface{}, reason EvictionReason) {
+			if reason == EvictionExpired {
+				mu.Lock()
+				expired = append(expired, key)
+				mu.Unlock()
+			}
+		},
+		CleanupInterval: time.Millisecond * 100,
+	})
+	defer lruCache.Close()
+
+	ctx := context.TODO()
+	lruCache.Set(ctx, "short", "short", time.Millisecond*100)
+	lruCache.Set(ctx, "long", "long", time.Minute)
+	assert.Equal(t, 2, lruCache.Len())
+
+	// 不查询，后台清理
+	time.Sleep(time.Millisecond * 500)
+	assert.Equal(t, 1, lruCache.Len())
+	mu.Lock()
+	assert.Equal(t, []string{"short"}, expired)
+	mu.Unlock()
+
+	// 多次Close
+	assert.Nil(t, lruCache.Close())
+	assert.Nil(t, lruCache.Close())
+}
+
+func TestLRUCache_RangePurge(t *testing.T) {
+	lruCache := NewLRUCache(100, 10)
+
+	ctx := context.TODO()
+	keys := []string{"user:1", "user:2", "item:1"}
+	values := []string{"user_1", "user_2", "item_1"}
+	err := lruCache.MSet(ctx, keys, values, time.Minute)
+	assert.Nil(t, err)
+	lruCache.Set(ctx, "expired", "expired", time.Millisecond*100)
+	time.Sleep(time.Millisecond * 300)
+
+	ranged := map[string]interface{}{}
+	lruCache.Range(func(key string, value interface{}) bool {
+		ranged[key] = value
+		return true
+	})
+	assert.Equal(t, map[string]interface{}{"user:1": "user_1", "user:2": "user_2", "item:1": "item_1"}, ranged)
+
+	// 停止遍历
+	var count int
+	lruCache.Range(func(key string, value interface{}) bool {
+		count++
+		return false
+	})
+	assert.Equal(t, 1, count)
+
+	err = lruCache.DeletePrefix(ctx, "user:")
+	assert.Nil(t, err)
+	var results []string
+	missIndexes, err := lruCache.MGet(ctx, keys, &results)
+	if assert.Nil(t, err) {
+		assert.Equal(t, []int{0, 1}, missIndexes)
+		assert.Equal(t, []string{"item_1"}, results)
+	}
+
+	lruCache.Purge()
+	assert.Equal(t, 0, lruCache.Len())
+	assert.Equal(t, int64(0), lruCache.Stats().Bytes)
+}
+
+func TestLRUCache_Tags(t *testing.T) {
+	lruCache := NewLRUCache(2, 1)
+
+	ctx := context.TODO()
+	lruCache.Set(ctx, "profile_1", "profile_1", time.Minute)
+	lruCache.AddTags(ctx, "profile_1", []string{"user:1"}, time.Minute)
+	lruCache.Set(ctx, "orders_1", "orders_1", time.Minute)
+	lruCache.AddTags(ctx, "orders_1", []string{"user:1", "orders"}, time.Minute)
+
+	// 超出容量，移除profile_1，同时删除索引
+	lruCache.Set(ctx, "profile_2", "profile_2", time.Minute)
+
+	keys, err := lruCache.PopTagKeys(ctx, "user:1")
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"orders_1"}, keys)
+	}
+	keys, err = lruCache.PopTagKeys(ctx, "user:1")
+	if assert.Nil(t, err) {
+		assert.Empty(t, keys)
+	}
+
+	// 删除数据项，同时删除索引
+	lruCache.Delete(ctx, "orders_1")
+	keys, err = lruCache.PopTagKeys(ctx, "orders")
+	if assert.Nil(t, err) {
+		assert.Empty(t, keys)
+	}
+
+	// 覆盖数据项，删除旧的标签
+	lruCache.Set(ctx, "profile_2", "profile_2", time.Minute)
+	lruCache.AddTags(ctx, "profile_2", []string{"user:2"}, time.Minute)
+	lruCache.Set(ctx, "profile_2", "profile_2 v2", time.Minute)
+	lruCache.AddTags(ctx, "profile_2", []string{"user:3"}, time.Minute)
+	keys, err = lruCache.PopTagKeys(ctx, "user:2")
+	if assert.Nil(t, err) {
+		assert.Empty(t, keys)
+	}
+	keys, err = lruCache.PopTagKeys(ctx, "user:3")
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"profile_2"}, keys)
+	}
+}
@@ -0,0 +1,179 @@
+package lrucache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lruShard LRU缓存的一个分片。
+type lruShard struct {
+	mu sync.Mutex
+
+	// items key -> list元素。元素的值为*lruEntry。
+	items map[string]*list.Element
+
+	// entries 最近使用的在前。
+	entries *list.List
+
+	// bytes 已经占用的字节数。
+	bytes int64
+
+	// maxEntries 最多存储的数据项数。0表示不限制。
+	maxEntries int
+
+	// maxBytes 最多占用的字节数。0表示不限制。
+	maxBytes int64
+}
+
+func newLRUShard(maxEntries int, maxBytes int64) *lruShard {
+	return &lruShard{
+		items:      make(map[string]*list.Element),
+		entries:    list.New(),
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+	}
+}
+
+// load 查找数据项。如果找到且没过期，更新为最近使用。
+// 如果已经过期，移除并返回过期的数据项。
+func (shard *lruShard) load(key string, now float64) (value interface{}, ok bool, expired *lruEntry) {
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	element, ok := shard.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+
+	entry := element.Value.(*lruEntry)
+	if now > entry.expireAt {
+		// 过期
+		shard.removeElement(element)
+		return nil, false, entry
+	}
+
+	// 更新为最近使用
+	shard.entries.MoveToFront(element)
+	return entry.value, true, nil
+}
+
+// expireAt 返回数据项的过期时间戳。不存在或者已经过期，返回0。不更新为最近使用。
+func (shard *lruShard) expireAt(key string, now float64) float64 {
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	element, ok := shard.items[key]
+	if !ok {
+		return 0
+	}
+	entry := element.Value.(*lruEntry)
+	if now > entry.expireAt {
+		return 0
+	}
+	return entry.expireAt
+}
+
+// store 存储数据项。返回被覆盖的数据项，和因为超出容量而被移除的数据项。
+// 如果数据项本身超出容量，不存储，数据项出现在evicted中。
+func (shard *lruShard) store(entry *lruEntry) (replaced *lruEntry, evicted []*lruEntry) {
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if element, ok := shard.items[entry.key]; ok {
+		replaced = element.Value.(*lruEntry)
+		shard.removeElement(element)
+	}
+
+	if shard.maxBytes > 0 && entry.size > shard.maxBytes {
+		return replaced, []*lruEntry{entry}
+	}
+
+	shard.items[entry.key] = shard.entries.PushFront(entry)
+	shard.bytes += entry.size
+
+	// 移除最近不用的，直到不超出容量
+	for (shard.maxEntries > 0 && shard.entries.Len() > shard.maxEntries) || (shard.maxBytes > 0 && shard.bytes > shard.maxBytes) {
+		element := shard.entries.Back()
+		shard.removeElement(element)
+		evicted = append(evicted, element.Value.(*lruEntry))
+	}
+	return replaced, evicted
+}
+
+// delete 删除数据项。返回被删除的数据项。
+func (shard *lruShard) delete(key string) (deleted *lruEntry) {
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	element, ok := shard.items[key]
+	if !ok {
+		return nil
+	}
+	shard.removeElement(element)
+	return element.Value.(*lruEntry)
+}
+
+// removeElement 移除list元素。需要持有锁。
+func (shard *lruShard) removeElement(element *list.Element) {
+	entry := element.Value.(*lruEntry)
+	shard.entries.Remove(element)
+	delete(shard.items, entry.key)
+	shard.bytes -= entry.size
+}
+
+// stats 返回数据项数和占用的字节数。
+func (shard *lruShard) stats() (entries int, bytes int64) {
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	return shard.entries.Len(), shard.bytes
+}
+
+// removeExpired 移除过期的数据项。返回被移除的数据项。
+func (shard *lruShard) removeExpired(now float64) (expired []*lruEntry) {
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	for element := shard.entries.Front(); element != nil; {
+		next := element.Next()
+		entry := element.Value.(*lruEntry)
+		if now > entry.expireAt {
+			shard.removeElement(element)
+			expired = append(expired, entry)
+		}
+		element = next
+	}
+	return expired
+}
+
+// snapshot 返回没过期的数据项的key和值。最近使用的在前。
+func (shard *lruShard) snapshot(now float64) (keys []string, values []interface{}) {
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	keys = make([]string, 0, shard.entries.Len())
+	values = make([]interface{}, 0, shard.entries.Len())
+	for element := shard.entries.Front(); element != nil; element = element.Next() {
+		entry := element.Value.(*lruEntry)
+		if now > entry.expireAt {
+			continue
+		}
+		keys = append(keys, entry.key)
+		values = append(values, entry.value)
+	}
+	return keys, values
+}
+
+// purge 移除全部数据项。返回被移除的数据项。
+func (shard *lruShard) purge() (purged []*lruEntry) {
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	purged = make([]*lruEntry, 0, shard.entries.Len())
+	for element := shard.entries.Front(); element != nil; element = element.Next() {
+		purged = append(purged, element.Value.(*lruEntry))
+	}
+	shard.items = make(map[string]*list.Element)
+	shard.entries.Init()
+	shard.bytes = 0
+	return purged
+}
@@ -0,0 +1,90 @@
+package lrucache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// tagIndex 标签索引。
+type tagIndex struct {
+	mu sync.Mutex
+
+	// tagKeys 标签 -> 带有标签的key。
+	tagKeys map[string]map[string]struct{}
+
+	// keyTags key -> key的标签。用于移除数据项时清理索引。
+	keyTags map[string]map[string]struct{}
+}
+
+// add 登记key的标签。
+func (index *tagIndex) add(key string, tags []string) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	if index.tagKeys == nil {
+		index.tagKeys = make(map[string]map[string]struct{})
+		index.keyTags = make(map[string]map[string]struct{})
+	}
+	keyTags := index.keyTags[key]
+	if keyTags == nil {
+		keyTags = make(map[string]struct{}, len(tags))
+		index.keyTags[key] = keyTags
+	}
+	for _, tag := range tags {
+		keys := index.tagKeys[tag]
+		if keys == nil {
+			keys = make(map[string]struct{})
+			index.tagKeys[tag] = keys
+		}
+		keys[key] = struct{}{}
+		keyTags[tag] = struct{}{}
+	}
+}
+
+// pop 返回带有标签的key，并删除标签的索引。
+func (index *tagIndex) pop(tag string) []string {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	keys := index.tagKeys[tag]
+	delete(index.tagKeys, tag)
+
+	result := make([]string, 0, len(keys))
+	for key := range keys {
+		result = append(result, key)
+		keyTags := index.keyTags[key]
+		delete(keyTags, tag)
+		if len(keyTags) == 0 {
+			delete(index.keyTags, key)
+		}
+	}
+	return result
+}
+
+// remove 删除key的索引。
+func (index *tagIndex) remove(key string) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	for tag := range index.keyTags[key] {
+		keys := index.tagKeys[tag]
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(index.tagKeys, tag)
+		}
+	}
+	delete(index.keyTags, key)
+}
+
+// AddTags 实现github.com/wencan/fastrest/restcache的TagIndexer接口。
+// 数据项被移除时，同时删除数据项的标签索引。
+func (lru *LRUCache) AddTags(ctx context.Context, key string, tags []string, TTL time.Duration) error {
+	lru.tags.add(key, tags)
+	return nil
+}
+
+// PopTagKeys 实现github.com/wencan/fastrest/restcache的TagIndexer接口。
+func (lru *LRUCache) PopTagKeys(ctx context.Context, tag string) (keys []string, err error) {
+	return lru.tags.pop(tag), nil
+}
@@ -42,6 +42,7 @@ type MCaching struct {
 	MQuery MQueryFunc
 
 	// TTLRange 缓存生存时间区间。每次随机取一个区间内的值。
+	// 如果缓存对象实现了TTLer接口，优先使用缓存对象的TTL。
 	TTLRange [2]time.Duration
 
 	// sentinelGroup  哨兵机制。
@@ -50,6 +51,78 @@ type MCaching struct {
 	// SentinelTTL 哨兵和哨兵持有的临时缓存的生存时间。用来省去双重检查。一般1s即可。
 	// “副作用”是可以避免高频查询找不到的数据。
 	SentinelTTL time.Duration
+
+	// NotFoundTTL 没找到的标记（墓碑）的生存时间。可选。
+	// 如果大于0，MQuery没找到的数据，会通过MStorage存储没找到的标记。
+	// 在标记的生存时间内，再查询这些数据，直接作为没找到，不再调用MQuery。用于避免缓存穿透。
+	NotFoundTTL time.Duration
+
+	// Observer 缓存事件观察者。可选。
+	Observer Observer
+
+	// TolerateStorageError 是否容忍MStorage的错误。可选。
+	// 如果为true，读MStorage出错时，全部调用MQuery查询；写MStorage出错时，照常返回查询结果。错误通过Observer通知。
+	TolerateStorageError bool
+
+	// CircuitBreaker MStorage的熔断器。可选。
+	// 熔断期间跳过MStorage，直接调用MQuery查询。一般同TolerateStorageError一起使用。
+	CircuitBreaker *CircuitBreaker
+
+	// Locker 分布式锁。可选。
+	// 如果不为nil，缓存没命中时，多个进程中只有获得锁的进程调用MQuery查询，其它进程在LockWait时间内轮询MStorage，等待查询结果。
+	// 锁出错、等待超时，照常调用MQuery查询。
+	Locker Locker
+
+	// LockTTL 分布式锁的生存时间。应该大于MQuery的耗时。默认为5s。
+	LockTTL time.Duration
+
+	// LockWait 没获得锁时，等待查询结果的最长时间。默认为1s。
+	LockWait time.Duration
+
+	// MaxBatchSize 每次调用MStorage、MQuery的最多key数。可选。
+	// 如果大于0，超出的分批调用。比如避免超出数据库IN语句、redis pipeline的限制。
+	MaxBatchSize int
+
+	// MaxConcurrency 分批调用时，最多同时调用的批数。默认为1，逐批调用。
+	MaxConcurrency int
+
+	// WarmBatchSize Warm每批加载的key数。默认为100。
+	WarmBatchSize int
+
+	// WarmConcurrency Warm最多同时加载的批数。默认为4。
+	WarmConcurrency int
+
+	// RefreshAheadTopN 提前刷新的key数。可选。
+	// 如果大于0，跟踪查询过的key的命中次数，RefreshAhead定时重新查询最近命中最多的、即将过期的key。
+	// 最多跟踪RefreshAheadTopN的10倍个key，超过的，不再跟踪命中最少的。
+	RefreshAheadTopN int
+
+	// RefreshAheadWindow 过期前多久提前刷新。默认为RefreshAhead的interval的2倍。
+	// 过期时间按TTLRange的下限估计，如果缓存对象实现了TTLer接口，按缓存对象的TTL。
+	RefreshAheadWindow time.Duration
+
+	// Namespace 缓存key的命名空间。可选。
+	// 如果不为空，存储时key加上前缀“Namespace:Version:世代:”。BumpNamespace改变世代，使命名空间内的全部缓存失效，不需要批量删除。
+	// 世代存储在MStorage里，key为Namespace+"#generation"。MStorage应该避免淘汰这个key，否则命名空间内的全部缓存失效。
+	// 读取世代出错的，使用已知的世代；没有已知的世代，容忍错误（TolerateStorageError）或者熔断期间，不使用缓存直接查询。
+	Namespace string
+
+	// Version 缓存数据的版本。可选。
+	// 如果不为空，存储时key加上版本前缀。缓存数据的结构改变后，修改Version，不再使用旧版本的数据。
+	Version string
+
+	// NamespaceCheckInterval 检查命名空间世代的间隔。默认为1s。
+	// 其它进程调用BumpNamespace后，最多在这个间隔后使用新的世代。
+	NamespaceCheckInterval time.Duration
+
+	// namespaceGeneration 命名空间的世代。
+	namespaceGeneration namespaceGeneration
+
+	// inflightQueries 正在执行的查询。用于失效。
+	inflightQueries inflightQueryGroup
+
+	// refreshAhead 提前刷新的跟踪数据。
+	refreshAhead refreshAheadTracker
 }
 
 // MGet 批量查询。
@@ -58,22 +131,42 @@ type MCaching struct {
 // destSlicePtr指向的切片的元素数据是共享的，内容不可修改。
 // 可以使用restutils.HitIndexes函数，将没找到部分的下标，转为找到部分的下标。
 func (mcaching *MCaching) MGet(ctx context.Context, destSlicePtr interface{}, keys []string, argsSlice interface{}) (missIndexes []int, err error) {
-	// 第一步，先查缓存
-	cacheMissIndexes, err := mcaching.MStorage.MGet(ctx, keys, destSlicePtr)
-	if err != nil {
-		return nil, err
+	if IsBypass(ctx) {
+		// 跳过缓存
+		return mcaching.bypass(ctx, destSlicePtr, keys, argsSlice)
+	}
+
+	storageKeys, err := mcaching.storageKeys(ctx, keys)
+	if err == errNoGeneration {
+		// 不能取得命名空间的世代，不使用缓存
+		return mcaching.bypass(ctx, destSlicePtr, keys, argsSlice)
 	}
-	// 移除失效的缓存 —— 通过可选的Validatable接口
-	cacheMissIndexes, err = removeInvalidCache(len(keys), cacheMissIndexes, destSlicePtr)
 	if err != nil {
 		return nil, err
 	}
+	keys = storageKeys
+
+	// 第一步，先查缓存
+	var cacheMissIndexes, notFoundIndexes []int
+	if IsRefresh(ctx) {
+		// 强制刷新。全部重新查询，不使用哨兵持有的临时缓存
+		cacheMissIndexes = make([]int, 0, len(keys))
+		for index := range keys {
+			cacheMissIndexes = append(cacheMissIndexes, index)
+		}
+		mcaching.sentinelGroup.Delete(keys...)
+	} else {
+		cacheMissIndexes, notFoundIndexes, err = mcaching.mgetStored(ctx, destSlicePtr, keys)
+		if err != nil {
+			return nil, err
+		}
+	}
 	if len(cacheMissIndexes) == 0 {
-		// 全部找到
-		return nil, nil
+		// 全部找到，或者确定没找到
+		return notFoundIndexes, nil
 	}
 
-	// 第二步，调query查询函数，查缓存没命中的
+	// 第二步，调query查询函数，查缓存没命中的，存起来
 	// 未命中缓存的查询参数
 	missKeys := make([]string, 0, len(cacheMissIndexes))
 	argsSliceValue := reflect.ValueOf(argsSlice)
@@ -85,58 +178,20 @@ func (mcaching *MCaching) MGet(ctx context.Context, destSlicePtr interface{}, ke
 		missKeys = append(missKeys, keys[missIndex])
 		missArgsSliceValue = reflect.Append(missArgsSliceValue, argsSliceValue.Index(missIndex))
 	}
-	// query查询
-	queriedDestPtrValue := reflect.New(reflect.ValueOf(destSlicePtr).Type().Elem())
-	// 哨兵机制。同一进程内，同一时间，不同查询同key的数据
-	queryErrs, err := mcaching.sentinelGroup.MDo(ctx, queriedDestPtrValue.Interface(), missKeys, missArgsSliceValue.Interface(), func(ctx context.Context, destSlicePtr, argsSlice interface{}) ([]error, error) {
-		queryMissIndexes, err := mcaching.MQuery(ctx, destSlicePtr, argsSlice)
-		if err != nil {
-			return nil, err
-		}
-		var errs []error // 目前这个只会有notfound或者nil
-		for index, missKey := range missKeys {
-			if restutils.IntSliceContains(queryMissIndexes, index) {
-				errs = append(errs, resterror.FormatNotFoundError("not found key [%s]", missKey))
-			} else {
-				errs = append(errs, nil)
-			}
-		}
-		return errs, nil
-	})
-	if err != nil {
-		return nil, err
-	}
-	// 延迟删除哨兵（和哨兵持有的临时缓存）
-	// 省去双重检查。
-	time.AfterFunc(mcaching.SentinelTTL, func() {
-		mcaching.sentinelGroup.Delete(missKeys...)
-	})
-
-	// 第三步，query查询到的存起来
-	queriedDestValue := queriedDestPtrValue.Elem()
-	var queriedKeys = make([]string, 0, queriedDestValue.Len())
-	for queryIndex, missKey := range missKeys {
-		if len(queryErrs) > queryIndex && resterror.IsNotFound(queryErrs[queryIndex]) {
-			// query函数没找到
-		} else {
-			queriedKeys = append(queriedKeys, missKey)
-		}
-	}
-	if len(queriedKeys) != queriedDestValue.Len() {
-		return nil, fmt.Errorf("wrong query result. query keys: %v", missKeys)
-	}
-	err = mcaching.MStorage.MSet(ctx, queriedKeys, queriedDestValue.Interface(), getTTL(mcaching.TTLRange))
+	queryErrs, queriedDestValue, err := mcaching.queryAndStore(ctx, reflect.ValueOf(destSlicePtr).Type().Elem(), missKeys, missArgsSliceValue)
 	if err != nil {
 		return nil, err
 	}
 
-	// 第四步，组合结果
+	// 第三步，组合结果
 	var cacheCount, queryCount, queriedDestCount int
 	var cacheDestSlice = reflect.ValueOf(destSlicePtr).Elem()
 	var destElemValueMap = make(map[string]reflect.Value)
 	var m = make(map[string]interface{})
 	for index, key := range keys {
-		if !restutils.IntSliceContains(cacheMissIndexes, index) { // 缓存命中的
+		if restutils.IntSliceContains(notFoundIndexes, index) { // 有没找到标记的
+			missIndexes = append(missIndexes, index)
+		} else if !restutils.IntSliceContains(cacheMissIndexes, index) { // 缓存命中的
 			if cacheDestSlice.Len() <= cacheCount { // 缓存返回数据有问题
 				return nil, errors.New("not enough cache results")
 			}
@@ -172,6 +227,425 @@ func (mcaching *MCaching) MGet(ctx context.Context, destSlicePtr interface{}, ke
 	return missIndexes, nil
 }
 
+// bypass 跳过缓存，直接调用MQuery查询。
+func (mcaching *MCaching) bypass(ctx context.Context, destSlicePtr interface{}, keys []string, argsSlice interface{}) (missIndexes []int, err error) {
+	if len(keys) != reflect.ValueOf(argsSlice).Len() {
+		return nil, errors.New("wrong argsSlice")
+	}
+	observe(ctx, mcaching.Observer, EventQueryStart, keys, 0, nil)
+	start := time.Now()
+	missIndexes, err = mcaching.MQuery(ctx, destSlicePtr, argsSlice)
+	observe(ctx, mcaching.Observer, EventQueryFinish, keys, time.Since(start), err)
+	return missIndexes, err
+}
+
+// MGetMap 批量查询。逻辑同MGet，结果按key存入destMapPtr指向的map，没找到的key不在map中。
+// destMapPtr是map[string]V指针，V同MGet的切片元素类型。如果指向的map为nil，创建新的map。
+// map的元素数据是共享的，内容不可修改。
+func (mcaching *MCaching) MGetMap(ctx context.Context, destMapPtr interface{}, keys []string, argsSlice interface{}) error {
+	destMapPtrValue := reflect.ValueOf(destMapPtr)
+	if destMapPtrValue.Kind() != reflect.Ptr || destMapPtrValue.Elem().Kind() != reflect.Map || destMapPtrValue.Elem().Type().Key().Kind() != reflect.String {
+		return errors.New("destMapPtr must be a pointer to map[string]V")
+	}
+	destMapValue := destMapPtrValue.Elem()
+	if destMapValue.IsNil() {
+		destMapValue.Set(reflect.MakeMapWithSize(destMapValue.Type(), len(keys)))
+	}
+
+	destSlicePtrValue := reflect.New(reflect.SliceOf(destMapValue.Type().Elem()))
+	missIndexes, err := mcaching.MGet(ctx, destSlicePtrValue.Interface(), keys, argsSlice)
+	if err != nil {
+		return err
+	}
+
+	destSliceValue := destSlicePtrValue.Elem()
+	keyType := destMapValue.Type().Key()
+	var destCount, missCount int
+	for index, key := range keys {
+		if missCount < len(missIndexes) && missIndexes[missCount] == index {
+			missCount++
+			continue
+		}
+		if destCount >= destSliceValue.Len() {
+			return errors.New("not enough results")
+		}
+		destMapValue.SetMapIndex(reflect.ValueOf(key).Convert(keyType), destSliceValue.Index(destCount))
+		destCount++
+	}
+	return nil
+}
+
+// queryAndStore 通过哨兵机制调用MQuery查询，并存储查询结果和没找到的标记。
+// 返回各个missKey的查询错误（目前只会有notfound或者nil，允许省去后面的nil），和查询到的数据（顺序同查询到的missKey）。
+func (mcaching *MCaching) queryAndStore(ctx context.Context, destSliceType reflect.Type, missKeys []string, missArgsSliceValue reflect.Value) (queryErrs []error, queriedDestValue reflect.Value, err error) {
+	// 登记查询。如果查询期间缓存被失效，查询结果不写回MStorage
+	inflight := mcaching.inflightQueries.begin(missKeys...)
+	defer mcaching.inflightQueries.end(inflight, missKeys...)
+	// 查询期间获得的分布式锁，存储查询结果之后再释放
+	var locks heldLocks
+	defer locks.release()
+	// query查询
+	// 哨兵机制的参数序列为missKeys的下标，用于得知哪些key实际执行了查询
+	missPositions := make([]int, 0, len(missKeys))
+	for pos := range missKeys {
+		missPositions = append(missPositions, pos)
+	}
+	var queriedPositions []int
+	queriedDestPtrValue := reflect.New(destSliceType)
+	start := time.Now()
+	// 哨兵机制。同一进程内，同一时间，不同查询同key的数据
+	queryErrs, err = mcaching.sentinelGroup.MDo(ctx, queriedDestPtrValue.Interface(), missKeys, missPositions, func(ctx context.Context, destSlicePtr, argsSlice interface{}) ([]error, error) {
+		queriedPositions = argsSlice.([]int)
+		doKeys := make([]string, 0, len(queriedPositions))
+		doArgsSliceValue := reflect.MakeSlice(missArgsSliceValue.Type(), 0, len(queriedPositions))
+		for _, pos := range queriedPositions {
+			doKeys = append(doKeys, missKeys[pos])
+			doArgsSliceValue = reflect.Append(doArgsSliceValue, missArgsSliceValue.Index(pos))
+		}
+
+		queryMissIndexes, err := mcaching.mqueryInBatches(ctx, &locks, destSlicePtr, doKeys, doArgsSliceValue)
+		if err != nil {
+			return nil, err
+		}
+		var errs []error // 目前这个只会有notfound或者nil
+		for index, doKey := range doKeys {
+			if restutils.IntSliceContains(queryMissIndexes, index) {
+				errs = append(errs, resterror.FormatNotFoundError("not found key [%s]", doKey))
+			} else {
+				errs = append(errs, nil)
+			}
+		}
+		return errs, nil
+	})
+	if mcaching.Observer != nil && len(queriedPositions) != len(missKeys) {
+		var coalescedKeys []string
+		for pos, missKey := range missKeys {
+			if !restutils.IntSliceContains(queriedPositions, pos) {
+				coalescedKeys = append(coalescedKeys, missKey)
+			}
+		}
+		observe(ctx, mcaching.Observer, EventCoalesced, coalescedKeys, time.Since(start), err)
+	}
+	if err != nil {
+		return nil, reflect.Value{}, err
+	}
+	// 延迟删除哨兵（和哨兵持有的临时缓存）
+	// 省去双重检查。
+	time.AfterFunc(mcaching.SentinelTTL, func() {
+		mcaching.sentinelGroup.Delete(missKeys...)
+	})
+
+	// query查询到的存起来
+	// 从MStorage加载到的（其它进程查询并存储的），不再存储
+	queriedDestValue = queriedDestPtrValue.Elem()
+	var queriedKeys = make([]string, 0, queriedDestValue.Len())
+	var storeDestValue = reflect.MakeSlice(queriedDestValue.Type(), 0, queriedDestValue.Len())
+	var storeIndexes []int // 需要存储的查询到的数据，在missKeys中的下标
+	var notFoundKeys []string
+	var queriedCount int
+	for queryIndex, missKey := range missKeys {
+		if len(queryErrs) > queryIndex && resterror.IsNotFound(queryErrs[queryIndex]) {
+			// query函数没找到
+			if !locks.isLoaded(missKey) {
+				notFoundKeys = append(notFoundKeys, missKey)
+			}
+			continue
+		}
+		if queriedCount >= queriedDestValue.Len() {
+			return nil, reflect.Value{}, fmt.Errorf("wrong query result. query keys: %v", missKeys)
+		}
+		if !locks.isLoaded(missKey) {
+			queriedKeys = append(queriedKeys, missKey)
+			storeDestValue = reflect.Append(storeDestValue, queriedDestValue.Index(queriedCount))
+			storeIndexes = append(storeIndexes, queryIndex)
+		}
+		queriedCount++
+	}
+	if queriedCount != queriedDestValue.Len() {
+		return nil, reflect.Value{}, fmt.Errorf("wrong query result. query keys: %v", missKeys)
+	}
+	if allowStorage(mcaching.CircuitBreaker) { // 熔断期间，不存储
+		err = mcaching.msetQueried(ctx, inflight, queriedKeys, storeDestValue, notFoundKeys)
+		if err != nil {
+			return nil, reflect.Value{}, err
+		}
+
+		if mcaching.RefreshAheadTopN > 0 {
+			// 跟踪存储了的key，用于提前刷新
+			now := time.Now()
+			for storeCount, queryIndex := range storeIndexes {
+				ttl := getValueTTL(storeDestValue.Index(storeCount).Interface(), mcaching.TTLRange[0])
+				mcaching.refreshAhead.stored(missKeys[queryIndex], destSliceType, missArgsSliceValue.Index(queryIndex), now.Add(ttl), mcaching.RefreshAheadTopN*refreshAheadTrackFactor)
+			}
+		}
+	}
+
+	return queryErrs, queriedDestValue, nil
+}
+
+// mquery 调用MQuery查询。如果设置了Locker，只查询获得锁的，其它的等待获得锁的进程查询并存储。
+// 逻辑同MQuery，destSlicePtr元素的顺序同doKeys的顺序，返回没找到部分的下标。
+// 获得的分布式锁登记到locks，由调用者在存储查询结果之后释放。从MStorage加载到的key也登记到locks，不需要再存储。
+func (mcaching *MCaching) mquery(ctx context.Context, locks *heldLocks, destSlicePtr interface{}, doKeys []string, doArgsSliceValue reflect.Value) (queryMissIndexes []int, err error) {
+	if mcaching.Locker == nil || IsRefresh(ctx) {
+		observe(ctx, mcaching.Observer, EventQueryStart, doKeys, 0, nil)
+		start := time.Now()
+		queryMissIndexes, err = mcaching.MQuery(ctx, destSlicePtr, doArgsSliceValue.Interface())
+		observe(ctx, mcaching.Observer, EventQueryFinish, doKeys, time.Since(start), err)
+		return queryMissIndexes, err
+	}
+
+	// 分布式锁。没获得锁的，等待获得锁的进程查询并存储
+	destSliceType := reflect.ValueOf(destSlicePtr).Type().Elem()
+	loadedValues := make(map[int]reflect.Value) // 从MStorage加载到的
+	loadedNotFound := make(map[int]bool)        // 从MStorage加载到没找到标记的
+	queryIndexes, unlock := lockOrWait(ctx, mcaching.Locker, doKeys, mcaching.LockTTL, mcaching.LockWait, func(indexes []int) ([]int, error) {
+		if !allowStorage(mcaching.CircuitBreaker) {
+			return nil, errStopWaiting
+		}
+		waitKeys := make([]string, 0, len(indexes))
+		for _, index := range indexes {
+			waitKeys = append(waitKeys, doKeys[index])
+		}
+		storedPtrValue := reflect.New(destSliceType)
+		cacheMissIndexes, notFoundIndexes, err := mcaching.mgetStored(ctx, storedPtrValue.Interface(), waitKeys)
+		if err != nil {
+			return nil, err
+		}
+		var remainIndexes []int
+		var storedCount int
+		for waitIndex, index := range indexes {
+			if restutils.IntSliceContains(notFoundIndexes, waitIndex) {
+				loadedNotFound[index] = true
+			} else if restutils.IntSliceContains(cacheMissIndexes, waitIndex) {
+				remainIndexes = append(remainIndexes, index)
+			} else {
+				if storedPtrValue.Elem().Len() <= storedCount {
+					return nil, errors.New("not enough cache results")
+				}
+				loadedValues[index] = storedPtrValue.Elem().Index(storedCount)
+				storedCount++
+			}
+		}
+		return remainIndexes, nil
+	})
+	// 存储查询结果之后才释放锁，避免其它进程在存储之前重复查询
+	locks.add(unlock)
+
+	// 查询获得锁的，和等待超时的
+	queriedValue := reflect.New(destSliceType).Elem()
+	var queriedMissIndexes []int
+	if len(queryIndexes) > 0 {
+		queryKeys := make([]string, 0, len(queryIndexes))
+		queryArgsSliceValue := reflect.MakeSlice(doArgsSliceValue.Type(), 0, len(queryIndexes))
+		for _, index := range queryIndexes {
+			queryKeys = append(queryKeys, doKeys[index])
+			queryArgsSliceValue = reflect.Append(queryArgsSliceValue, doArgsSliceValue.Index(index))
+		}
+		observe(ctx, mcaching.Observer, EventQueryStart, queryKeys, 0, nil)
+		start := time.Now()
+		queriedMissIndexes, err = mcaching.MQuery(ctx, queriedValue.Addr().Interface(), queryArgsSliceValue.Interface())
+		observe(ctx, mcaching.Observer, EventQueryFinish, queryKeys, time.Since(start), err)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 按doKeys的顺序组合结果
+	destSliceValue := reflect.ValueOf(destSlicePtr).Elem()
+	var queryCount, queriedCount int
+	for index, doKey := range doKeys {
+		if value, ok := loadedValues[index]; ok {
+			destSliceValue.Set(reflect.Append(destSliceValue, value))
+			locks.addLoaded(doKey)
+		} else if loadedNotFound[index] {
+			queryMissIndexes = append(queryMissIndexes, index)
+			locks.addLoaded(doKey)
+		} else {
+			if restutils.IntSliceContains(queriedMissIndexes, queryCount) {
+				queryMissIndexes = append(queryMissIndexes, index)
+			} else {
+				if queriedValue.Len() <= queriedCount {
+					return nil, errors.New("not enough query results")
+				}
+				destSliceValue.Set(reflect.Append(destSliceValue, queriedValue.Index(queriedCount)))
+				queriedCount++
+			}
+			queryCount++
+		}
+	}
+	return queryMissIndexes, nil
+}
+
+// msetQueried 存储查询到的数据，和没找到的标记。跳过查询期间已失效的。
+func (mcaching *MCaching) msetQueried(ctx context.Context, inflight *inflightQuery, queriedKeys []string, queriedDestValue reflect.Value, notFoundKeys []string) error {
+	start := time.Now()
+	err := inflight.write(queriedKeys, func(validIndexes []int) error {
+		// 跳过已失效的
+		validIndexes = filterTagCanceled(inflight, validIndexes, queriedDestValue)
+		validKeys, validDestValue := queriedKeys, queriedDestValue
+		if len(validIndexes) != len(queriedKeys) {
+			validKeys = make([]string, 0, len(validIndexes))
+			validDestValue = reflect.MakeSlice(queriedDestValue.Type(), 0, len(validIndexes))
+			for _, validIndex := range validIndexes {
+				validKeys = append(validKeys, queriedKeys[validIndex])
+				validDestValue = reflect.Append(validDestValue, queriedDestValue.Index(validIndex))
+			}
+		}
+		return msetByTTL(ctx, mcaching.mstorage(), validKeys, validDestValue, getTTL(mcaching.TTLRange))
+	})
+	// 如果容忍错误，照常返回查询结果
+	err = mcaching.handleStorageError(ctx, queriedKeys, start, err)
+	if err != nil {
+		return err
+	}
+
+	if IsRefresh(ctx) && len(notFoundKeys) > 0 {
+		// 强制刷新没找到，删除存储的数据
+		start := time.Now()
+		err = inflight.write(notFoundKeys, func(validIndexes []int) error {
+			validKeys := make([]string, 0, len(validIndexes))
+			for _, validIndex := range validIndexes {
+				validKeys = append(validKeys, notFoundKeys[validIndex])
+			}
+			if len(validKeys) == 0 {
+				return nil
+			}
+			return deleteRefreshedNotFound(ctx, mcaching.MStorage, validKeys)
+		})
+		err = mcaching.handleStorageError(ctx, notFoundKeys, start, err)
+		if err != nil {
+			return err
+		}
+	}
+
+	if mcaching.NotFoundTTL > 0 && len(notFoundKeys) > 0 {
+		// 存储没找到的标记
+		start := time.Now()
+		err = inflight.write(notFoundKeys, func(validIndexes []int) error {
+			validKeys := make([]string, 0, len(validIndexes))
+			for _, validIndex := range validIndexes {
+				validKeys = append(validKeys, notFoundKeys[validIndex])
+			}
+			return msetNotFoundTombstones(ctx, mcaching.mstorage(), validKeys, mcaching.NotFoundTTL)
+		})
+		err = mcaching.handleStorageError(ctx, notFoundKeys, start, err)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// filterTagCanceled 过滤掉带有已失效标签的查询结果的下标。只能在write的f中调用。
+func filterTagCanceled(inflight *inflightQuery, validIndexes []int, queriedDestValue reflect.Value) []int {
+	if len(inflight.canceledTags) == 0 {
+		return validIndexes
+	}
+	filtered := make([]int, 0, len(validIndexes))
+	for _, validIndex := range validIndexes {
+		if !inflight.tagCanceled(queriedDestValue.Index(validIndex).Interface()) {
+			filtered = append(filtered, validIndex)
+		}
+	}
+	return filtered
+}
+
+// msetByTTL 按缓存对象的TTL分组，批量存储，并登记缓存对象的标签。
+func msetByTTL(ctx context.Context, mstorage MStorage, keys []string, destSliceValue reflect.Value, defaultTTL time.Duration) error {
+	ttls, groups := groupByTTL(destSliceValue.Len(), func(index int) interface{} { return destSliceValue.Index(index).Interface() }, defaultTTL)
+	for groupIndex, group := range groups {
+		groupKeys, groupDestValue := keys, destSliceValue
+		if len(groups) > 1 {
+			groupKeys = make([]string, 0, len(group))
+			groupDestValue = reflect.MakeSlice(destSliceValue.Type(), 0, len(group))
+			for _, index := range group {
+				groupKeys = append(groupKeys, keys[index])
+				groupDestValue = reflect.Append(groupDestValue, destSliceValue.Index(index))
+			}
+		}
+		err := mstorage.MSet(ctx, groupKeys, groupDestValue.Interface(), ttls[groupIndex])
+		if err != nil {
+			return err
+		}
+		for index, key := range groupKeys {
+			err = addValueTags(ctx, mstorage, key, groupDestValue.Index(index).Interface(), ttls[groupIndex])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mgetStored 从MStorage批量查询。返回缓存未命中的下标，和有没找到标记的下标。
+// 缓存命中的数据，按顺序追加到destSlicePtr指向的切片。
+func (mcaching *MCaching) mgetStored(ctx context.Context, destSlicePtr interface{}, keys []string) (cacheMissIndexes, notFoundIndexes []int, err error) {
+	allMissIndexes := make([]int, 0, len(keys))
+	for index := range keys {
+		allMissIndexes = append(allMissIndexes, index)
+	}
+	if !allowStorage(mcaching.CircuitBreaker) {
+		// 熔断期间，等同全部没找到
+		return allMissIndexes, nil, nil
+	}
+
+	start := time.Now()
+	cacheMissIndexes, err = mcaching.mstorage().MGet(ctx, keys, destSlicePtr)
+	if err != nil {
+		// 如果容忍错误，等同全部没找到
+		destSliceValue := reflect.ValueOf(destSlicePtr).Elem()
+		destSliceValue.Set(reflect.MakeSlice(destSliceValue.Type(), 0, 0))
+		return allMissIndexes, nil, mcaching.handleStorageError(ctx, keys, start, err)
+	}
+	mcaching.handleStorageError(ctx, keys, start, nil)
+	if mcaching.Observer != nil {
+		hitKeys, missKeys := splitKeys(keys, cacheMissIndexes)
+		observe(ctx, mcaching.Observer, EventHit, hitKeys, time.Since(start), nil)
+		observe(ctx, mcaching.Observer, EventMiss, missKeys, time.Since(start), nil)
+	}
+
+	// 移除失效的缓存 —— 通过可选的Validatable接口
+	validCacheMissIndexes, err := removeInvalidCache(len(keys), cacheMissIndexes, destSlicePtr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if mcaching.Observer != nil && len(validCacheMissIndexes) != len(cacheMissIndexes) {
+		var invalidKeys []string
+		for _, index := range validCacheMissIndexes {
+			if !restutils.IntSliceContains(cacheMissIndexes, index) {
+				invalidKeys = append(invalidKeys, keys[index])
+			}
+		}
+		observe(ctx, mcaching.Observer, EventInvalid, invalidKeys, 0, nil)
+	}
+	cacheMissIndexes = validCacheMissIndexes
+	if mcaching.RefreshAheadTopN > 0 {
+		hitKeys, _ := splitKeys(keys, cacheMissIndexes)
+		mcaching.refreshAhead.hit(hitKeys)
+	}
+
+	// 分离出有没找到标记的
+	if mcaching.NotFoundTTL > 0 && len(cacheMissIndexes) > 0 {
+		start := time.Now()
+		newCacheMissIndexes, newNotFoundIndexes, err := mgetNotFoundTombstones(ctx, mcaching.mstorage(), keys, cacheMissIndexes)
+		if err != nil {
+			// 如果容忍错误，等同没有标记
+			return cacheMissIndexes, nil, mcaching.handleStorageError(ctx, keys, start, err)
+		}
+		mcaching.handleStorageError(ctx, keys, start, nil)
+		cacheMissIndexes, notFoundIndexes = newCacheMissIndexes, newNotFoundIndexes
+	}
+
+	return cacheMissIndexes, notFoundIndexes, nil
+}
+
+// handleStorageError 处理MStorage操作的结果。如果容忍错误，返回nil。
+func (mcaching *MCaching) handleStorageError(ctx context.Context, keys []string, start time.Time, err error) error {
+	return handleStorageError(ctx, mcaching.Observer, mcaching.CircuitBreaker, mcaching.TolerateStorageError, keys, start, err)
+}
+
 var validatorType = reflect.TypeOf((*Validatable)(nil)).Elem()
 
 // removeInvalidCache 通过可选的Validatable接口来检查缓存对象是否还有效，并移除无效缓存数据。
@@ -208,3 +682,15 @@ func removeInvalidCache(keysLength int, cacheMissIndexes []int, destSlicePtr int
 	destSliceValue.Set(newDestSliceValue)
 	return newCacheMissIndexes, nil
 }
+
+// splitKeys 根据未命中的下标，将keys分为命中的和未命中的。
+func splitKeys(keys []string, missIndexes []int) (hitKeys, missKeys []string) {
+	for index, key := range keys {
+		if restutils.IntSliceContains(missIndexes, index) {
+			missKeys = append(missKeys, key)
+		} else {
+			hitKeys = append(hitKeys, key)
+		}
+	}
+	return hitKeys, missKeys
+}
@@ -0,0 +1,257 @@
+//go:build go1.18
+// +build go1.18
+
+package restcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/wencan/fastrest/resterror"
+	"github.com/wencan/fastrest/restutils"
+	"github.com/wencan/gox/xsync/sentinel"
+)
+
+// GenericsMStorage 基于范型的支持批量操作的缓存存储接口。
+// 可以使用NewGenericsMStorage，将MStorage实现转为GenericsMStorage实现。
+type GenericsMStorage[VALUE any] interface {
+	// MGet 批量查询存储的数据。
+	// values的元素顺序同keys的顺序。如果keys重复，values元素也必须相应重复。
+	// 如果全部没找到，或者部分没找到，返回没找到部分的下标，不返回错误。
+	// 实现逻辑应该处理掉需要忽略的错误。
+	MGet(ctx context.Context, keys []string) (values []VALUE, missIndexes []int, err error)
+
+	// MSet 批量存储数据。
+	// keys和values同长度同顺序。
+	// 实现逻辑应该处理掉需要忽略的错误。
+	MSet(ctx context.Context, keys []string, values []VALUE, ttl time.Duration) error
+}
+
+// GenericsMQueryFunc 基于范型的批量查询函数。
+// values元素的顺序同argsSlice的顺序。如果argsSlice元素出现重复，values元素也必须相应重复。
+// 如果全部没找到，或者部分没找到，返回没找到部分的下标，不返回错误。
+type GenericsMQueryFunc[ARGS, VALUE any] func(ctx context.Context, argsSlice []ARGS) (values []VALUE, missIndexes []int, err error)
+
+// GenericsMCaching 基于范型的批量缓存。逻辑同MCaching。
+// ARGS为查询函数参数类型，VALUE为缓存数据类型。
+type GenericsMCaching[ARGS, VALUE any] struct {
+	// MStorage 支持批量操作的缓存存储接口。
+	MStorage GenericsMStorage[VALUE]
+
+	// MQuery 没从MStorage里找到的，调用MQuery批量查询。
+	MQuery GenericsMQueryFunc[ARGS, VALUE]
+
+	// TTLRange 缓存生存时间区间。每次随机取一个区间内的值。
+	// 如果缓存对象实现了TTLer接口，优先使用缓存对象的TTL。
+	TTLRange [2]time.Duration
+
+	// sentinelGroup  哨兵机制。
+	sentinelGroup sentinel.SentinelGroup
+
+	// SentinelTTL 哨兵和哨兵持有的临时缓存的生存时间。用来省去双重检查。一般1s即可。
+	// “副作用”是可以避免高频查询找不到的数据。
+	SentinelTTL time.Duration
+}
+
+// MGet 批量查询。
+// keys是缓存key，argsSlice是查询函数的参数序列。
+// 如果全部没找到，或者部分没找到，返回没找到部分的下标，不返回错误。
+// 返回的values的元素数据是共享的，内容不可修改。
+// 可以使用restutils.HitIndexes函数，将没找到部分的下标，转为找到部分的下标。
+func (mcaching *GenericsMCaching[ARGS, VALUE]) MGet(ctx context.Context, keys []string, argsSlice []ARGS) (values []VALUE, missIndexes []int, err error) {
+	if len(keys) != len(argsSlice) {
+		return nil, nil, errors.New("wrong argsSlice")
+	}
+
+	// 第一步，先查缓存
+	cachedValues, cacheMissIndexes, err := mcaching.MStorage.MGet(ctx, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	// 移除失效的缓存 —— 通过可选的Validatable接口
+	cachedValues, cacheMissIndexes, err = removeInvalidGenericsCache(len(keys), cacheMissIndexes, cachedValues)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(cacheMissIndexes) == 0 {
+		// 全部找到
+		return cachedValues, nil, nil
+	}
+
+	// 第二步，调query查询函数，查缓存没命中的
+	// 未命中缓存的查询参数
+	missKeys := make([]string, 0, len(cacheMissIndexes))
+	missArgsSlice := make([]ARGS, 0, len(cacheMissIndexes))
+	for _, missIndex := range cacheMissIndexes {
+		missKeys = append(missKeys, keys[missIndex])
+		missArgsSlice = append(missArgsSlice, argsSlice[missIndex])
+	}
+	// query查询
+	var queriedValues []VALUE
+	// 哨兵机制。同一进程内，同一时间，不同查询同key的数据
+	queryErrs, err := mcaching.sentinelGroup.MDo(ctx, &queriedValues, missKeys, missArgsSlice, func(ctx context.Context, destSlicePtr, argsSlice interface{}) ([]error, error) {
+		doArgsSlice := argsSlice.([]ARGS)
+		values, queryMissIndexes, err := mcaching.MQuery(ctx, doArgsSlice)
+		if err != nil {
+			return nil, err
+		}
+		*destSlicePtr.(*[]VALUE) = values
+
+		var errs []error // 目前这个只会有notfound或者nil
+		for index := range doArgsSlice {
+			if restutils.IntSliceContains(queryMissIndexes, index) {
+				errs = append(errs, resterror.FormatNotFoundError("not found index [%d]", index))
+			} else {
+				errs = append(errs, nil)
+			}
+		}
+		return errs, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	// 延迟删除哨兵（和哨兵持有的临时缓存）
+	// 省去双重检查。
+	time.AfterFunc(mcaching.SentinelTTL, func() {
+		mcaching.sentinelGroup.Delete(missKeys...)
+	})
+
+	// 第三步，query查询到的存起来
+	var queriedKeys = make([]string, 0, len(queriedValues))
+	for queryIndex, missKey := range missKeys {
+		if len(queryErrs) > queryIndex && resterror.IsNotFound(queryErrs[queryIndex]) {
+			// query函数没找到
+		} else {
+			queriedKeys = append(queriedKeys, missKey)
+		}
+	}
+	if len(queriedKeys) != len(queriedValues) {
+		return nil, nil, fmt.Errorf("wrong query result. query keys: %v", missKeys)
+	}
+	// 按TTL分组存储
+	ttls, groups := groupByTTL(len(queriedValues), func(index int) interface{} { return queriedValues[index] }, getTTL(mcaching.TTLRange))
+	for groupIndex, group := range groups {
+		groupKeys, groupValues := queriedKeys, queriedValues
+		if len(groups) > 1 {
+			groupKeys = make([]string, 0, len(group))
+			groupValues = make([]VALUE, 0, len(group))
+			for _, index := range group {
+				groupKeys = append(groupKeys, queriedKeys[index])
+				groupValues = append(groupValues, queriedValues[index])
+			}
+		}
+		err = mcaching.MStorage.MSet(ctx, groupKeys, groupValues, ttls[groupIndex])
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// 第四步，按keys的顺序组合结果
+	var cacheCount, queryCount, queriedCount int
+	values = make([]VALUE, 0, len(keys))
+	for index := range keys {
+		if !restutils.IntSliceContains(cacheMissIndexes, index) { // 缓存命中的
+			if len(cachedValues) <= cacheCount { // 缓存返回数据有问题
+				return nil, nil, errors.New("not enough cache results")
+			}
+			values = append(values, cachedValues[cacheCount])
+			cacheCount++
+		} else { // 缓存没命中的
+			if len(queryErrs) > queryCount && resterror.IsNotFound(queryErrs[queryCount]) { // 允许省去后面的nil
+				// query函数也没找到
+				missIndexes = append(missIndexes, index)
+			} else { // 查询到的
+				if len(queriedValues) <= queriedCount { // query函数返回数据有问题
+					return nil, nil, errors.New("not enough query results")
+				}
+				values = append(values, queriedValues[queriedCount])
+				queriedCount++
+			}
+			queryCount++
+		}
+	}
+
+	return values, missIndexes, nil
+}
+
+// MGetMap 批量查询。逻辑同MGet，返回按key索引的结果，没找到的key不在map中。
+// 返回的values的元素数据是共享的，内容不可修改。
+func (mcaching *GenericsMCaching[ARGS, VALUE]) MGetMap(ctx context.Context, keys []string, argsSlice []ARGS) (values map[string]VALUE, err error) {
+	slice, missIndexes, err := mcaching.MGet(ctx, keys, argsSlice)
+	if err != nil {
+		return nil, err
+	}
+
+	values = make(map[string]VALUE, len(slice))
+	var count, missCount int
+	for index, key := range keys {
+		if missCount < len(missIndexes) && missIndexes[missCount] == index {
+			missCount++
+			continue
+		}
+		if count >= len(slice) {
+			return nil, errors.New("not enough results")
+		}
+		values[key] = slice[count]
+		count++
+	}
+	return values, nil
+}
+
+// removeInvalidGenericsCache 通过可选的Validatable接口来检查缓存对象是否还有效，并移除无效缓存数据。
+func removeInvalidGenericsCache[VALUE any](keysLength int, cacheMissIndexes []int, cachedValues []VALUE) (newCachedValues []VALUE, newCacheMissIndexes []int, err error) {
+	if len(cachedValues) == 0 {
+		return cachedValues, cacheMissIndexes, nil
+	}
+	var zero VALUE
+	if !isGenericsValidatable(&zero) {
+		return cachedValues, cacheMissIndexes, nil
+	}
+
+	newCachedValues = make([]VALUE, 0, len(cachedValues))
+	var cachedCount int
+	for index := 0; index < keysLength; index++ {
+		if restutils.IntSliceContains(cacheMissIndexes, index) {
+			newCacheMissIndexes = append(newCacheMissIndexes, index)
+			continue
+		}
+
+		// 检查各个项，是否还是有效的
+		if cachedCount >= len(cachedValues) {
+			return nil, nil, errors.New("not enough cache results")
+		}
+		value := cachedValues[cachedCount]
+		if validateAndResetGenerics(&value) {
+			newCachedValues = append(newCachedValues, value)
+		} else {
+			newCacheMissIndexes = append(newCacheMissIndexes, index)
+		}
+
+		cachedCount++
+	}
+
+	return newCachedValues, newCacheMissIndexes, nil
+}
+
+// genericsMStorage 将MStorage实现适配为GenericsMStorage实现。
+type genericsMStorage[VALUE any] struct {
+	mstorage MStorage
+}
+
+// NewGenericsMStorage 将MStorage实现（比如lrucache.LRUCache）转为GenericsMStorage实现。
+func NewGenericsMStorage[VALUE any](mstorage MStorage) GenericsMStorage[VALUE] {
+	return genericsMStorage[VALUE]{mstorage: mstorage}
+}
+
+// MGet 实现GenericsMStorage接口。
+func (s genericsMStorage[VALUE]) MGet(ctx context.Context, keys []string) (values []VALUE, missIndexes []int, err error) {
+	missIndexes, err = s.mstorage.MGet(ctx, keys, &values)
+	return values, missIndexes, err
+}
+
+// MSet 实现GenericsMStorage接口。
+func (s genericsMStorage[VALUE]) MSet(ctx context.Context, keys []string, values []VALUE, ttl time.Duration) error {
+	return s.mstorage.MSet(ctx, keys, values, ttl)
+}
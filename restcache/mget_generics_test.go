@@ -0,0 +1,151 @@
+//go:build go1.18
+// +build go1.18
+
+package restcache
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wencan/fastrest/restcache/lrucache"
+)
+
+func TestGenericsMCaching_MGet(t *testing.T) {
+	var queried [][]string
+	mcaching := GenericsMCaching[string, string]{
+		MStorage: NewGenericsMStorage[string](lrucache.NewLRUCache(1000, 10)),
+		MQuery: func(ctx context.Context, argsSlice []string) (values []string, missIndexes []int, err error) {
+			queried = append(queried, argsSlice)
+			for index, args := range argsSlice {
+				if args == "" {
+					missIndexes = append(missIndexes, index)
+					continue
+				}
+				values = append(values, "echo: "+args)
+			}
+			return values, missIndexes, nil
+		},
+		TTLRange:    [2]time.Duration{time.Minute * 4, time.Minute * 6},
+		SentinelTTL: time.Millisecond,
+	}
+
+	keys := []string{"key_1", "key_2", "key_notfound", "key_3"}
+	argsSlice := []string{"1", "2", "", "3"}
+	values, missIndexes, err := mcaching.MGet(context.TODO(), keys, argsSlice)
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"echo: 1", "echo: 2", "echo: 3"}, values)
+		assert.Equal(t, []int{2}, missIndexes)
+	}
+
+	time.Sleep(time.Millisecond * 10) // 等待哨兵删除
+
+	keys = []string{"key_0", "key_1", "key_notfound", "key_3"}
+	argsSlice = []string{"0", "1", "", "3"}
+	values, missIndexes, err = mcaching.MGet(context.TODO(), keys, argsSlice)
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"echo: 0", "echo: 1", "echo: 3"}, values)
+		assert.Equal(t, []int{2}, missIndexes)
+	}
+	// 第二次只查询了未缓存的
+	assert.Equal(t, [][]string{{"1", "2", "", "3"}, {"0", ""}}, queried)
+
+	_, _, err = mcaching.MGet(context.TODO(), keys, argsSlice[:1])
+	assert.NotNil(t, err)
+}
+
+func TestGenericsMCaching_MGetWithValid(t *testing.T) {
+	storage := lrucache.NewLRUCache(1000, 10)
+	keys := []string{"miss_0", "valid_1", "invalid_2", "miss_3", "valid_4", "invalid_5"}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, "miss") {
+			storage.Set(context.TODO(), key, testResponseWithValid{Valid: strings.HasPrefix(key, "valid")}, time.Minute)
+		}
+	}
+
+	mcaching := GenericsMCaching[string, testResponseWithValid]{
+		MStorage: NewGenericsMStorage[testResponseWithValid](storage),
+		MQuery: func(ctx context.Context, argsSlice []string) (values []testResponseWithValid, missIndexes []int, err error) {
+			for index := range argsSlice {
+				missIndexes = append(missIndexes, index)
+			}
+			return nil, missIndexes, nil
+		},
+		TTLRange:    [2]time.Duration{time.Minute * 4, time.Minute * 6},
+		SentinelTTL: time.Second,
+	}
+
+	values, missIndexes, err := mcaching.MGet(context.TODO(), keys, keys)
+	if assert.Nil(t, err) {
+		assert.Equal(t, []int{0, 2, 3, 5}, missIndexes)
+		assert.Len(t, values, 2)
+	}
+}
+
+// testResponseWithPtrValid 指针接收者实现Validatable接口和Resetable接口。
+type testResponseWithPtrValid struct {
+	Valid bool
+
+	Name string
+}
+
+func (resp *testResponseWithPtrValid) IsValidCache() bool {
+	return resp.Valid
+}
+
+func (resp *testResponseWithPtrValid) Reset() {
+	*resp = testResponseWithPtrValid{}
+}
+
+func TestGenericsMCaching_MGetWithPtrValid(t *testing.T) {
+	storage := lrucache.NewLRUCache(1000, 10)
+	keys := []string{"miss_0", "valid_1", "invalid_2", "valid_3"}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, "miss") {
+			storage.Set(context.TODO(), key, testResponseWithPtrValid{Valid: strings.HasPrefix(key, "valid"), Name: key}, time.Minute)
+		}
+	}
+
+	mcaching := GenericsMCaching[string, testResponseWithPtrValid]{
+		MStorage: NewGenericsMStorage[testResponseWithPtrValid](storage),
+		MQuery: func(ctx context.Context, argsSlice []string) (values []testResponseWithPtrValid, missIndexes []int, err error) {
+			for index := range argsSlice {
+				missIndexes = append(missIndexes, index)
+			}
+			return nil, missIndexes, nil
+		},
+		TTLRange:    [2]time.Duration{time.Minute * 4, time.Minute * 6},
+		SentinelTTL: time.Second,
+	}
+
+	values, missIndexes, err := mcaching.MGet(context.TODO(), keys, keys)
+	if assert.Nil(t, err) {
+		assert.Equal(t, []int{0, 2}, missIndexes)
+		assert.Equal(t, []testResponseWithPtrValid{{Valid: true, Name: "valid_1"}, {Valid: true, Name: "valid_3"}}, values)
+	}
+}
+
+func TestGenericsMCaching_MGetMap(t *testing.T) {
+	mcaching := GenericsMCaching[string, string]{
+		MStorage: NewGenericsMStorage[string](lrucache.NewLRUCache(1000, 10)),
+		MQuery: func(ctx context.Context, argsSlice []string) (values []string, missIndexes []int, err error) {
+			for index, req := range argsSlice {
+				if req == "" {
+					missIndexes = append(missIndexes, index)
+					continue
+				}
+				values = append(values, "echo: "+req)
+			}
+			return values, missIndexes, nil
+		},
+		TTLRange:    [2]time.Duration{time.Minute * 4, time.Minute * 6},
+		SentinelTTL: time.Second,
+	}
+
+	values, err := mcaching.MGetMap(context.TODO(), []string{"key_1", "key_2", "key_3"}, []string{"1", "", "3"})
+	if assert.Nil(t, err) {
+		assert.Equal(t, map[string]string{"key_1": "echo: 1", "key_3": "echo: 3"}, values)
+	}
+}
@@ -13,6 +13,7 @@ import (
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"github.com/wencan/fastrest/restcache/lrucache"
 	"github.com/wencan/fastrest/restcache/mock_restcache"
 )
 
@@ -801,3 +802,42 @@ func Test_removeInvalidCache(t *testing.T) {
 		})
 	}
 }
+
+func TestMCaching_MGetMap(t *testing.T) {
+	type Key string
+
+	mcaching := MCaching{
+		MStorage: lrucache.NewLRUCache(1000, 10),
+		MQuery: func(ctx context.Context, destSlicePtr, argsSlice interface{}) (missIndexes []int, err error) {
+			for index, req := range argsSlice.([]string) {
+				if req == "" {
+					missIndexes = append(missIndexes, index)
+					continue
+				}
+				*destSlicePtr.(*[]string) = append(*destSlicePtr.(*[]string), "echo: "+req)
+			}
+			return missIndexes, nil
+		},
+		TTLRange:    [2]time.Duration{time.Minute * 4, time.Minute * 6},
+		SentinelTTL: time.Second,
+	}
+
+	keys := []string{"key_1", "key_2", "key_3"}
+	argsSlice := []string{"1", "", "3"}
+	var resps map[string]string
+	err := mcaching.MGetMap(context.TODO(), &resps, keys, argsSlice)
+	if assert.Nil(t, err) {
+		assert.Equal(t, map[string]string{"key_1": "echo: 1", "key_3": "echo: 3"}, resps)
+	}
+
+	// 已有的map，key为自定义字符串类型
+	resps2 := map[Key]string{"other": "other"}
+	err = mcaching.MGetMap(context.TODO(), &resps2, keys, argsSlice)
+	if assert.Nil(t, err) {
+		assert.Equal(t, map[Key]string{"other": "other", "key_1": "echo: 1", "key_3": "echo: 3"}, resps2)
+	}
+
+	var wrong []string
+	err = mcaching.MGetMap(context.TODO(), &wrong, keys, argsSlice)
+	assert.NotNil(t, err)
+}
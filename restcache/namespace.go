@@ -0,0 +1,287 @@
+package restcache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// namespaceGenerationSuffix 命名空间世代key的后缀。
+	namespaceGenerationSuffix = "#generation"
+
+	// namespaceGenerationTTL 命名空间世代的生存时间。
+	namespaceGenerationTTL = time.Hour * 24 * 365
+
+	// defaultNamespaceCheckInterval 默认的检查命名空间世代的间隔。
+	defaultNamespaceCheckInterval = time.Second
+)
+
+// ErrNoNamespace 没有设置命名空间。
+var ErrNoNamespace = errors.New("namespace is not set")
+
+// errNoGeneration 不能取得命名空间的世代。缓存存储出错且容忍错误，或者熔断期间，不使用缓存。
+var errNoGeneration = errors.New("namespace generation is unavailable")
+
+// namespaceGeneration 命名空间的世代。世代改变后，旧世代的缓存数据不再使用。
+type namespaceGeneration struct {
+	mu sync.Mutex
+
+	// generation 最近一次得知的世代。
+	generation string
+
+	// checkedAt 最近一次检查世代的时间。
+	checkedAt time.Time
+
+	// checking 是否正在从storage检查世代。
+	checking bool
+
+	// checkDone 正在进行的检查结束后关闭。
+	checkDone chan struct{}
+
+	// bumps 改变世代的次数。用于丢弃改变世代之前开始的检查结果。
+	bumps uint64
+}
+
+// namespaceKey 加上命名空间、版本和世代前缀的key。格式为Namespace:Version:世代:key。
+// 如果没有设置命名空间，格式为Version:key。都没设置，返回key。
+func namespaceKey(namespace, version, generation, key string) string {
+	if namespace == "" {
+		if version == "" {
+			return key
+		}
+		return version + ":" + key
+	}
+	return namespace + ":" + version + ":" + generation + ":" + key
+}
+
+// get 取得世代。每隔interval从storage检查一次。如果storage里没有，创建新的世代。
+// allow为false时（熔断期间），不访问storage，只使用已知的世代。
+// 访问storage出错的，返回错误，同时返回已知的世代（可能为空）。
+// 不持有锁访问storage；同一时间只有一个检查，已知世代的，其它的使用已知的世代；没有已知世代的，其它的等待检查结束。
+func (ng *namespaceGeneration) get(ctx context.Context, storage Storage, namespace string, interval time.Duration, allow bool) (generation string, err error) {
+	if interval <= 0 {
+		interval = defaultNamespaceCheckInterval
+	}
+
+	ng.mu.Lock()
+	for ng.generation == "" && ng.checking && allow {
+		// 等待正在进行的首次检查，避免并发创建不同的世代
+		checkDone := ng.checkDone
+		ng.mu.Unlock()
+		select {
+		case <-checkDone:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+		ng.mu.Lock()
+	}
+	known, bumps := ng.generation, ng.bumps
+	if known != "" && (time.Since(ng.checkedAt) < interval || ng.checking || !allow) {
+		ng.mu.Unlock()
+		return known, nil
+	}
+	if !allow {
+		ng.mu.Unlock()
+		return "", errNoGeneration
+	}
+	ng.checking = true
+	ng.checkDone = make(chan struct{})
+	ng.mu.Unlock()
+	defer func() {
+		ng.mu.Lock()
+		defer ng.mu.Unlock()
+		ng.checking = false
+		close(ng.checkDone)
+		if err != nil {
+			return
+		}
+		if ng.bumps != bumps {
+			// 检查期间改变了世代，使用新的世代
+			generation = ng.generation
+			return
+		}
+		ng.generation, ng.checkedAt = generation, time.Now()
+	}()
+
+	found, err := storage.Get(ctx, namespace+namespaceGenerationSuffix, &generation)
+	if err != nil {
+		// 读取出错，继续使用已知的世代
+		return known, err
+	}
+	if !found || generation == "" {
+		// 世代丢失，不能确定旧的数据是否有效，使用新的世代
+		generation, err = newGeneration(ctx, storage, namespace, known)
+		if err != nil {
+			return known, err
+		}
+	}
+	return generation, nil
+}
+
+// bump 使用新的世代。
+func (ng *namespaceGeneration) bump(ctx context.Context, storage Storage, namespace string) error {
+	ng.mu.Lock()
+	known := ng.generation
+	ng.mu.Unlock()
+
+	generation, err := newGeneration(ctx, storage, namespace, known)
+	if err != nil {
+		return err
+	}
+
+	ng.mu.Lock()
+	defer ng.mu.Unlock()
+	ng.generation, ng.checkedAt = generation, time.Now()
+	ng.bumps++
+	return nil
+}
+
+// newGeneration 生成并存储新的世代。新的世代不同于known。
+func newGeneration(ctx context.Context, storage Storage, namespace, known string) (string, error) {
+	generation := strconv.FormatInt(time.Now().UnixNano(), 36)
+	if generation == known {
+		generation += "0"
+	}
+	err := storage.Set(ctx, namespace+namespaceGenerationSuffix, generation, namespaceGenerationTTL)
+	if err != nil {
+		return "", err
+	}
+	return generation, nil
+}
+
+// loadGeneration 取得命名空间的世代。
+// 出错的，经handleStorageError处理（熔断器、观察者）。有已知的世代，继续使用；否则，容忍错误或者熔断期间，返回errNoGeneration，不使用缓存。
+func loadGeneration(ctx context.Context, ng *namespaceGeneration, storage Storage, namespace string, interval time.Duration, breaker *CircuitBreaker, handleStorageError func(ctx context.Context, keys []string, start time.Time, err error) error) (string, error) {
+	start := time.Now()
+	generation, err := ng.get(ctx, storage, namespace, interval, allowStorage(breaker))
+	if err == nil {
+		return generation, nil
+	}
+	if err != errNoGeneration {
+		err = handleStorageError(ctx, []string{namespace + namespaceGenerationSuffix}, start, err)
+	}
+	if generation != "" {
+		return generation, nil
+	}
+	if err == nil {
+		return "", errNoGeneration
+	}
+	return "", err
+}
+
+// storageKey 返回Storage使用的key。
+// 不能取得命名空间世代，且容忍错误或者熔断期间，返回errNoGeneration。
+func (caching *Caching) storageKey(ctx context.Context, key string) (string, error) {
+	var generation string
+	if caching.Namespace != "" {
+		var err error
+		generation, err = caching.generation(ctx)
+		if err != nil {
+			return "", err
+		}
+	}
+	return namespaceKey(caching.Namespace, caching.Version, generation, key), nil
+}
+
+// generation 取得命名空间的世代。
+func (caching *Caching) generation(ctx context.Context) (string, error) {
+	return loadGeneration(ctx, &caching.namespaceGeneration, caching.Storage, caching.Namespace, caching.NamespaceCheckInterval, caching.CircuitBreaker, caching.handleStorageError)
+}
+
+// storageKeys 返回Storage使用的key。
+func (caching *Caching) storageKeys(ctx context.Context, keys []string) ([]string, error) {
+	if caching.Namespace == "" && caching.Version == "" {
+		return keys, nil
+	}
+	var generation string
+	if caching.Namespace != "" {
+		var err error
+		generation, err = caching.generation(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	storageKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		storageKeys = append(storageKeys, namespaceKey(caching.Namespace, caching.Version, generation, key))
+	}
+	return storageKeys, nil
+}
+
+// BumpNamespace 使用命名空间的新世代。全部进程在NamespaceCheckInterval内不再使用命名空间内的旧数据，不需要删除。
+// 旧数据在生存时间后过期。需要设置Namespace。
+func (caching *Caching) BumpNamespace(ctx context.Context) error {
+	if caching.Namespace == "" {
+		return ErrNoNamespace
+	}
+	return caching.namespaceGeneration.bump(ctx, caching.Storage, caching.Namespace)
+}
+
+// storageKeys 返回MStorage使用的key。
+// 不能取得命名空间世代，且容忍错误或者熔断期间，返回errNoGeneration。
+func (mcaching *MCaching) storageKeys(ctx context.Context, keys []string) ([]string, error) {
+	if mcaching.Namespace == "" && mcaching.Version == "" {
+		return keys, nil
+	}
+	var generation string
+	if mcaching.Namespace != "" {
+		var err error
+		generation, err = loadGeneration(ctx, &mcaching.namespaceGeneration, mstorageAsStorage{mcaching.MStorage}, mcaching.Namespace, mcaching.NamespaceCheckInterval, mcaching.CircuitBreaker, mcaching.handleStorageError)
+		if err != nil {
+			return nil, err
+		}
+		if mcaching.RefreshAheadTopN > 0 {
+			// 不再跟踪旧世代的key
+			mcaching.refreshAhead.keepPrefix(namespaceKey(mcaching.Namespace, mcaching.Version, generation, ""))
+		}
+	}
+	storageKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		storageKeys = append(storageKeys, namespaceKey(mcaching.Namespace, mcaching.Version, generation, key))
+	}
+	return storageKeys, nil
+}
+
+// BumpNamespace 使用命名空间的新世代。全部进程在NamespaceCheckInterval内不再使用命名空间内的旧数据，不需要删除。
+// 旧数据在生存时间后过期。需要设置Namespace。
+func (mcaching *MCaching) BumpNamespace(ctx context.Context) error {
+	if mcaching.Namespace == "" {
+		return ErrNoNamespace
+	}
+	return mcaching.namespaceGeneration.bump(ctx, mstorageAsStorage{mcaching.MStorage}, mcaching.Namespace)
+}
+
+// mstorageAsStorage 将MStorage适配为Storage。
+type mstorageAsStorage struct {
+	mstorage MStorage
+}
+
+// Get 实现Storage接口。
+func (s mstorageAsStorage) Get(ctx context.Context, key string, valuePtr interface{}) (found bool, err error) {
+	if storage, ok := s.mstorage.(Storage); ok {
+		return storage.Get(ctx, key, valuePtr)
+	}
+
+	destSlicePtrValue := reflect.New(reflect.SliceOf(reflect.TypeOf(valuePtr).Elem()))
+	missIndexes, err := s.mstorage.MGet(ctx, []string{key}, destSlicePtrValue.Interface())
+	if err != nil || len(missIndexes) > 0 || destSlicePtrValue.Elem().Len() == 0 {
+		return false, err
+	}
+	reflect.ValueOf(valuePtr).Elem().Set(destSlicePtrValue.Elem().Index(0))
+	return true, nil
+}
+
+// Set 实现Storage接口。
+func (s mstorageAsStorage) Set(ctx context.Context, key string, value interface{}, TTL time.Duration) error {
+	if storage, ok := s.mstorage.(Storage); ok {
+		return storage.Set(ctx, key, value, TTL)
+	}
+
+	valueSliceValue := reflect.MakeSlice(reflect.SliceOf(reflect.TypeOf(value)), 0, 1)
+	valueSliceValue = reflect.Append(valueSliceValue, reflect.ValueOf(value))
+	return s.mstorage.MSet(ctx, []string{key}, valueSliceValue.Interface(), TTL)
+}
@@ -0,0 +1,286 @@
+package restcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/wencan/fastrest/restcache/lrucache"
+	"github.com/wencan/fastrest/restcache/mock_restcache"
+)
+
+func TestCaching_Namespace(t *testing.T) {
+	storage := lrucache.NewLRUCache(1000, 10)
+	var queried int
+	newCaching := func(version string) *Caching {
+		return &Caching{
+			Storage: storage,
+			Query: func(ctx context.Context, destPtr, args interface{}) (found bool, err error) {
+				queried++
+				*destPtr.(*string) = version
+				return true, nil
+			},
+			TTLRange:  [2]time.Duration{time.Minute * 4, time.Minute * 6},
+			Namespace: "ns",
+			Version:   version,
+		}
+	}
+
+	caching := newCaching("v1")
+	var resp string
+	for i := 0; i < 2; i++ {
+		found, err := caching.Get(context.TODO(), &resp, "key", nil)
+		if assert.Nil(t, err) && assert.True(t, found) {
+			assert.Equal(t, "v1", resp)
+		}
+	}
+	assert.Equal(t, 1, queried)
+
+	// key加了前缀
+	found, err := storage.Get(context.TODO(), "key", &resp)
+	if assert.Nil(t, err) {
+		assert.False(t, found)
+	}
+
+	// 修改版本，不使用旧版本的数据
+	caching = newCaching("v2")
+	found, err = caching.Get(context.TODO(), &resp, "key", nil)
+	if assert.Nil(t, err) && assert.True(t, found) {
+		assert.Equal(t, "v2", resp)
+	}
+	assert.Equal(t, 2, queried)
+
+	// 失效加了前缀的key
+	err = caching.Invalidate(context.TODO(), "key")
+	assert.Nil(t, err)
+	found, err = caching.Get(context.TODO(), &resp, "key", nil)
+	if assert.Nil(t, err) && assert.True(t, found) {
+		assert.Equal(t, "v2", resp)
+	}
+	assert.Equal(t, 3, queried)
+}
+
+func TestCaching_BumpNamespace(t *testing.T) {
+	storage := lrucache.NewLRUCache(1000, 10)
+	var queried int
+	newCaching := func() *Caching {
+		return &Caching{
+			Storage: storage,
+			Query: func(ctx context.Context, destPtr, args interface{}) (found bool, err error) {
+				queried++
+				*destPtr.(*int) = queried
+				return true, nil
+			},
+			TTLRange:               [2]time.Duration{time.Minute * 4, time.Minute * 6},
+			Namespace:              "ns",
+			NamespaceCheckInterval: time.Millisecond * 10,
+		}
+	}
+	caching1, caching2 := newCaching(), newCaching()
+
+	var resp int
+	found, err := caching1.Get(context.TODO(), &resp, "key", nil)
+	if assert.Nil(t, err) && assert.True(t, found) {
+		assert.Equal(t, 1, resp)
+	}
+	// 同一命名空间，共享数据
+	found, err = caching2.Get(context.TODO(), &resp, "key", nil)
+	if assert.Nil(t, err) && assert.True(t, found) {
+		assert.Equal(t, 1, resp)
+	}
+
+	// 新的世代，不再使用旧数据
+	err = caching1.BumpNamespace(context.TODO())
+	assert.Nil(t, err)
+	found, err = caching1.Get(context.TODO(), &resp, "key", nil)
+	if assert.Nil(t, err) && assert.True(t, found) {
+		assert.Equal(t, 2, resp)
+	}
+
+	// 其它实例在检查间隔后，使用新的世代
+	time.Sleep(time.Millisecond * 20)
+	found, err = caching2.Get(context.TODO(), &resp, "key", nil)
+	if assert.Nil(t, err) && assert.True(t, found) {
+		assert.Equal(t, 2, resp)
+	}
+	assert.Equal(t, 2, queried)
+
+	// 没有命名空间
+	err = (&Caching{Storage: storage}).BumpNamespace(context.TODO())
+	assert.Equal(t, ErrNoNamespace, err)
+}
+
+func TestMCaching_BumpNamespace(t *testing.T) {
+	var queried [][]string
+	mcaching := MCaching{
+		MStorage: lrucache.NewLRUCache(1000, 10),
+		MQuery: func(ctx context.Context, destSlicePtr, argsSlice interface{}) (missIndexes []int, err error) {
+			reqs := argsSlice.([]string)
+			queried = append(queried, reqs)
+			for _, req := range reqs {
+				*destSlicePtr.(*[]string) = append(*destSlicePtr.(*[]string), "echo: "+req)
+			}
+			return nil, nil
+		},
+		TTLRange:  [2]time.Duration{time.Minute * 4, time.Minute * 6},
+		Namespace: "ns",
+		Version:   "v1",
+	}
+
+	keys := []string{"key_1", "key_2"}
+	for i := 0; i < 3; i++ {
+		var resps []string
+		missIndexes, err := mcaching.MGet(context.TODO(), &resps, keys, keys)
+		if assert.Nil(t, err) {
+			assert.Empty(t, missIndexes)
+			assert.Equal(t, []string{"echo: key_1", "echo: key_2"}, resps)
+		}
+
+		switch i {
+		case 0:
+			err = mcaching.BumpNamespace(context.TODO())
+			assert.Nil(t, err)
+		case 1:
+			err = mcaching.Invalidate(context.TODO(), "key_2")
+			assert.Nil(t, err)
+		}
+	}
+	assert.Equal(t, [][]string{{"key_1", "key_2"}, {"key_1", "key_2"}, {"key_2"}}, queried)
+}
+
+func TestCaching_NamespaceStorageError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	storageErr := errors.New("storage error")
+	mockStorage := mock_restcache.NewMockStorage(ctrl)
+	// 读取世代出错两次后熔断，不再调用Storage
+	mockStorage.EXPECT().Get(gomock.Any(), "ns"+namespaceGenerationSuffix, gomock.Any()).Return(false, storageErr).Times(2)
+
+	var queryCount int
+	var storageErrors int
+	caching := Caching{
+		Storage: mockStorage,
+		Query: func(ctx context.Context, destPtr, args interface{}) (found bool, err error) {
+			queryCount++
+			*destPtr.(*string) = "echo"
+			return true, nil
+		},
+		TTLRange:  [2]time.Duration{time.Minute * 4, time.Minute * 6},
+		Namespace: "ns",
+		Observer: ObserverFunc(func(ctx context.Context, event Event) {
+			if event.Type == EventStorageError {
+				storageErrors++
+			}
+		}),
+		TolerateStorageError: true,
+		CircuitBreaker:       &CircuitBreaker{FailureThreshold: 2, CoolDown: time.Minute},
+	}
+
+	// 容忍错误，不使用缓存直接查询
+	for i := 0; i < 3; i++ {
+		var resp string
+		found, err := caching.Get(context.TODO(), &resp, "key", nil)
+		if assert.Nil(t, err) && assert.True(t, found) {
+			assert.Equal(t, "echo", resp)
+		}
+	}
+	assert.Equal(t, 3, queryCount)
+	assert.Equal(t, 2, storageErrors)
+
+	err := caching.Invalidate(context.TODO(), "key")
+	assert.Nil(t, err)
+}
+
+func TestMCaching_NamespaceStorageError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	storageErr := errors.New("storage error")
+	mockStorage := mock_restcache.NewMockMStorage(ctrl)
+	mockStorage.EXPECT().MGet(gomock.Any(), []string{"ns" + namespaceGenerationSuffix}, gomock.Any()).Return(nil, storageErr).Times(1)
+
+	var queryCount int
+	newMCaching := func(tolerate bool) *MCaching {
+		return &MCaching{
+			MStorage: mockStorage,
+			MQuery: func(ctx context.Context, destSlicePtr, argsSlice interface{}) (missIndexes []int, err error) {
+				queryCount++
+				for _, args := range argsSlice.([]string) {
+					*destSlicePtr.(*[]string) = append(*destSlicePtr.(*[]string), "echo: "+args)
+				}
+				return nil, nil
+			},
+			TTLRange:             [2]time.Duration{time.Minute * 4, time.Minute * 6},
+			Namespace:            "ns",
+			TolerateStorageError: tolerate,
+		}
+	}
+
+	// 容忍错误，不使用缓存直接查询
+	var resps []string
+	missIndexes, err := newMCaching(true).MGet(context.TODO(), &resps, []string{"key_1", "key_2"}, []string{"1", "2"})
+	if assert.Nil(t, err) {
+		assert.Empty(t, missIndexes)
+		assert.Equal(t, []string{"echo: 1", "echo: 2"}, resps)
+	}
+	assert.Equal(t, 1, queryCount)
+
+	// 不容忍错误，返回错误
+	mockStorage.EXPECT().MGet(gomock.Any(), []string{"ns" + namespaceGenerationSuffix}, gomock.Any()).Return(nil, storageErr).Times(1)
+	_, err = newMCaching(false).MGet(context.TODO(), &resps, []string{"key_1"}, []string{"1"})
+	assert.Equal(t, storageErr, err)
+}
+
+// testSlowStorage 查询较慢的存储。统计存储命名空间世代的次数。
+type testSlowStorage struct {
+	*lrucache.LRUCache
+
+	generationSets int64
+}
+
+func (storage *testSlowStorage) Get(ctx context.Context, key string, valuePtr interface{}) (found bool, err error) {
+	found, err = storage.LRUCache.Get(ctx, key, valuePtr)
+	time.Sleep(time.Millisecond * 20)
+	return found, err
+}
+
+func (storage *testSlowStorage) Set(ctx context.Context, key string, value interface{}, TTL time.Duration) error {
+	if key == "ns"+namespaceGenerationSuffix {
+		atomic.AddInt64(&storage.generationSets, 1)
+	}
+	return storage.LRUCache.Set(ctx, key, value, TTL)
+}
+
+func TestCaching_NamespaceColdStart(t *testing.T) {
+	storage := &testSlowStorage{LRUCache: lrucache.NewLRUCache(1000, 10)}
+	caching := Caching{
+		Storage:   storage,
+		Namespace: "ns",
+	}
+
+	// 并发的首次查询，只创建一个世代
+	var mu sync.Mutex
+	generations := make(map[string]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			generation, err := caching.generation(context.TODO())
+			if assert.Nil(t, err) {
+				mu.Lock()
+				generations[generation] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, generations, 1)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&storage.generationSets))
+}
@@ -0,0 +1,82 @@
+package restcache
+
+import (
+	"context"
+	"time"
+
+	"github.com/wencan/fastrest/restutils"
+)
+
+// notFoundTombstone 没找到的数据的标记（墓碑）。
+// 同数据分开存储，key为数据key加上notFoundKeySuffix后缀，避免影响数据的类型。
+type notFoundTombstone struct {
+	NotFound bool `json:"not_found" msgpack:"not_found"`
+}
+
+// notFoundKeySuffix 墓碑key的后缀。
+const notFoundKeySuffix = "#notfound"
+
+// notFoundKey 返回数据key对应的墓碑key。
+func notFoundKey(key string) string {
+	return key + notFoundKeySuffix
+}
+
+// getNotFoundTombstone 查询是否存在没找到的标记。
+func getNotFoundTombstone(ctx context.Context, storage Storage, key string) (notFound bool, err error) {
+	var tombstone notFoundTombstone
+	found, err := storage.Get(ctx, notFoundKey(key), &tombstone)
+	if err != nil {
+		return false, err
+	}
+	return found && tombstone.NotFound, nil
+}
+
+// setNotFoundTombstone 存储没找到的标记。
+func setNotFoundTombstone(ctx context.Context, storage Storage, key string, TTL time.Duration) error {
+	return storage.Set(ctx, notFoundKey(key), notFoundTombstone{NotFound: true}, TTL)
+}
+
+// mgetNotFoundTombstones 批量查询没找到的标记。
+// 从缓存未命中的下标中，分离出存在没找到标记的下标。
+func mgetNotFoundTombstones(ctx context.Context, mstorage MStorage, keys []string, cacheMissIndexes []int) (newCacheMissIndexes []int, notFoundIndexes []int, err error) {
+	tombstoneKeys := make([]string, 0, len(cacheMissIndexes))
+	for _, missIndex := range cacheMissIndexes {
+		tombstoneKeys = append(tombstoneKeys, notFoundKey(keys[missIndex]))
+	}
+
+	var tombstones []notFoundTombstone
+	tombstoneMissIndexes, err := mstorage.MGet(ctx, tombstoneKeys, &tombstones)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var tombstoneCount int
+	for index, missIndex := range cacheMissIndexes {
+		if restutils.IntSliceContains(tombstoneMissIndexes, index) {
+			newCacheMissIndexes = append(newCacheMissIndexes, missIndex)
+			continue
+		}
+		if tombstoneCount < len(tombstones) && tombstones[tombstoneCount].NotFound {
+			notFoundIndexes = append(notFoundIndexes, missIndex)
+		} else {
+			newCacheMissIndexes = append(newCacheMissIndexes, missIndex)
+		}
+		tombstoneCount++
+	}
+	return newCacheMissIndexes, notFoundIndexes, nil
+}
+
+// msetNotFoundTombstones 批量存储没找到的标记。
+func msetNotFoundTombstones(ctx context.Context, mstorage MStorage, notFoundKeys []string, ttl time.Duration) error {
+	if len(notFoundKeys) == 0 {
+		return nil
+	}
+
+	tombstoneKeys := make([]string, 0, len(notFoundKeys))
+	tombstones := make([]notFoundTombstone, 0, len(notFoundKeys))
+	for _, key := range notFoundKeys {
+		tombstoneKeys = append(tombstoneKeys, notFoundKey(key))
+		tombstones = append(tombstones, notFoundTombstone{NotFound: true})
+	}
+	return mstorage.MSet(ctx, tombstoneKeys, tombstones, ttl)
+}
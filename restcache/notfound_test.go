@@ -0,0 +1,88 @@
+package restcache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wencan/fastrest/restcache/lrucache"
+)
+
+func TestCaching_GetNotFoundTombstone(t *testing.T) {
+	var queryCount int
+	caching := Caching{
+		Storage: lrucache.NewLRUCache(1000, 10),
+		Query: func(ctx context.Context, destPtr, args interface{}) (found bool, err error) {
+			queryCount++
+			return false, nil
+		},
+		TTLRange:    [2]time.Duration{time.Minute * 4, time.Minute * 6},
+		NotFoundTTL: time.Millisecond * 200,
+	}
+
+	for i := 0; i < 3; i++ {
+		var resp string
+		found, err := caching.Get(context.TODO(), &resp, "notfound", nil)
+		if assert.Nil(t, err) {
+			assert.False(t, found)
+		}
+		time.Sleep(time.Millisecond * 10) // 等待哨兵删除
+	}
+	assert.Equal(t, 1, queryCount)
+
+	// 标记过期后，重新查询
+	time.Sleep(time.Millisecond * 1200)
+	var resp string
+	found, err := caching.Get(context.TODO(), &resp, "notfound", nil)
+	if assert.Nil(t, err) {
+		assert.False(t, found)
+	}
+	assert.Equal(t, 2, queryCount)
+}
+
+func TestMCaching_MGetNotFoundTombstone(t *testing.T) {
+	var queried [][]string
+	mcaching := MCaching{
+		MStorage: lrucache.NewLRUCache(1000, 10),
+		MQuery: func(ctx context.Context, destSlicePtr, argsSlice interface{}) (missIndexes []int, err error) {
+			reqs := argsSlice.([]string)
+			resps := destSlicePtr.(*[]string)
+			queried = append(queried, reqs)
+			for index, req := range reqs {
+				if req == "" {
+					missIndexes = append(missIndexes, index)
+				} else {
+					*resps = append(*resps, "echo: "+req)
+				}
+			}
+			return missIndexes, nil
+		},
+		TTLRange:    [2]time.Duration{time.Minute * 4, time.Minute * 6},
+		NotFoundTTL: time.Minute,
+	}
+
+	keys := []string{"key_1", "key_notfound_1", "key_2", "key_notfound_2"}
+	args := []string{"1", "", "2", ""}
+	for i := 0; i < 2; i++ {
+		var resps []string
+		missIndexes, err := mcaching.MGet(context.TODO(), &resps, keys, args)
+		if assert.Nil(t, err) {
+			assert.Equal(t, []int{1, 3}, missIndexes)
+			assert.Equal(t, []string{"echo: 1", "echo: 2"}, resps)
+		}
+		time.Sleep(time.Millisecond * 10) // 等待哨兵删除
+	}
+
+	// 部分命中缓存，部分有没找到的标记，部分需要查询
+	keys = []string{"key_notfound_1", "key_3", "key_1"}
+	args = []string{"", "3", "1"}
+	var resps []string
+	missIndexes, err := mcaching.MGet(context.TODO(), &resps, keys, args)
+	if assert.Nil(t, err) {
+		assert.Equal(t, []int{0}, missIndexes)
+		assert.Equal(t, []string{"echo: 3", "echo: 1"}, resps)
+	}
+
+	assert.Equal(t, [][]string{{"1", "", "2", ""}, {"3"}}, queried)
+}
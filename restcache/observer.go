@@ -0,0 +1,92 @@
+package restcache
+
+import (
+	"context"
+	"time"
+)
+
+// EventType 缓存事件类型。
+type EventType int
+
+const (
+	// EventHit 缓存命中。Duration为查询存储的耗时。
+	EventHit EventType = iota + 1
+
+	// EventMiss 缓存未命中。Duration为查询存储的耗时。
+	EventMiss
+
+	// EventInvalid 缓存数据已失效。通过Validatable接口检查。
+	EventInvalid
+
+	// EventQueryStart 开始调用查询函数。
+	EventQueryStart
+
+	// EventQueryFinish 查询函数返回。Duration为查询函数的耗时，Err为查询函数返回的错误。
+	EventQueryFinish
+
+	// EventCoalesced 通过哨兵机制，等待了其它调用的查询结果，没有调用查询函数。Duration为等待的耗时。
+	EventCoalesced
+
+	// EventStorageError 缓存存储返回错误。Duration为存储操作的耗时，Err为存储返回的错误。
+	EventStorageError
+)
+
+// String 实现fmt.Stringer接口。
+func (eventType EventType) String() string {
+	switch eventType {
+	case EventHit:
+		return "hit"
+	case EventMiss:
+		return "miss"
+	case EventInvalid:
+		return "invalid"
+	case EventQueryStart:
+		return "query_start"
+	case EventQueryFinish:
+		return "query_finish"
+	case EventCoalesced:
+		return "coalesced"
+	case EventStorageError:
+		return "storage_error"
+	default:
+		return "unknown"
+	}
+}
+
+// Event 缓存事件。
+type Event struct {
+	// Type 事件类型。
+	Type EventType
+
+	// Keys 事件涉及的缓存key。单个数据的事件只有一个key，批量操作的事件可能有多个key。
+	Keys []string
+
+	// Duration 耗时。含义见事件类型的说明。
+	Duration time.Duration
+
+	// Err 错误。含义见事件类型的说明。
+	Err error
+}
+
+// Observer 缓存事件观察者接口。用于统计命中率、查询耗时等。
+// 实现逻辑应该尽快返回，不应该阻塞缓存流程。
+type Observer interface {
+	// Observe 接收缓存事件。
+	Observe(ctx context.Context, event Event)
+}
+
+// ObserverFunc 将一个函数转为Observer接口实现。
+type ObserverFunc func(ctx context.Context, event Event)
+
+// Observe 实现Observer接口。
+func (f ObserverFunc) Observe(ctx context.Context, event Event) {
+	f(ctx, event)
+}
+
+// observe 如果有观察者，通知事件。
+func observe(ctx context.Context, observer Observer, eventType EventType, keys []string, duration time.Duration, err error) {
+	if observer == nil || len(keys) == 0 {
+		return
+	}
+	observer.Observe(ctx, Event{Type: eventType, Keys: keys, Duration: duration, Err: err})
+}
@@ -0,0 +1,152 @@
+package restcache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/wencan/fastrest/restcache/lrucache"
+	"github.com/wencan/fastrest/restcache/mock_restcache"
+)
+
+// testEventRecorder 记录缓存事件。
+type testEventRecorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (recorder *testEventRecorder) Observe(ctx context.Context, event Event) {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	recorder.events = append(recorder.events, event)
+}
+
+// types 事件类型和key。
+func (recorder *testEventRecorder) types() []string {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	var types []string
+	for _, event := range recorder.events {
+		types = append(types, event.Type.String()+":"+strings.Join(event.Keys, ","))
+	}
+	return types
+}
+
+func TestCaching_Observer(t *testing.T) {
+	recorder := &testEventRecorder{}
+	querying := make(chan struct{})
+	caching := Caching{
+		Storage: lrucache.NewLRUCache(1000, 10),
+		Query: func(ctx context.Context, destPtr, args interface{}) (found bool, err error) {
+			<-querying
+			*destPtr.(*string) = "echo"
+			return true, nil
+		},
+		TTLRange:    [2]time.Duration{time.Minute * 4, time.Minute * 6},
+		SentinelTTL: time.Millisecond,
+		Observer:    recorder,
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var resp string
+			found, err := caching.Get(context.TODO(), &resp, "key", nil)
+			if assert.Nil(t, err) {
+				assert.True(t, found)
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond * 100)
+	close(querying)
+	wg.Wait()
+
+	var resp string
+	found, err := caching.Get(context.TODO(), &resp, "key", nil)
+	if assert.Nil(t, err) {
+		assert.True(t, found)
+	}
+
+	assert.ElementsMatch(t, []string{"miss:key", "miss:key", "query_start:key", "query_finish:key", "coalesced:key", "hit:key"}, recorder.types())
+}
+
+func TestCaching_ObserverStorageError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	storageErr := errors.New("storage error")
+	mockStorage := mock_restcache.NewMockStorage(ctrl)
+	mockStorage.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(false, storageErr)
+
+	recorder := &testEventRecorder{}
+	caching := Caching{Storage: mockStorage, Observer: recorder}
+	var resp string
+	_, err := caching.Get(context.TODO(), &resp, "key", nil)
+	assert.Equal(t, storageErr, err)
+	if assert.Len(t, recorder.events, 1) {
+		assert.Equal(t, EventStorageError, recorder.events[0].Type)
+		assert.Equal(t, storageErr, recorder.events[0].Err)
+	}
+}
+
+func TestMCaching_Observer(t *testing.T) {
+	storage := lrucache.NewLRUCache(1000, 10)
+	storage.Set(context.TODO(), "valid_1", testResponseWithValid{Valid: true}, time.Minute)
+	storage.Set(context.TODO(), "invalid_2", testResponseWithValid{Valid: false}, time.Minute)
+
+	recorder := &testEventRecorder{}
+	mcaching := MCaching{
+		MStorage: storage,
+		MQuery: func(ctx context.Context, destSlicePtr, argsSlice interface{}) (missIndexes []int, err error) {
+			for range argsSlice.([]string) {
+				*destSlicePtr.(*[]testResponseWithValid) = append(*destSlicePtr.(*[]testResponseWithValid), testResponseWithValid{Valid: true})
+			}
+			return nil, nil
+		},
+		TTLRange:    [2]time.Duration{time.Minute * 4, time.Minute * 6},
+		SentinelTTL: time.Millisecond,
+		Observer:    recorder,
+	}
+
+	keys := []string{"miss_0", "valid_1", "invalid_2"}
+	var resps []testResponseWithValid
+	missIndexes, err := mcaching.MGet(context.TODO(), &resps, keys, keys)
+	if assert.Nil(t, err) {
+		assert.Empty(t, missIndexes)
+		assert.Len(t, resps, 3)
+	}
+
+	assert.Equal(t, []string{
+		"hit:valid_1,invalid_2",
+		"miss:miss_0",
+		"invalid:invalid_2",
+		"query_start:miss_0,invalid_2",
+		"query_finish:miss_0,invalid_2",
+	}, recorder.types())
+}
+
+// expvarObserverTests 已执行TestExpvarObserver的次数。expvar不能重复发布同名变量，用于go test -count=N。
+var expvarObserverTests int64
+
+func TestExpvarObserver(t *testing.T) {
+	observer := NewExpvarObserver("test_restcache_observer_" + strconv.FormatInt(atomic.AddInt64(&expvarObserverTests, 1), 10))
+	observer.Observe(context.TODO(), Event{Type: EventHit, Keys: []string{"a", "b"}})
+	observer.Observe(context.TODO(), Event{Type: EventMiss, Keys: []string{"c"}})
+	observer.Observe(context.TODO(), Event{Type: EventQueryFinish, Keys: []string{"c"}, Duration: time.Second, Err: errors.New("query error")})
+
+	assert.Equal(t, "2", observer.Vars().Get("hit").String())
+	assert.Equal(t, "1", observer.Vars().Get("miss").String())
+	assert.Equal(t, "1", observer.Vars().Get("query_finish").String())
+	assert.Equal(t, "1", observer.Vars().Get("query_error").String())
+	assert.Equal(t, "1000000000", observer.Vars().Get("query_nanoseconds").String())
+}
@@ -1,5 +1,7 @@
 package restcache
 
+import "time"
+
 // Validatable 缓存有效性检查接口。缓存对象可选实现。
 // 警告：注意实现中ValidCache方法的接收者，一般应该是结构体对象，而不是结构体指针。
 // 失效的缓存对象可能会影响结果，可选实现Resetable接口。
@@ -14,3 +16,11 @@ type Resetable interface {
 	// Reset 重置。
 	Reset()
 }
+
+// TTLer 自定义缓存生存时间的接口。缓存对象可选实现。
+// 警告：注意实现中CacheTTL方法的接收者，一般应该是结构体对象，而不是结构体指针。
+type TTLer interface {
+	// CacheTTL 返回缓存对象的生存时间。
+	// 如果小于等于0，使用TTLRange。
+	CacheTTL() time.Duration
+}
@@ -96,3 +96,74 @@ func TestMGetWithValid(t *testing.T) {
 		assert.Equal(t, []int{0, 2, 3, 5}, missIndexes)
 	}
 }
+
+type testResponseWithTTL struct {
+	Name string
+	TTL  time.Duration
+}
+
+func (resp testResponseWithTTL) CacheTTL() time.Duration {
+	return resp.TTL
+}
+
+func TestGetWithTTL(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.TODO()
+	mockStorage := mock_restcache.NewMockStorage(ctrl)
+	mockStorage.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(false, nil).AnyTimes()
+	mockStorage.EXPECT().Set(gomock.Any(), "config", gomock.Any(), time.Hour).Return(nil)
+	mockStorage.EXPECT().Set(gomock.Any(), "default", gomock.Any(), time.Minute).Return(nil)
+
+	caching := Caching{
+		Storage: mockStorage,
+		Query: func(ctx context.Context, destPtr, args interface{}) (found bool, err error) {
+			*destPtr.(*testResponseWithTTL) = args.(testResponseWithTTL)
+			return true, nil
+		},
+		TTLRange: [2]time.Duration{time.Minute, time.Minute},
+	}
+	var response testResponseWithTTL
+	found, err := caching.Get(ctx, &response, "config", testResponseWithTTL{Name: "config", TTL: time.Hour})
+	if assert.Nil(t, err) {
+		assert.True(t, found)
+	}
+	found, err = caching.Get(ctx, &response, "default", testResponseWithTTL{Name: "default"})
+	if assert.Nil(t, err) {
+		assert.True(t, found)
+	}
+}
+
+func TestMGetWithTTL(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := mock_restcache.NewMockMStorage(ctrl)
+	mockStorage.EXPECT().MGet(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, keys []string, destSlicePtr interface{}) (missIndexes []int, err error) {
+		for index := range keys {
+			missIndexes = append(missIndexes, index)
+		}
+		return missIndexes, nil
+	})
+	mockStorage.EXPECT().MSet(gomock.Any(), []string{"config", "config2"}, []testResponseWithTTL{{Name: "config", TTL: time.Hour}, {Name: "config2", TTL: time.Hour}}, time.Hour).Return(nil)
+	mockStorage.EXPECT().MSet(gomock.Any(), []string{"inventory"}, []testResponseWithTTL{{Name: "inventory", TTL: time.Second}}, time.Second).Return(nil)
+	mockStorage.EXPECT().MSet(gomock.Any(), []string{"default"}, []testResponseWithTTL{{Name: "default"}}, time.Minute).Return(nil)
+
+	mcaching := MCaching{
+		MStorage: mockStorage,
+		MQuery: func(ctx context.Context, destSlicePtr, argsSlice interface{}) (missIndexes []int, err error) {
+			*destSlicePtr.(*[]testResponseWithTTL) = argsSlice.([]testResponseWithTTL)
+			return nil, nil
+		},
+		TTLRange: [2]time.Duration{time.Minute, time.Minute},
+	}
+	keys := []string{"config", "inventory", "default", "config2"}
+	argsSlice := []testResponseWithTTL{{Name: "config", TTL: time.Hour}, {Name: "inventory", TTL: time.Second}, {Name: "default"}, {Name: "config2", TTL: time.Hour}}
+	var responses []testResponseWithTTL
+	missIndexes, err := mcaching.MGet(context.TODO(), &responses, keys, argsSlice)
+	if assert.Nil(t, err) {
+		assert.Empty(t, missIndexes)
+		assert.Equal(t, argsSlice, responses)
+	}
+}
@@ -0,0 +1,107 @@
+package rediscache
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/wencan/fastrest/restcodecs/restjson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec 缓存数据的序列化接口。
+type Codec interface {
+	// Marshal 序列化。v为Storage.Set的value参数。
+	Marshal(v interface{}) ([]byte, error)
+
+	// Unmarshal 反序列化。v为Storage.Get的valuePtr参数，或者是MStorage.MGet的切片元素的指针。
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// CodecFuncs 将一对序列化函数转为Codec接口实现。
+type CodecFuncs struct {
+	MarshalFunc func(v interface{}) ([]byte, error)
+
+	UnmarshalFunc func(data []byte, v interface{}) error
+}
+
+// Marshal 实现Codec接口。
+func (funcs CodecFuncs) Marshal(v interface{}) ([]byte, error) {
+	return funcs.MarshalFunc(v)
+}
+
+// Unmarshal 实现Codec接口。
+func (funcs CodecFuncs) Unmarshal(data []byte, v interface{}) error {
+	return funcs.UnmarshalFunc(data, v)
+}
+
+// JsonCodec 基于restjson的json序列化。
+var JsonCodec Codec = CodecFuncs{
+	MarshalFunc: func(v interface{}) ([]byte, error) {
+		return restjson.Marshal(v)
+	},
+	UnmarshalFunc: func(data []byte, v interface{}) error {
+		return restjson.Unmarshal(data, v)
+	},
+}
+
+// MsgpackCodec msgpack序列化。
+var MsgpackCodec Codec = CodecFuncs{
+	MarshalFunc:   msgpack.Marshal,
+	UnmarshalFunc: msgpack.Unmarshal,
+}
+
+// BytesCodec 不序列化，原样存取[]byte。
+// 缓存数据类型应该是[]byte，比如作为envelope.EnvelopeStorage的内层缓存存储。
+var BytesCodec Codec = CodecFuncs{
+	MarshalFunc: func(v interface{}) ([]byte, error) {
+		data, ok := v.([]byte)
+		if !ok {
+			return nil, errors.New("value is not a []byte")
+		}
+		return data, nil
+	},
+	UnmarshalFunc: func(data []byte, v interface{}) error {
+		dataPtr, ok := v.(*[]byte)
+		if !ok || dataPtr == nil {
+			return errors.New("value is not a *[]byte")
+		}
+		*dataPtr = append([]byte(nil), data...)
+		return nil
+	},
+}
+
+// ProtobufCodec protobuf序列化。
+// 缓存数据类型应该是proto.Message实现，一般是protoc生成的结构体指针。
+var ProtobufCodec Codec = CodecFuncs{
+	MarshalFunc: func(v interface{}) ([]byte, error) {
+		message, ok := v.(proto.Message)
+		if !ok {
+			return nil, errors.New("value is not a proto.Message")
+		}
+		return proto.Marshal(message)
+	},
+	UnmarshalFunc: func(data []byte, v interface{}) error {
+		message, ok := v.(proto.Message)
+		if ok {
+			return proto.Unmarshal(data, message)
+		}
+
+		// v是*proto.Message，一般是**XxxMessage
+		value := reflect.ValueOf(v)
+		if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Ptr {
+			return errors.New("value is not a proto.Message or pointer to proto.Message")
+		}
+		messageValue := reflect.New(value.Elem().Type().Elem())
+		message, ok = messageValue.Interface().(proto.Message)
+		if !ok {
+			return errors.New("value is not a proto.Message or pointer to proto.Message")
+		}
+		err := proto.Unmarshal(data, message)
+		if err != nil {
+			return err
+		}
+		value.Elem().Set(messageValue)
+		return nil
+	},
+}
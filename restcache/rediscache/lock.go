@@ -0,0 +1,53 @@
+package rediscache
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/wencan/fastrest/restcache"
+)
+
+// unlockScript 只有token相同时才删除锁，避免释放其它持有者的锁。
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// RedisLocker 基于redis SET NX的分布式锁。
+// 实现了github.com/wencan/fastrest/restcache的Locker接口。
+type RedisLocker struct {
+	client redis.Cmdable
+
+	keyPrefix string
+}
+
+// NewRedisLocker 创建redis分布式锁。
+// client 为redis客户端，支持*redis.Client、*redis.ClusterClient等。
+// keyPrefix 为锁key的前缀。
+func NewRedisLocker(client redis.Cmdable, keyPrefix string) *RedisLocker {
+	return &RedisLocker{
+		client:    client,
+		keyPrefix: keyPrefix,
+	}
+}
+
+// TryLock 实现github.com/wencan/fastrest/restcache的Locker接口。
+func (locker *RedisLocker) TryLock(ctx context.Context, key string, TTL time.Duration) (token string, locked bool, err error) {
+	token, err = restcache.NewLockToken()
+	if err != nil {
+		return "", false, err
+	}
+	locked, err = locker.client.SetNX(ctx, locker.keyPrefix+key, token, TTL).Result()
+	if err != nil || !locked {
+		return "", false, err
+	}
+	return token, true, nil
+}
+
+// Unlock 实现github.com/wencan/fastrest/restcache的Locker接口。
+func (locker *RedisLocker) Unlock(ctx context.Context, key string, token string) error {
+	return unlockScript.Run(ctx, locker.client, []string{locker.keyPrefix + key}, token).Err()
+}
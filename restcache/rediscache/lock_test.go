@@ -0,0 +1,50 @@
+package rediscache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wencan/fastrest/restcache"
+)
+
+var _ restcache.Locker = (*RedisLocker)(nil)
+
+func TestRedisLocker(t *testing.T) {
+	s, client := newTestRedisClient(t)
+	locker := NewRedisLocker(client, "lock:")
+
+	token, locked, err := locker.TryLock(context.TODO(), "key", time.Second)
+	if assert.Nil(t, err) {
+		assert.True(t, locked)
+		assert.NotEmpty(t, token)
+	}
+	assert.True(t, s.Exists("lock:key"))
+
+	// 已被持有
+	_, locked, err = locker.TryLock(context.TODO(), "key", time.Second)
+	if assert.Nil(t, err) {
+		assert.False(t, locked)
+	}
+
+	// 错误的token不能释放
+	err = locker.Unlock(context.TODO(), "key", "wrong")
+	assert.Nil(t, err)
+	assert.True(t, s.Exists("lock:key"))
+
+	err = locker.Unlock(context.TODO(), "key", token)
+	assert.Nil(t, err)
+	assert.False(t, s.Exists("lock:key"))
+
+	// 自动释放
+	_, locked, err = locker.TryLock(context.TODO(), "key", time.Second)
+	if assert.Nil(t, err) {
+		assert.True(t, locked)
+	}
+	s.FastForward(time.Second * 2)
+	_, locked, err = locker.TryLock(context.TODO(), "key", time.Second)
+	if assert.Nil(t, err) {
+		assert.True(t, locked)
+	}
+}
@@ -0,0 +1,265 @@
+package rediscache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisCache 基于redis的缓存存储。
+// 实现了github.com/wencan/fastrest/restcache的Storage接口和MStorage接口，以及Deleter接口、MDeleter接口和PrefixDeleter接口。
+// 没有实现TagIndexer接口：缓存对象的标签被忽略，InvalidateTag返回restcache.ErrTagNotSupported。
+// 需要标签失效的，可以在上层放一层实现了TagIndexer接口的进程内缓存存储，比如tiered.TieredStorage加lrucache.LRUCache。
+type RedisCache struct {
+	client redis.Cmdable
+
+	codec Codec
+
+	keyPrefix string
+}
+
+// NewRedisCache 创建redis缓存存储。
+// client 为redis客户端，支持*redis.Client、*redis.ClusterClient等。
+// 如果是*redis.ClusterClient，批量查询和删除通过pipeline逐key执行，key可以分布在不同的slot。
+// codec 为缓存数据的序列化实现。默认为：JsonCodec。
+// keyPrefix 为存储时给key添加的前缀。
+func NewRedisCache(client redis.Cmdable, codec Codec, keyPrefix string) *RedisCache {
+	if codec == nil {
+		codec = JsonCodec
+	}
+	return &RedisCache{
+		client:    client,
+		codec:     codec,
+		keyPrefix: keyPrefix,
+	}
+}
+
+// Get 实现github.com/wencan/fastrest/restcache的Storage接口。
+func (cache *RedisCache) Get(ctx context.Context, key string, valuePtr interface{}) (found bool, err error) {
+	data, err := cache.client.Get(ctx, cache.keyPrefix+key).Bytes()
+	if err == redis.Nil {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	err = cache.codec.Unmarshal(data, valuePtr)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Set 实现github.com/wencan/fastrest/restcache的Storage接口。
+func (cache *RedisCache) Set(ctx context.Context, key string, value interface{}, TTL time.Duration) error {
+	data, err := cache.codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return cache.client.Set(ctx, cache.keyPrefix+key, data, TTL).Err()
+}
+
+// MGet 实现github.com/wencan/fastrest/restcache的MStorage接口。
+func (cache *RedisCache) MGet(ctx context.Context, keys []string, valueSlicePtr interface{}) (missIndexes []int, err error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	prefixedKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		prefixedKeys = append(prefixedKeys, cache.keyPrefix+key)
+	}
+	results, err := cache.mget(ctx, prefixedKeys)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) != len(keys) {
+		return nil, errors.New("wrong mget results")
+	}
+
+	valueSliceValue := reflect.ValueOf(valueSlicePtr).Elem()
+	elemType := valueSliceValue.Type().Elem()
+	for index, result := range results {
+		data, ok := result.(string)
+		if !ok { // nil，不存在
+			missIndexes = append(missIndexes, index)
+			continue
+		}
+
+		valuePtr := reflect.New(elemType)
+		err = cache.codec.Unmarshal([]byte(data), valuePtr.Interface())
+		if err != nil {
+			return nil, err
+		}
+		valueSliceValue.Set(reflect.Append(valueSliceValue, valuePtr.Elem()))
+	}
+
+	return missIndexes, nil
+}
+
+// MSet 实现github.com/wencan/fastrest/restcache的MStorage接口。
+// 通过pipeline批量执行SET EX。
+func (cache *RedisCache) MSet(ctx context.Context, keys []string, valueSlice interface{}, ttl time.Duration) error {
+	valueSliceValue := reflect.ValueOf(valueSlice)
+	if len(keys) != valueSliceValue.Len() {
+		return errors.New("wrong arguments")
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	datas := make([][]byte, 0, len(keys))
+	for index := range keys {
+		data, err := cache.codec.Marshal(valueSliceValue.Index(index).Interface())
+		if err != nil {
+			return err
+		}
+		datas = append(datas, data)
+	}
+
+	_, err := cache.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for index, key := range keys {
+			pipe.Set(ctx, cache.keyPrefix+key, datas[index], ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+// RemainingTTLs 实现github.com/wencan/fastrest/restcache/tiered的TTLReader接口。
+// 通过pipeline批量执行PTTL。不存在或者没有过期时间的，为0。
+func (cache *RedisCache) RemainingTTLs(ctx context.Context, keys []string) ([]time.Duration, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	cmds := make([]*redis.DurationCmd, 0, len(keys))
+	_, err := cache.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			cmds = append(cmds, pipe.PTTL(ctx, cache.keyPrefix+key))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ttls := make([]time.Duration, len(keys))
+	for index, cmd := range cmds {
+		if ttl := cmd.Val(); ttl > 0 {
+			ttls[index] = ttl
+		}
+	}
+	return ttls, nil
+}
+
+// Delete 实现github.com/wencan/fastrest/restcache的Deleter接口。
+func (cache *RedisCache) Delete(ctx context.Context, key string) error {
+	return cache.client.Del(ctx, cache.keyPrefix+key).Err()
+}
+
+// MDelete 实现github.com/wencan/fastrest/restcache的MDeleter接口。
+func (cache *RedisCache) MDelete(ctx context.Context, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	prefixedKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		prefixedKeys = append(prefixedKeys, cache.keyPrefix+key)
+	}
+	return cache.del(ctx, prefixedKeys)
+}
+
+// mget 批量查询。不存在的key，结果为nil。
+// 集群的MGET要求key在同一个slot，集群客户端通过pipeline逐key执行GET。
+func (cache *RedisCache) mget(ctx context.Context, keys []string) ([]interface{}, error) {
+	if _, ok := cache.client.(*redis.ClusterClient); !ok {
+		return cache.client.MGet(ctx, keys...).Result()
+	}
+
+	cmds := make([]*redis.StringCmd, 0, len(keys))
+	_, err := cache.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			cmds = append(cmds, pipe.Get(ctx, key))
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	results := make([]interface{}, len(keys))
+	for index, cmd := range cmds {
+		data, err := cmd.Result()
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		results[index] = data
+	}
+	return results, nil
+}
+
+// del 批量删除。
+// 集群的DEL要求key在同一个slot，集群客户端通过pipeline逐key执行DEL。
+func (cache *RedisCache) del(ctx context.Context, keys []string) error {
+	if _, ok := cache.client.(*redis.ClusterClient); !ok {
+		return cache.client.Del(ctx, keys...).Err()
+	}
+
+	_, err := cache.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Del(ctx, key)
+		}
+		return nil
+	})
+	return err
+}
+
+// scanCount 每次SCAN的数量。
+const scanCount = 1000
+
+// globEscaper 转义redis glob模式的特殊字符。
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// DeletePrefix 实现github.com/wencan/fastrest/restcache的PrefixDeleter接口。
+// 通过SCAN遍历key。如果是集群客户端，遍历全部主节点。
+func (cache *RedisCache) DeletePrefix(ctx context.Context, prefix string) error {
+	match := globEscaper.Replace(cache.keyPrefix+prefix) + "*"
+
+	if clusterClient, ok := cache.client.(*redis.ClusterClient); ok {
+		return clusterClient.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+			return cache.deleteMatch(ctx, client, match)
+		})
+	}
+	return cache.deleteMatch(ctx, cache.client, match)
+}
+
+// deleteMatch 通过client的SCAN遍历匹配match的key，并删除。
+func (cache *RedisCache) deleteMatch(ctx context.Context, client redis.Cmdable, match string) error {
+	var cursor uint64
+	for {
+		keys, nextCursor, err := client.Scan(ctx, cursor, match, scanCount).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			err = cache.del(ctx, keys)
+			if err != nil {
+				return err
+			}
+		}
+
+		cursor = nextCursor
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
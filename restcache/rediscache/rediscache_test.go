@@ -0,0 +1,219 @@
+package rediscache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/wencan/fastrest/restcache"
+	"google.golang.org/grpc/examples/helloworld/helloworld"
+)
+
+var _ restcache.Storage = (*RedisCache)(nil)
+var _ restcache.MStorage = (*RedisCache)(nil)
+
+func newTestRedisClient(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	s := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	t.Cleanup(func() {
+		client.Close()
+	})
+	return s, client
+}
+
+func TestRedisCache(t *testing.T) {
+	type Response struct {
+		Echo string `json:"echo" msgpack:"echo"`
+	}
+
+	for name, codec := range map[string]Codec{"json": JsonCodec, "msgpack": MsgpackCodec} {
+		t.Run(name, func(t *testing.T) {
+			s, client := newTestRedisClient(t)
+			cache := NewRedisCache(client, codec, "test:")
+
+			// not found
+			var resp1 Response
+			ok, err := cache.Get(context.TODO(), "response_1", &resp1)
+			if assert.Nil(t, err) {
+				assert.False(t, ok)
+			}
+
+			err = cache.Set(context.TODO(), "response_1", Response{Echo: "response_1"}, time.Second)
+			assert.Nil(t, err)
+			assert.True(t, s.Exists("test:response_1"))
+
+			// got
+			var resp2 Response
+			ok, err = cache.Get(context.TODO(), "response_1", &resp2)
+			if assert.Nil(t, err) && assert.True(t, ok) {
+				assert.Equal(t, "response_1", resp2.Echo)
+			}
+
+			// expired
+			s.FastForward(time.Second * 2)
+			var resp3 Response
+			ok, err = cache.Get(context.TODO(), "response_1", &resp3)
+			if assert.Nil(t, err) {
+				assert.False(t, ok)
+			}
+
+			keys := []string{"response_1", "response_2", "response_3"}
+			values := []Response{{Echo: "response_1"}, {Echo: "response_2"}, {Echo: "response_3"}}
+			err = cache.MSet(context.TODO(), keys, values, time.Minute)
+			assert.Nil(t, err)
+
+			var results []Response
+			missIndexes, err := cache.MGet(context.TODO(), []string{"response_1", "response_4", "response_3", "response_1"}, &results)
+			if assert.Nil(t, err) {
+				assert.Equal(t, []int{1}, missIndexes)
+				assert.Equal(t, []Response{{Echo: "response_1"}, {Echo: "response_3"}, {Echo: "response_1"}}, results)
+			}
+		})
+	}
+}
+
+func TestRedisCache_Bytes(t *testing.T) {
+	s, client := newTestRedisClient(t)
+	cache := NewRedisCache(client, BytesCodec, "")
+
+	data := []byte{0, 1, 2, 0xff}
+	err := cache.Set(context.TODO(), "bytes_1", data, time.Minute)
+	assert.Nil(t, err)
+	stored, err := s.Get("bytes_1")
+	if assert.Nil(t, err) {
+		assert.Equal(t, string(data), stored)
+	}
+
+	var resp []byte
+	ok, err := cache.Get(context.TODO(), "bytes_1", &resp)
+	if assert.Nil(t, err) && assert.True(t, ok) {
+		assert.Equal(t, data, resp)
+	}
+
+	err = cache.MSet(context.TODO(), []string{"bytes_2"}, [][]byte{[]byte("two")}, time.Minute)
+	assert.Nil(t, err)
+	var results [][]byte
+	missIndexes, err := cache.MGet(context.TODO(), []string{"bytes_1", "bytes_3", "bytes_2"}, &results)
+	if assert.Nil(t, err) {
+		assert.Equal(t, []int{1}, missIndexes)
+		assert.Equal(t, [][]byte{data, []byte("two")}, results)
+	}
+
+	// 不是[]byte
+	err = cache.Set(context.TODO(), "bytes_4", "string", time.Minute)
+	assert.NotNil(t, err)
+}
+
+func TestRedisCache_Protobuf(t *testing.T) {
+	_, client := newTestRedisClient(t)
+	cache := NewRedisCache(client, ProtobufCodec, "")
+
+	err := cache.Set(context.TODO(), "hello", &helloworld.HelloReply{Message: "hello"}, time.Minute)
+	assert.Nil(t, err)
+
+	var reply *helloworld.HelloReply
+	ok, err := cache.Get(context.TODO(), "hello", &reply)
+	if assert.Nil(t, err) && assert.True(t, ok) {
+		assert.Equal(t, "hello", reply.GetMessage())
+	}
+
+	var reply2 helloworld.HelloReply
+	ok, err = cache.Get(context.TODO(), "hello", &reply2)
+	if assert.Nil(t, err) && assert.True(t, ok) {
+		assert.Equal(t, "hello", reply2.GetMessage())
+	}
+
+	err = cache.Set(context.TODO(), "not_message", "hello", time.Minute)
+	assert.NotNil(t, err)
+
+	var replies []*helloworld.HelloReply
+	missIndexes, err := cache.MGet(context.TODO(), []string{"none", "hello"}, &replies)
+	if assert.Nil(t, err) && assert.Len(t, replies, 1) {
+		assert.Equal(t, []int{0}, missIndexes)
+		assert.Equal(t, "hello", replies[0].GetMessage())
+	}
+}
+
+func TestRedisCache_WithCaching(t *testing.T) {
+	_, client := newTestRedisClient(t)
+	cache := NewRedisCache(client, nil, "echo:")
+
+	var queryCount int
+	caching := restcache.Caching{
+		Storage: cache,
+		Query: func(ctx context.Context, destPtr, args interface{}) (found bool, err error) {
+			queryCount++
+			*destPtr.(*string) = "echo: " + args.(string)
+			return true, nil
+		},
+		TTLRange: [2]time.Duration{time.Minute * 4, time.Minute * 6},
+	}
+
+	for i := 0; i < 2; i++ {
+		var resp string
+		found, err := caching.Get(context.TODO(), &resp, "hi", "hi")
+		if assert.Nil(t, err) && assert.True(t, found) {
+			assert.Equal(t, "echo: hi", resp)
+		}
+	}
+	assert.Equal(t, 1, queryCount)
+}
+
+func TestRedisCache_Delete(t *testing.T) {
+	s, client := newTestRedisClient(t)
+	cache := NewRedisCache(client, nil, "test:")
+
+	keys := []string{"user:1", "user:2", "user:3", "order:1", "user*"}
+	values := []string{"user:1", "user:2", "user:3", "order:1", "user*"}
+	err := cache.MSet(context.TODO(), keys, values, time.Minute)
+	assert.Nil(t, err)
+
+	err = cache.Delete(context.TODO(), "user:1")
+	assert.Nil(t, err)
+	assert.False(t, s.Exists("test:user:1"))
+
+	err = cache.MDelete(context.TODO(), []string{"user:2", "user:4"})
+	assert.Nil(t, err)
+	assert.False(t, s.Exists("test:user:2"))
+
+	err = cache.DeletePrefix(context.TODO(), "user*")
+	assert.Nil(t, err)
+	assert.False(t, s.Exists("test:user*"))
+	assert.True(t, s.Exists("test:user:3"))
+
+	err = cache.DeletePrefix(context.TODO(), "user:")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"test:order:1"}, s.Keys())
+}
+
+func TestRedisCache_Cluster(t *testing.T) {
+	s := miniredis.RunT(t)
+	client := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{s.Addr()}})
+	t.Cleanup(func() {
+		client.Close()
+	})
+	cache := NewRedisCache(client, nil, "test:")
+
+	// key分布在不同的slot
+	keys := []string{"user:1", "user:2", "order:1"}
+	err := cache.MSet(context.TODO(), keys, []string{"user:1", "user:2", "order:1"}, time.Minute)
+	assert.Nil(t, err)
+
+	var values []string
+	missIndexes, err := cache.MGet(context.TODO(), []string{"user:1", "user:3", "order:1"}, &values)
+	if assert.Nil(t, err) {
+		assert.Equal(t, []int{1}, missIndexes)
+		assert.Equal(t, []string{"user:1", "order:1"}, values)
+	}
+
+	err = cache.MDelete(context.TODO(), []string{"user:1", "order:1"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"test:user:2"}, s.Keys())
+
+	err = cache.DeletePrefix(context.TODO(), "user:")
+	assert.Nil(t, err)
+	assert.Empty(t, s.Keys())
+}
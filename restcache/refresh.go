@@ -0,0 +1,56 @@
+package restcache
+
+import (
+	"context"
+	"reflect"
+	"time"
+)
+
+// freshMarker 数据新鲜的标记。
+// 同数据分开存储，key为数据key加上freshKeySuffix后缀，生存时间为SoftTTL。
+// 数据存在但是标记不存在，表示数据已过时。
+type freshMarker struct {
+	Fresh bool `json:"fresh" msgpack:"fresh"`
+}
+
+// freshKeySuffix 新鲜标记key的后缀。
+const freshKeySuffix = "#fresh"
+
+// freshKey 返回数据key对应的新鲜标记key。
+func freshKey(key string) string {
+	return key + freshKeySuffix
+}
+
+// getFreshMarker 查询是否存在新鲜标记。
+func getFreshMarker(ctx context.Context, storage Storage, key string) (fresh bool, err error) {
+	var marker freshMarker
+	found, err := storage.Get(ctx, freshKey(key), &marker)
+	if err != nil {
+		return false, err
+	}
+	return found && marker.Fresh, nil
+}
+
+// setFreshMarker 存储新鲜标记。
+func setFreshMarker(ctx context.Context, storage Storage, key string, TTL time.Duration) error {
+	return storage.Set(ctx, freshKey(key), freshMarker{Fresh: true}, TTL)
+}
+
+// refreshInBackground 在后台刷新过时的数据。
+// 同一个key同时只有一个后台刷新。刷新通过哨兵机制执行，不会同前台的查询重复。
+func (caching *Caching) refreshInBackground(destType reflect.Type, key string, args interface{}) {
+	_, loaded := caching.refreshingKeys.LoadOrStore(key, nil)
+	if loaded {
+		// 已经在刷新
+		return
+	}
+
+	go func() {
+		defer caching.refreshingKeys.Delete(key)
+
+		// 不使用请求的上下文。请求结束，不影响刷新
+		// 强制刷新。存储里过时的数据还在，不能作为其它进程的查询结果（见Locker）
+		destPtr := reflect.New(destType).Interface()
+		_ = caching.query(WithRefresh(context.Background()), destPtr, key, args)
+	}()
+}
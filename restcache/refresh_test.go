@@ -0,0 +1,97 @@
+package restcache
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wencan/fastrest/restcache/lrucache"
+)
+
+func TestCaching_GetStaleWhileRevalidate(t *testing.T) {
+	var queryCount int64
+	caching := Caching{
+		Storage: lrucache.NewLRUCache(1000, 10),
+		Query: func(ctx context.Context, destPtr, args interface{}) (found bool, err error) {
+			count := atomic.AddInt64(&queryCount, 1)
+			time.Sleep(time.Millisecond * 50)
+			*destPtr.(*string) = "version: " + strconv.FormatInt(count, 10)
+			return true, nil
+		},
+		TTLRange:    [2]time.Duration{time.Minute * 4, time.Minute * 6},
+		SentinelTTL: time.Millisecond * 10,
+		SoftTTL:     time.Millisecond * 200,
+	}
+
+	var resp string
+	found, err := caching.Get(context.TODO(), &resp, "key", nil)
+	if assert.Nil(t, err) && assert.True(t, found) {
+		assert.Equal(t, "version: 1", resp)
+	}
+
+	// 过时，直接返回过时的数据，后台只刷新一次
+	time.Sleep(time.Millisecond * 400) // LRUCache的时间精度为0.1s
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			start := time.Now()
+			var resp string
+			found, err := caching.Get(context.TODO(), &resp, "key", nil)
+			if assert.Nil(t, err) && assert.True(t, found) {
+				assert.Equal(t, "version: 1", resp)
+			}
+			assert.True(t, time.Since(start) < time.Millisecond*50)
+		}()
+	}
+	wg.Wait()
+
+	// 等待后台刷新完成
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, int64(2), atomic.LoadInt64(&queryCount))
+	found, err = caching.Get(context.TODO(), &resp, "key", nil)
+	if assert.Nil(t, err) && assert.True(t, found) {
+		assert.Equal(t, "version: 2", resp)
+	}
+}
+
+func TestCaching_GetStaleWhileRevalidate_Locker(t *testing.T) {
+	var queryCount int64
+	caching := Caching{
+		Storage: lrucache.NewLRUCache(1000, 10),
+		Query: func(ctx context.Context, destPtr, args interface{}) (found bool, err error) {
+			count := atomic.AddInt64(&queryCount, 1)
+			*destPtr.(*string) = "version: " + strconv.FormatInt(count, 10)
+			return true, nil
+		},
+		TTLRange:    [2]time.Duration{time.Minute * 4, time.Minute * 6},
+		SentinelTTL: time.Millisecond * 10,
+		SoftTTL:     time.Millisecond * 200,
+		Locker:      &MemoryLocker{},
+	}
+
+	var resp string
+	found, err := caching.Get(context.TODO(), &resp, "key", nil)
+	if assert.Nil(t, err) && assert.True(t, found) {
+		assert.Equal(t, "version: 1", resp)
+	}
+
+	// 获得锁后，存储里过时的数据不能作为查询结果，照常后台刷新
+	time.Sleep(time.Millisecond * 400) // LRUCache的时间精度为0.1s
+	found, err = caching.Get(context.TODO(), &resp, "key", nil)
+	if assert.Nil(t, err) && assert.True(t, found) {
+		assert.Equal(t, "version: 1", resp)
+	}
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, int64(2), atomic.LoadInt64(&queryCount))
+	found, err = caching.Get(context.TODO(), &resp, "key", nil)
+	if assert.Nil(t, err) && assert.True(t, found) {
+		assert.Equal(t, "version: 2", resp)
+	}
+}
@@ -0,0 +1,86 @@
+package restcache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Taggable 缓存对象的依赖标签接口。缓存对象可选实现。
+// 警告：注意实现中CacheTags方法的接收者，一般应该是结构体对象，而不是结构体指针。
+type Taggable interface {
+	// CacheTags 返回缓存对象依赖的标签。比如缓存对象依赖的用户，可以返回"user:1"。
+	// 通过InvalidateTag使标签失效时，带有标签的缓存对象都会失效。
+	CacheTags() []string
+}
+
+// TagIndexer 标签索引接口。Storage、MStorage实现可选实现。
+// 存储缓存对象时，如果缓存对象实现了Taggable接口，通过AddTags登记key的标签。
+type TagIndexer interface {
+	// AddTags 登记key的标签。TTL为key的数据的生存时间。
+	AddTags(ctx context.Context, key string, tags []string, TTL time.Duration) error
+
+	// PopTagKeys 返回带有标签的全部key，并删除标签的索引。
+	PopTagKeys(ctx context.Context, tag string) (keys []string, err error)
+}
+
+// ErrTagNotSupported 缓存存储不支持标签。
+var ErrTagNotSupported = errors.New("storage does not support tags")
+
+// InvalidateTag 使带有标签的全部缓存失效。
+// Storage需要实现TagIndexer接口，以及MDeleter接口或者Deleter接口。
+func (caching *Caching) InvalidateTag(ctx context.Context, tag string) error {
+	indexer, ok := caching.Storage.(TagIndexer)
+	if !ok {
+		return ErrTagNotSupported
+	}
+
+	// 先阻止正在执行的查询写回带有标签的结果，再删除存储的数据
+	caching.inflightQueries.cancelTag(tag)
+	keys, err := indexer.PopTagKeys(ctx, tag)
+	if err != nil {
+		return err
+	}
+	// 索引的是Storage使用的key，不再加命名空间前缀
+	return caching.invalidate(ctx, keys)
+}
+
+// InvalidateTag 使带有标签的全部缓存失效。
+// MStorage需要实现TagIndexer接口，以及MDeleter接口或者Deleter接口。
+func (mcaching *MCaching) InvalidateTag(ctx context.Context, tag string) error {
+	indexer, ok := mcaching.MStorage.(TagIndexer)
+	if !ok {
+		return ErrTagNotSupported
+	}
+
+	// 先阻止正在执行的查询写回带有标签的结果，再删除存储的数据
+	mcaching.inflightQueries.cancelTag(tag)
+	keys, err := indexer.PopTagKeys(ctx, tag)
+	if err != nil {
+		return err
+	}
+	// 索引的是MStorage使用的key，不再加命名空间前缀
+	return mcaching.invalidate(ctx, keys)
+}
+
+// getValueTags 取得缓存对象的标签。
+func getValueTags(value interface{}) []string {
+	taggable, _ := value.(Taggable)
+	if taggable == nil {
+		return nil
+	}
+	return taggable.CacheTags()
+}
+
+// addValueTags 登记缓存对象的标签。如果存储不支持标签，忽略标签。
+func addValueTags(ctx context.Context, storage interface{}, key string, value interface{}, TTL time.Duration) error {
+	tags := getValueTags(value)
+	if len(tags) == 0 {
+		return nil
+	}
+	indexer, ok := unwrapStorage(storage).(TagIndexer)
+	if !ok {
+		return nil
+	}
+	return indexer.AddTags(ctx, key, tags, TTL)
+}
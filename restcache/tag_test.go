@@ -0,0 +1,151 @@
+package restcache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/wencan/fastrest/restcache/lrucache"
+	"github.com/wencan/fastrest/restcache/mock_restcache"
+)
+
+type testResponseWithTags struct {
+	Name   string
+	UserID string
+}
+
+func (resp testResponseWithTags) CacheTags() []string {
+	return []string{"user:" + resp.UserID}
+}
+
+func TestCaching_InvalidateTag(t *testing.T) {
+	var queried []string
+	caching := Caching{
+		Storage: lrucache.NewLRUCache(1000, 10),
+		Query: func(ctx context.Context, destPtr, args interface{}) (found bool, err error) {
+			resp := args.(testResponseWithTags)
+			queried = append(queried, resp.Name)
+			*destPtr.(*testResponseWithTags) = resp
+			return true, nil
+		},
+		TTLRange:    [2]time.Duration{time.Minute * 4, time.Minute * 6},
+		SentinelTTL: time.Minute,
+	}
+
+	argsSlice := []testResponseWithTags{
+		{Name: "profile_1", UserID: "1"},
+		{Name: "orders_1", UserID: "1"},
+		{Name: "profile_2", UserID: "2"},
+	}
+	for i := 0; i < 2; i++ {
+		for _, args := range argsSlice {
+			var resp testResponseWithTags
+			found, err := caching.Get(context.TODO(), &resp, args.Name, args)
+			if assert.Nil(t, err) && assert.True(t, found) {
+				assert.Equal(t, args, resp)
+			}
+		}
+
+		if i == 0 {
+			// 用户1的全部缓存失效
+			err := caching.InvalidateTag(context.TODO(), "user:1")
+			assert.Nil(t, err)
+		}
+	}
+	assert.Equal(t, []string{"profile_1", "orders_1", "profile_2", "profile_1", "orders_1"}, queried)
+}
+
+func TestMCaching_InvalidateTag(t *testing.T) {
+	var queried [][]string
+	mcaching := MCaching{
+		MStorage: lrucache.NewLRUCache(1000, 10),
+		MQuery: func(ctx context.Context, destSlicePtr, argsSlice interface{}) (missIndexes []int, err error) {
+			var names []string
+			for _, args := range argsSlice.([]testResponseWithTags) {
+				names = append(names, args.Name)
+				*destSlicePtr.(*[]testResponseWithTags) = append(*destSlicePtr.(*[]testResponseWithTags), args)
+			}
+			queried = append(queried, names)
+			return nil, nil
+		},
+		TTLRange:    [2]time.Duration{time.Minute * 4, time.Minute * 6},
+		SentinelTTL: time.Minute,
+	}
+
+	keys := []string{"profile_1", "orders_1", "profile_2"}
+	argsSlice := []testResponseWithTags{
+		{Name: "profile_1", UserID: "1"},
+		{Name: "orders_1", UserID: "1"},
+		{Name: "profile_2", UserID: "2"},
+	}
+	for i := 0; i < 2; i++ {
+		var resps []testResponseWithTags
+		missIndexes, err := mcaching.MGet(context.TODO(), &resps, keys, argsSlice)
+		if assert.Nil(t, err) {
+			assert.Empty(t, missIndexes)
+			assert.Equal(t, argsSlice, resps)
+		}
+
+		if i == 0 {
+			err = mcaching.InvalidateTag(context.TODO(), "user:2")
+			assert.Nil(t, err)
+		}
+	}
+	assert.Equal(t, [][]string{{"profile_1", "orders_1", "profile_2"}, {"profile_2"}}, queried)
+}
+
+func TestCaching_InvalidateTagInflight(t *testing.T) {
+	storage := lrucache.NewLRUCache(1000, 10)
+	querying := make(chan struct{})
+	invalidated := make(chan struct{})
+	caching := Caching{
+		Storage: storage,
+		Query: func(ctx context.Context, destPtr, args interface{}) (found bool, err error) {
+			close(querying)
+			<-invalidated
+			*destPtr.(*testResponseWithTags) = testResponseWithTags{Name: "profile_1", UserID: "1"}
+			return true, nil
+		},
+		TTLRange:    [2]time.Duration{time.Minute * 4, time.Minute * 6},
+		SentinelTTL: time.Minute,
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var resp testResponseWithTags
+		found, err := caching.Get(context.TODO(), &resp, "profile_1", nil)
+		if assert.Nil(t, err) {
+			assert.True(t, found)
+		}
+	}()
+
+	// 查询期间失效
+	<-querying
+	err := caching.InvalidateTag(context.TODO(), "user:1")
+	assert.Nil(t, err)
+	close(invalidated)
+	<-done
+
+	// 查询结果没有写回
+	var resp testResponseWithTags
+	found, err := storage.Get(context.TODO(), "profile_1", &resp)
+	if assert.Nil(t, err) {
+		assert.False(t, found)
+	}
+}
+
+func TestCaching_InvalidateTagNotSupported(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	caching := Caching{Storage: mock_restcache.NewMockStorage(ctrl)}
+	err := caching.InvalidateTag(context.TODO(), "tag")
+	assert.Equal(t, ErrTagNotSupported, err)
+
+	mcaching := MCaching{MStorage: mock_restcache.NewMockMStorage(ctrl)}
+	err = mcaching.InvalidateTag(context.TODO(), "tag")
+	assert.Equal(t, ErrTagNotSupported, err)
+}
@@ -0,0 +1,377 @@
+package tiered
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/wencan/fastrest/restcache"
+	"github.com/wencan/fastrest/restutils"
+)
+
+// TTLReader 可以读取数据剩余生存时间的缓存存储。Tier的Storage可选实现。
+// 下层命中回填上层时，用于确定回填的生存时间。lrucache.LRUCache和rediscache.RedisCache实现了这个接口。
+type TTLReader interface {
+	// RemainingTTLs 返回keys的数据剩余的生存时间，同keys的顺序。数据不存在或者没有过期时间的，为0。
+	RemainingTTLs(ctx context.Context, keys []string) ([]time.Duration, error)
+}
+
+// Tier 一层缓存存储。
+type Tier struct {
+	// Storage 缓存存储。如果同时实现了restcache.MStorage接口，批量操作使用批量接口。
+	Storage restcache.Storage
+
+	// TTL 本层数据的生存时间上限。
+	// 存储时，取TTL和调用方指定的生存时间中较小的一个。
+	// 下层命中回填本层时，取TTL和下层数据剩余的生存时间中较小的一个；下层没有实现TTLReader接口的，使用TTL。
+	// 如果为0，存储时使用调用方指定的生存时间；回填时使用下层数据剩余的生存时间，不能得知的不回填。
+	TTL time.Duration
+}
+
+// ttl 返回本层存储时使用的生存时间。
+func (tier Tier) ttl(ttl time.Duration) time.Duration {
+	if tier.TTL > 0 && tier.TTL < ttl {
+		return tier.TTL
+	}
+	return ttl
+}
+
+// backfillTTL 返回回填本层时使用的生存时间。remaining为下层数据剩余的生存时间，0表示不能得知。返回0表示不回填。
+func (tier Tier) backfillTTL(remaining time.Duration) time.Duration {
+	if remaining <= 0 {
+		return tier.TTL
+	}
+	return tier.ttl(remaining)
+}
+
+// TieredStorage 多层缓存存储。比如进程内的LRUCache在上层，共享的redis在下层。
+// 查询时，按顺序逐层查找，下层命中后回填上层；本层出错的，继续到下层查找，全部层都出错才返回错误。
+// 存储时，存储到全部层。
+// 实现了restcache的Storage接口和MStorage接口，以及Deleter接口、MDeleter接口、PrefixDeleter接口和TagIndexer接口。
+type TieredStorage struct {
+	tiers []Tier
+}
+
+// NewTieredStorage 创建多层缓存存储。tiers从上层到下层排列。
+func NewTieredStorage(tiers ...Tier) *TieredStorage {
+	return &TieredStorage{tiers: tiers}
+}
+
+// Get 实现restcache的Storage接口。
+func (storage *TieredStorage) Get(ctx context.Context, key string, valuePtr interface{}) (found bool, err error) {
+	var answered bool // 是否有层没出错
+	for index, tier := range storage.tiers {
+		found, tierErr := tier.Storage.Get(ctx, key, valuePtr)
+		if tierErr != nil {
+			// 本层出错，到下层查找
+			err = tierErr
+			continue
+		}
+		answered = true
+		if !found {
+			continue
+		}
+
+		// 回填上层
+		valueValue := reflect.ValueOf(valuePtr).Elem()
+		values := reflect.Append(reflect.MakeSlice(reflect.SliceOf(valueValue.Type()), 0, 1), valueValue)
+		storage.backfill(ctx, index, []string{key}, values)
+		return true, nil
+	}
+
+	if answered {
+		return false, nil
+	}
+	return false, err
+}
+
+// Set 实现restcache的Storage接口。
+// 从下层到上层存储，避免上层先于下层更新。
+func (storage *TieredStorage) Set(ctx context.Context, key string, value interface{}, TTL time.Duration) error {
+	for index := len(storage.tiers) - 1; index >= 0; index-- {
+		tier := storage.tiers[index]
+		err := tier.Storage.Set(ctx, key, value, tier.ttl(TTL))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MGet 实现restcache的MStorage接口。
+// 上层没找到或者出错的，到下层查找。下层找到的，回填上层。
+func (storage *TieredStorage) MGet(ctx context.Context, keys []string, valueSlicePtr interface{}) (missIndexes []int, err error) {
+	valueSliceValue := reflect.ValueOf(valueSlicePtr).Elem()
+	results := make([]reflect.Value, len(keys)) // 每个下标位置上的结果
+
+	pendingIndexes := make([]int, 0, len(keys)) // 还没找到的下标
+	for index := range keys {
+		pendingIndexes = append(pendingIndexes, index)
+	}
+	var answered bool // 是否有层没出错
+	for tierIndex, tier := range storage.tiers {
+		if len(pendingIndexes) == 0 {
+			break
+		}
+
+		pendingKeys := make([]string, 0, len(pendingIndexes))
+		for _, pendingIndex := range pendingIndexes {
+			pendingKeys = append(pendingKeys, keys[pendingIndex])
+		}
+		tierValuesPtr := reflect.New(valueSliceValue.Type())
+		tierMissIndexes, tierErr := mgetTier(ctx, tier, pendingKeys, tierValuesPtr.Interface())
+		if tierErr != nil {
+			// 本层出错，到下层查找
+			err = tierErr
+			continue
+		}
+		answered = true
+
+		// 合并本层的结果
+		tierValues := tierValuesPtr.Elem()
+		hitKeys := make([]string, 0, len(pendingKeys)-len(tierMissIndexes))
+		hitValues := reflect.MakeSlice(valueSliceValue.Type(), 0, len(pendingKeys)-len(tierMissIndexes))
+		var nextPendingIndexes []int
+		var hitCount int
+		for pos, pendingIndex := range pendingIndexes {
+			if restutils.IntSliceContains(tierMissIndexes, pos) {
+				nextPendingIndexes = append(nextPendingIndexes, pendingIndex)
+				continue
+			}
+			if tierValues.Len() <= hitCount { // 本层返回数据有问题
+				return nil, errors.New("not enough tier results")
+			}
+			value := tierValues.Index(hitCount)
+			results[pendingIndex] = value
+			hitKeys = append(hitKeys, keys[pendingIndex])
+			hitValues = reflect.Append(hitValues, value)
+			hitCount++
+		}
+		pendingIndexes = nextPendingIndexes
+
+		// 回填上层
+		if len(hitKeys) > 0 {
+			storage.backfill(ctx, tierIndex, hitKeys, hitValues)
+		}
+	}
+	if !answered && err != nil {
+		return nil, err
+	}
+
+	// 按keys的顺序组合结果
+	for index := range keys {
+		if !results[index].IsValid() {
+			missIndexes = append(missIndexes, index)
+			continue
+		}
+		valueSliceValue.Set(reflect.Append(valueSliceValue, results[index]))
+	}
+	return missIndexes, nil
+}
+
+// MSet 实现restcache的MStorage接口。
+// 从下层到上层存储，避免上层先于下层更新。
+func (storage *TieredStorage) MSet(ctx context.Context, keys []string, valueSlice interface{}, ttl time.Duration) error {
+	if len(keys) != reflect.ValueOf(valueSlice).Len() {
+		return errors.New("wrong arguments")
+	}
+
+	for index := len(storage.tiers) - 1; index >= 0; index-- {
+		tier := storage.tiers[index]
+		err := msetTier(ctx, tier, keys, valueSlice, tier.ttl(ttl))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete 实现restcache的Deleter接口。
+// 从下层到上层删除，避免上层被下层的旧数据回填。
+// 如果有的层不支持删除，删除其它层后，返回restcache.ErrDeleteNotSupported。
+func (storage *TieredStorage) Delete(ctx context.Context, key string) error {
+	return storage.MDelete(ctx, []string{key})
+}
+
+// MDelete 实现restcache的MDeleter接口。
+// 从下层到上层删除，避免上层被下层的旧数据回填。
+// 如果有的层不支持删除，删除其它层后，返回restcache.ErrDeleteNotSupported。
+func (storage *TieredStorage) MDelete(ctx context.Context, keys []string) error {
+	var notSupported bool
+	for index := len(storage.tiers) - 1; index >= 0; index-- {
+		switch deleter := storage.tiers[index].Storage.(type) {
+		case restcache.MDeleter:
+			err := deleter.MDelete(ctx, keys)
+			if err != nil {
+				return err
+			}
+		case restcache.Deleter:
+			for _, key := range keys {
+				err := deleter.Delete(ctx, key)
+				if err != nil {
+					return err
+				}
+			}
+		default:
+			notSupported = true
+		}
+	}
+
+	if notSupported {
+		return restcache.ErrDeleteNotSupported
+	}
+	return nil
+}
+
+// DeletePrefix 实现restcache的PrefixDeleter接口。
+// 如果有的层不支持按前缀删除，删除其它层后，返回restcache.ErrDeleteNotSupported。
+func (storage *TieredStorage) DeletePrefix(ctx context.Context, prefix string) error {
+	var notSupported bool
+	for index := len(storage.tiers) - 1; index >= 0; index-- {
+		deleter, ok := storage.tiers[index].Storage.(restcache.PrefixDeleter)
+		if !ok {
+			notSupported = true
+			continue
+		}
+		err := deleter.DeletePrefix(ctx, prefix)
+		if err != nil {
+			return err
+		}
+	}
+
+	if notSupported {
+		return restcache.ErrDeleteNotSupported
+	}
+	return nil
+}
+
+// AddTags 实现restcache的TagIndexer接口。
+// 登记到全部实现了TagIndexer接口的层。TTL同Set，取各层的TTL上限和TTL中较小的一个。
+func (storage *TieredStorage) AddTags(ctx context.Context, key string, tags []string, TTL time.Duration) error {
+	for index := len(storage.tiers) - 1; index >= 0; index-- {
+		tier := storage.tiers[index]
+		indexer, ok := tier.Storage.(restcache.TagIndexer)
+		if !ok {
+			continue
+		}
+		err := indexer.AddTags(ctx, key, tags, tier.ttl(TTL))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PopTagKeys 实现restcache的TagIndexer接口。
+// 合并全部实现了TagIndexer接口的层的结果。只能得到这些层登记的key，比如只有进程内的上层支持标签，只能得到本进程存储的key。
+// 如果全部层都不支持标签，返回restcache.ErrTagNotSupported。
+func (storage *TieredStorage) PopTagKeys(ctx context.Context, tag string) (keys []string, err error) {
+	var supported bool
+	seen := make(map[string]struct{})
+	for _, tier := range storage.tiers {
+		indexer, ok := tier.Storage.(restcache.TagIndexer)
+		if !ok {
+			continue
+		}
+		supported = true
+		tierKeys, err := indexer.PopTagKeys(ctx, tag)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range tierKeys {
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			keys = append(keys, key)
+		}
+	}
+
+	if !supported {
+		return nil, restcache.ErrTagNotSupported
+	}
+	return keys, nil
+}
+
+// backfill 下层命中后，回填上层。tierIndex为命中的层。
+// 尽力而为，忽略回填的错误。
+func (storage *TieredStorage) backfill(ctx context.Context, tierIndex int, keys []string, values reflect.Value) {
+	if tierIndex == 0 {
+		return
+	}
+
+	// 下层数据剩余的生存时间
+	remainings := make([]time.Duration, len(keys))
+	if reader, ok := storage.tiers[tierIndex].Storage.(TTLReader); ok {
+		ttls, err := reader.RemainingTTLs(ctx, keys)
+		if err == nil && len(ttls) == len(keys) {
+			remainings = ttls
+		}
+	}
+
+	for _, upperTier := range storage.tiers[:tierIndex] {
+		// 按生存时间分组回填
+		var ttls []time.Duration
+		groups := make(map[time.Duration][]int)
+		for index := range keys {
+			ttl := upperTier.backfillTTL(remainings[index])
+			if ttl <= 0 {
+				continue
+			}
+			if _, ok := groups[ttl]; !ok {
+				ttls = append(ttls, ttl)
+			}
+			groups[ttl] = append(groups[ttl], index)
+		}
+		for _, ttl := range ttls {
+			groupKeys := make([]string, 0, len(groups[ttl]))
+			groupValues := reflect.MakeSlice(values.Type(), 0, len(groups[ttl]))
+			for _, index := range groups[ttl] {
+				groupKeys = append(groupKeys, keys[index])
+				groupValues = reflect.Append(groupValues, values.Index(index))
+			}
+			msetTier(ctx, upperTier, groupKeys, groupValues.Interface(), ttl)
+		}
+	}
+}
+
+// mgetTier 批量查询一层。如果这层不支持批量查询，逐个查询。
+func mgetTier(ctx context.Context, tier Tier, keys []string, valueSlicePtr interface{}) (missIndexes []int, err error) {
+	mstorage, ok := tier.Storage.(restcache.MStorage)
+	if ok {
+		return mstorage.MGet(ctx, keys, valueSlicePtr)
+	}
+
+	valueSliceValue := reflect.ValueOf(valueSlicePtr).Elem()
+	for index, key := range keys {
+		valuePtr := reflect.New(valueSliceValue.Type().Elem())
+		found, err := tier.Storage.Get(ctx, key, valuePtr.Interface())
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			missIndexes = append(missIndexes, index)
+			continue
+		}
+		valueSliceValue.Set(reflect.Append(valueSliceValue, valuePtr.Elem()))
+	}
+	return missIndexes, nil
+}
+
+// msetTier 批量存储到一层。如果这层不支持批量存储，逐个存储。
+func msetTier(ctx context.Context, tier Tier, keys []string, valueSlice interface{}, ttl time.Duration) error {
+	mstorage, ok := tier.Storage.(restcache.MStorage)
+	if ok {
+		return mstorage.MSet(ctx, keys, valueSlice, ttl)
+	}
+
+	valueSliceValue := reflect.ValueOf(valueSlice)
+	for index, key := range keys {
+		err := tier.Storage.Set(ctx, key, valueSliceValue.Index(index).Interface(), ttl)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package tiered
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/wencan/fastrest/restcache"
+	"github.com/wencan/fastrest/restcache/lrucache"
+	"github.com/wencan/fastrest/restcache/rediscache"
+)
+
+var _ restcache.Storage = (*TieredStorage)(nil)
+var _ restcache.MStorage = (*TieredStorage)(nil)
+var _ restcache.TagIndexer = (*TieredStorage)(nil)
+
+// testErrorStorage 总是出错的存储。
+type testErrorStorage struct{}
+
+var errTestStorage = errors.New("storage error")
+
+func (testErrorStorage) Get(ctx context.Context, key string, valuePtr interface{}) (found bool, err error) {
+	return false, errTestStorage
+}
+
+func (testErrorStorage) Set(ctx context.Context, key string, value interface{}, TTL time.Duration) error {
+	return errTestStorage
+}
+
+// testMapStorage 只实现了restcache.Storage接口的存储。
+type testMapStorage map[string]string
+
+func (m testMapStorage) Get(ctx context.Context, key string, valuePtr interface{}) (found bool, err error) {
+	value, found := m[key]
+	if found {
+		*valuePtr.(*string) = value
+	}
+	return found, nil
+}
+
+func (m testMapStorage) Set(ctx context.Context, key string, value interface{}, TTL time.Duration) error {
+	m[key] = value.(string)
+	return nil
+}
+
+func newTestTieredStorage(t *testing.T) (*TieredStorage, *lrucache.LRUCache, *miniredis.Miniredis, testMapStorage) {
+	s := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	t.Cleanup(func() {
+		client.Close()
+	})
+
+	l1 := lrucache.NewLRUCache(100, 10)
+	l3 := make(testMapStorage)
+	storage := NewTieredStorage(
+		Tier{Storage: l1, TTL: time.Minute},
+		Tier{Storage: rediscache.NewRedisCache(client, nil, ""), TTL: time.Hour},
+		Tier{Storage: l3},
+	)
+	return storage, l1, s, l3
+}
+
+func TestTieredStorage_Get(t *testing.T) {
+	storage, l1, s, l3 := newTestTieredStorage(t)
+
+	var value string
+	found, err := storage.Get(context.TODO(), "key", &value)
+	if assert.Nil(t, err) {
+		assert.False(t, found)
+	}
+
+	// 最下层命中，回填上层
+	l3["key"] = "value"
+	found, err = storage.Get(context.TODO(), "key", &value)
+	if assert.Nil(t, err) && assert.True(t, found) {
+		assert.Equal(t, "value", value)
+	}
+	assert.True(t, s.Exists("key"))
+	assert.Equal(t, time.Hour, s.TTL("key"))
+	found, err = l1.Get(context.TODO(), "key", &value)
+	if assert.Nil(t, err) {
+		assert.True(t, found)
+	}
+
+	// 存储到全部层，生存时间不超过每层的上限
+	err = storage.Set(context.TODO(), "key2", "value2", time.Hour*2)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Hour, s.TTL("key2"))
+	assert.Equal(t, "value2", l3["key2"])
+}
+
+func TestTieredStorage_MGet(t *testing.T) {
+	storage, l1, s, l3 := newTestTieredStorage(t)
+
+	l1.Set(context.TODO(), "key_1", "value_1", time.Minute)
+	s.Set("key_2", `"value_2"`)
+	l3["key_3"] = "value_3"
+
+	keys := []string{"key_3", "key_1", "key_4", "key_2", "key_1"}
+	var values []string
+	missIndexes, err := storage.MGet(context.TODO(), keys, &values)
+	if assert.Nil(t, err) {
+		assert.Equal(t, []int{2}, missIndexes)
+		assert.Equal(t, []string{"value_3", "value_1", "value_2", "value_1"}, values)
+	}
+
+	// 已回填第一层
+	values = nil
+	missIndexes, err = l1.MGet(context.TODO(), []string{"key_1", "key_2", "key_3"}, &values)
+	if assert.Nil(t, err) {
+		assert.Empty(t, missIndexes)
+		assert.Equal(t, []string{"value_1", "value_2", "value_3"}, values)
+	}
+
+	err = storage.MSet(context.TODO(), []string{"key_5", "key_6"}, []string{"value_5", "value_6"}, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, "value_6", l3["key_6"])
+	assert.True(t, s.Exists("key_5"))
+}
+
+func TestTieredStorage_Delete(t *testing.T) {
+	storage, l1, s, _ := newTestTieredStorage(t)
+
+	err := storage.MSet(context.TODO(), []string{"key_1", "key_2"}, []string{"value_1", "value_2"}, time.Minute)
+	assert.Nil(t, err)
+
+	// 最下层不支持删除
+	err = storage.MDelete(context.TODO(), []string{"key_1"})
+	assert.Equal(t, restcache.ErrDeleteNotSupported, err)
+	assert.False(t, s.Exists("key_1"))
+	var value string
+	found, err := l1.Get(context.TODO(), "key_1", &value)
+	if assert.Nil(t, err) {
+		assert.False(t, found)
+	}
+
+	storage = NewTieredStorage(storage.tiers[:2]...)
+	err = storage.Delete(context.TODO(), "key_2")
+	assert.Nil(t, err)
+	assert.False(t, s.Exists("key_2"))
+}
+
+func TestTieredStorage_Tags(t *testing.T) {
+	l1, l2 := lrucache.NewLRUCache(100, 10), lrucache.NewLRUCache(100, 10)
+	storage := NewTieredStorage(Tier{Storage: l1, TTL: time.Minute}, Tier{Storage: l2})
+
+	ctx := context.TODO()
+	err := storage.Set(ctx, "profile_1", "profile_1", time.Hour)
+	assert.Nil(t, err)
+	err = storage.AddTags(ctx, "profile_1", []string{"user:1"}, time.Hour)
+	assert.Nil(t, err)
+	// 只在下层的
+	err = l2.Set(ctx, "orders_1", "orders_1", time.Hour)
+	assert.Nil(t, err)
+	err = l2.AddTags(ctx, "orders_1", []string{"user:1"}, time.Hour)
+	assert.Nil(t, err)
+
+	// 合并各层的结果
+	keys, err := storage.PopTagKeys(ctx, "user:1")
+	if assert.Nil(t, err) {
+		assert.ElementsMatch(t, []string{"profile_1", "orders_1"}, keys)
+	}
+	keys, err = l1.PopTagKeys(ctx, "user:1")
+	if assert.Nil(t, err) {
+		assert.Empty(t, keys)
+	}
+
+	// 都不支持标签
+	storage, _, _, _ = newTestTieredStorage(t)
+	storage = NewTieredStorage(storage.tiers[1:]...)
+	err = storage.AddTags(ctx, "profile_1", []string{"user:1"}, time.Hour)
+	assert.Nil(t, err)
+	_, err = storage.PopTagKeys(ctx, "user:1")
+	assert.Equal(t, restcache.ErrTagNotSupported, err)
+}
+
+func TestTieredStorage_TierError(t *testing.T) {
+	l2 := lrucache.NewLRUCache(100, 10)
+	storage := NewTieredStorage(Tier{Storage: testErrorStorage{}, TTL: time.Minute}, Tier{Storage: l2})
+	l2.Set(context.TODO(), "key_1", "value_1", time.Minute)
+
+	// 上层出错，到下层查找
+	var value string
+	found, err := storage.Get(context.TODO(), "key_1", &value)
+	if assert.Nil(t, err) && assert.True(t, found) {
+		assert.Equal(t, "value_1", value)
+	}
+	found, err = storage.Get(context.TODO(), "key_2", &value)
+	if assert.Nil(t, err) {
+		assert.False(t, found)
+	}
+	var values []string
+	missIndexes, err := storage.MGet(context.TODO(), []string{"key_1", "key_2"}, &values)
+	if assert.Nil(t, err) {
+		assert.Equal(t, []int{1}, missIndexes)
+		assert.Equal(t, []string{"value_1"}, values)
+	}
+
+	// 全部层都出错
+	storage = NewTieredStorage(Tier{Storage: testErrorStorage{}}, Tier{Storage: testErrorStorage{}})
+	_, err = storage.Get(context.TODO(), "key_1", &value)
+	assert.Equal(t, errTestStorage, err)
+	_, err = storage.MGet(context.TODO(), []string{"key_1"}, &values)
+	assert.Equal(t, errTestStorage, err)
+}
+
+func TestTieredStorage_BackfillTTL(t *testing.T) {
+	s := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	defer client.Close()
+
+	l1, l2 := lrucache.NewLRUCache(100, 10), lrucache.NewLRUCache(100, 10)
+	storage := NewTieredStorage(
+		Tier{Storage: l1},                   // 没有上限，使用下层剩余的生存时间
+		Tier{Storage: l2, TTL: time.Minute}, // 取上限和下层剩余的生存时间中较小的
+		Tier{Storage: rediscache.NewRedisCache(client, nil, "")},
+	)
+	s.Set("key_1", `"value_1"`)
+	s.SetTTL("key_1", time.Second*30)
+	s.Set("key_2", `"value_2"`)
+	s.SetTTL("key_2", time.Hour)
+
+	var values []string
+	missIndexes, err := storage.MGet(context.TODO(), []string{"key_1", "key_2"}, &values)
+	if assert.Nil(t, err) {
+		assert.Empty(t, missIndexes)
+		assert.Equal(t, []string{"value_1", "value_2"}, values)
+	}
+
+	ttls, err := l1.RemainingTTLs(context.TODO(), []string{"key_1", "key_2"})
+	if assert.Nil(t, err) {
+		assert.InDelta(t, time.Second*30, ttls[0], float64(time.Second))
+		assert.InDelta(t, time.Hour, ttls[1], float64(time.Second))
+	}
+	ttls, err = l2.RemainingTTLs(context.TODO(), []string{"key_1", "key_2", "key_3"})
+	if assert.Nil(t, err) {
+		assert.InDelta(t, time.Second*30, ttls[0], float64(time.Second))
+		assert.InDelta(t, time.Minute, ttls[1], float64(time.Second))
+		assert.Equal(t, time.Duration(0), ttls[2])
+	}
+
+	// 下层不能得知剩余的生存时间，没有上限的层不回填
+	l3 := make(testMapStorage)
+	l3["key_3"] = "value_3"
+	l1 = lrucache.NewLRUCache(100, 10)
+	storage = NewTieredStorage(Tier{Storage: l1}, Tier{Storage: l3})
+	var value string
+	found, err := storage.Get(context.TODO(), "key_3", &value)
+	if assert.Nil(t, err) && assert.True(t, found) {
+		assert.Equal(t, "value_3", value)
+	}
+	assert.Equal(t, 0, l1.Len())
+}
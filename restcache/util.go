@@ -5,10 +5,10 @@ import (
 	"time"
 )
 
-// getTTL 计算出区间内的一个TTL值。
+// getTTL 计算出区间内的一个TTL值。按秒取值，区间不足1秒的，返回下限。
 func getTTL(TTLRange [2]time.Duration) time.Duration {
 	gap := TTLRange[1] - TTLRange[0]
-	if gap == 0 {
+	if gap < time.Second {
 		return TTLRange[0]
 	}
 
@@ -17,6 +17,40 @@ func getTTL(TTLRange [2]time.Duration) time.Duration {
 	return ttl
 }
 
+// getValueTTL 取得缓存对象的TTL。如果实现了TTLer接口，使用CacheTTL方法的返回值，否则使用defaultTTL。
+func getValueTTL(value interface{}, defaultTTL time.Duration) time.Duration {
+	ttler, _ := value.(TTLer)
+	if ttler == nil {
+		return defaultTTL
+	}
+	ttl := ttler.CacheTTL()
+	if ttl <= 0 {
+		return defaultTTL
+	}
+	return ttl
+}
+
+// groupByTTL 按缓存对象的TTL分组。返回各组的TTL，和各组元素的下标。
+// 如果都没有实现TTLer接口，或者没有元素，只有一组。
+func groupByTTL(length int, valueAt func(index int) interface{}, defaultTTL time.Duration) (ttls []time.Duration, groups [][]int) {
+	if length == 0 {
+		return []time.Duration{defaultTTL}, [][]int{nil}
+	}
+	groupIndexes := make(map[time.Duration]int)
+	for index := 0; index < length; index++ {
+		ttl := getValueTTL(valueAt(index), defaultTTL)
+		groupIndex, ok := groupIndexes[ttl]
+		if !ok {
+			groupIndex = len(ttls)
+			groupIndexes[ttl] = groupIndex
+			ttls = append(ttls, ttl)
+			groups = append(groups, nil)
+		}
+		groups[groupIndex] = append(groups[groupIndex], index)
+	}
+	return ttls, groups
+}
+
 // HitIndexes 根据未命中的索引，得到命中的索引
 func HitIndexes[T any](collection []T, missIndexes []int) []int {
 	var missPos int
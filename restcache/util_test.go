@@ -9,6 +9,15 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+func Test_getTTL(t *testing.T) {
+	// 区间不足1秒的，返回下限
+	assert.Equal(t, time.Second, getTTL([2]time.Duration{time.Second, time.Millisecond * 1500}))
+	assert.Equal(t, time.Second, getTTL([2]time.Duration{time.Second, time.Second}))
+
+	ttl := getTTL([2]time.Duration{time.Second, time.Millisecond * 2500})
+	assert.Contains(t, []time.Duration{time.Second, time.Second * 2}, ttl)
+}
+
 func Test_getTTL_Concurrently(t *testing.T) {
 	var wg sync.WaitGroup
 
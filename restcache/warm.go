@@ -0,0 +1,257 @@
+package restcache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultWarmBatchSize 默认的预热每批key数。
+	defaultWarmBatchSize = 100
+
+	// defaultWarmConcurrency 默认的预热同时查询批数。
+	defaultWarmConcurrency = 4
+
+	// refreshAheadTrackFactor 提前刷新最多跟踪的key数，为RefreshAheadTopN的倍数。
+	refreshAheadTrackFactor = 10
+)
+
+// Warm 预热缓存。比如部署后进程内缓存为空时，提前加载热点数据。
+// 按WarmBatchSize分批，最多WarmConcurrency批同时，通过MGet加载。已缓存的不再查询。
+// destSlicePtr只用于确定数据类型，可以为nil指针，比如(*[]Response)(nil)。keys是缓存key，argsSlice是查询函数的参数序列。
+// 返回第一个出错的批次的错误。
+func (mcaching *MCaching) Warm(ctx context.Context, destSlicePtr interface{}, keys []string, argsSlice interface{}) error {
+	argsSliceValue := reflect.ValueOf(argsSlice)
+	if len(keys) != argsSliceValue.Len() {
+		return errors.New("wrong argsSlice")
+	}
+	destSliceType := reflect.TypeOf(destSlicePtr).Elem()
+
+	batchSize := mcaching.WarmBatchSize
+	if batchSize <= 0 {
+		batchSize = defaultWarmBatchSize
+	}
+	concurrency := mcaching.WarmConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultWarmConcurrency
+	}
+
+	return runInBatches(ctx, len(keys), batchSize, concurrency, func(batchIndex, begin, end int) error {
+		destPtrValue := reflect.New(destSliceType)
+		_, err := mcaching.MGet(ctx, destPtrValue.Interface(), keys[begin:end], argsSliceValue.Slice(begin, end).Interface())
+		return err
+	})
+}
+
+// RefreshAhead 定时提前刷新。阻塞直到ctx结束。一般在单独的协程中执行。
+// 每个interval，重新查询最近命中最多的RefreshAheadTopN个、将在RefreshAheadWindow内过期的key，并存储。
+// 通过哨兵机制查询，不会同正在执行的查询重复。需要RefreshAheadTopN大于0。
+func (mcaching *MCaching) RefreshAhead(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	window := mcaching.RefreshAheadWindow
+	if window <= 0 {
+		window = interval * 2
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			mcaching.refreshAheadOnce(ctx, window)
+		}
+	}
+}
+
+// refreshAheadOnce 执行一次提前刷新。
+func (mcaching *MCaching) refreshAheadOnce(ctx context.Context, window time.Duration) {
+	candidates := mcaching.refreshAhead.pick(time.Now(), window, mcaching.RefreshAheadTopN)
+
+	// 按数据类型和参数类型分组
+	type groupKey struct {
+		destSliceType reflect.Type
+		argsType      reflect.Type
+	}
+	groups := make(map[groupKey][]refreshAheadCandidate)
+	var groupKeys []groupKey
+	for _, candidate := range candidates {
+		key := groupKey{destSliceType: candidate.destSliceType, argsType: candidate.argsValue.Type()}
+		if _, ok := groups[key]; !ok {
+			groupKeys = append(groupKeys, key)
+		}
+		groups[key] = append(groups[key], candidate)
+	}
+
+	for _, key := range groupKeys {
+		group := groups[key]
+		keys := make([]string, 0, len(group))
+		argsSliceValue := reflect.MakeSlice(reflect.SliceOf(key.argsType), 0, len(group))
+		for _, candidate := range group {
+			keys = append(keys, candidate.key)
+			argsSliceValue = reflect.Append(argsSliceValue, candidate.argsValue)
+		}
+		// 强制刷新。存储里即将过期的数据还在，不能作为其它进程的查询结果（见Locker）
+		// 错误已经通过Observer通知
+		mcaching.queryAndStore(WithRefresh(ctx), key.destSliceType, keys, argsSliceValue)
+	}
+}
+
+// refreshAheadEntry 提前刷新的跟踪数据。
+type refreshAheadEntry struct {
+	// destSliceType 数据切片类型。
+	destSliceType reflect.Type
+
+	// argsValue 查询参数。
+	argsValue reflect.Value
+
+	// expireAt 预计的过期时间。
+	expireAt time.Time
+
+	// hits 开始跟踪或者上次刷新后的命中次数。
+	hits int64
+}
+
+// refreshAheadCandidate 需要提前刷新的key。
+type refreshAheadCandidate struct {
+	key string
+
+	refreshAheadEntry
+}
+
+// refreshAheadTracker 跟踪查询过的key的命中次数和过期时间。用于提前刷新。
+type refreshAheadTracker struct {
+	mu sync.Mutex
+
+	entries map[string]*refreshAheadEntry
+
+	// prefix 当前命名空间世代的key前缀。世代改变后，不再跟踪旧世代的key。
+	prefix string
+}
+
+// stored 记录查询并存储了的key。最多跟踪limit个key，超过的，不再跟踪命中最少的。
+func (tracker *refreshAheadTracker) stored(key string, destSliceType reflect.Type, argsValue reflect.Value, expireAt time.Time, limit int) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	if tracker.entries == nil {
+		tracker.entries = make(map[string]*refreshAheadEntry)
+	}
+	if !strings.HasPrefix(key, tracker.prefix) {
+		// 旧世代的key
+		return
+	}
+	entry := tracker.entries[key]
+	if entry == nil {
+		if len(tracker.entries) >= limit {
+			tracker.evict()
+		}
+		entry = &refreshAheadEntry{}
+		tracker.entries[key] = entry
+	}
+	entry.destSliceType = destSliceType
+	entry.argsValue = argsValue
+	entry.expireAt = expireAt
+}
+
+// evict 不再跟踪命中最少的key。命中次数相同的，不再跟踪最早过期的。需要持有锁。
+func (tracker *refreshAheadTracker) evict() {
+	var evictKey string
+	var evictEntry *refreshAheadEntry
+	for key, entry := range tracker.entries {
+		if evictEntry == nil || entry.hits < evictEntry.hits || (entry.hits == evictEntry.hits && entry.expireAt.Before(evictEntry.expireAt)) {
+			evictKey, evictEntry = key, entry
+		}
+	}
+	if evictEntry != nil {
+		delete(tracker.entries, evictKey)
+	}
+}
+
+// keepPrefix 只跟踪具有指定前缀的key。命名空间世代改变后，不再跟踪旧世代的key。
+func (tracker *refreshAheadTracker) keepPrefix(prefix string) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	if tracker.prefix == prefix {
+		return
+	}
+	tracker.prefix = prefix
+	for key := range tracker.entries {
+		if !strings.HasPrefix(key, prefix) {
+			delete(tracker.entries, key)
+		}
+	}
+}
+
+// hit 记录命中。只记录查询并存储过的key。
+func (tracker *refreshAheadTracker) hit(keys []string) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	for _, key := range keys {
+		if entry := tracker.entries[key]; entry != nil {
+			entry.hits++
+		}
+	}
+}
+
+// remove 不再跟踪key。
+func (tracker *refreshAheadTracker) remove(keys ...string) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	for _, key := range keys {
+		delete(tracker.entries, key)
+	}
+}
+
+// removePrefix 不再跟踪具有指定前缀的key。
+func (tracker *refreshAheadTracker) removePrefix(prefix string) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	for key := range tracker.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(tracker.entries, key)
+		}
+	}
+}
+
+// pick 选出在window内过期、命中最多的topN个key。清零选出的key的命中次数，不再跟踪已过期的。
+func (tracker *refreshAheadTracker) pick(now time.Time, window time.Duration, topN int) []refreshAheadCandidate {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	var candidates []refreshAheadCandidate
+	for key, entry := range tracker.entries {
+		if now.After(entry.expireAt) {
+			// 已过期，等下次查询重新跟踪
+			delete(tracker.entries, key)
+			continue
+		}
+		if entry.hits > 0 && entry.expireAt.Sub(now) <= window {
+			candidates = append(candidates, refreshAheadCandidate{key: key, refreshAheadEntry: *entry})
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].hits != candidates[j].hits {
+			return candidates[i].hits > candidates[j].hits
+		}
+		return candidates[i].key < candidates[j].key
+	})
+	if len(candidates) > topN {
+		candidates = candidates[:topN]
+	}
+	for _, candidate := range candidates {
+		tracker.entries[candidate.key].hits = 0
+	}
+	return candidates
+}
@@ -0,0 +1,224 @@
+package restcache
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wencan/fastrest/restcache/lrucache"
+)
+
+func TestMCaching_Warm(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]string
+	var running, maxRunning int32
+	mcaching := MCaching{
+		MStorage: lrucache.NewLRUCache(1000, 10),
+		MQuery: func(ctx context.Context, destSlicePtr, argsSlice interface{}) (missIndexes []int, err error) {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			mu.Lock()
+			if n > maxRunning {
+				maxRunning = n
+			}
+			batches = append(batches, argsSlice.([]string))
+			mu.Unlock()
+			time.Sleep(time.Millisecond * 50)
+
+			for _, req := range argsSlice.([]string) {
+				*destSlicePtr.(*[]string) = append(*destSlicePtr.(*[]string), "echo: "+req)
+			}
+			return nil, nil
+		},
+		TTLRange:        [2]time.Duration{time.Minute * 4, time.Minute * 6},
+		WarmBatchSize:   10,
+		WarmConcurrency: 2,
+	}
+
+	var keys []string
+	for i := 0; i < 45; i++ {
+		keys = append(keys, strconv.Itoa(i))
+	}
+	err := mcaching.Warm(context.TODO(), (*[]string)(nil), keys, keys)
+	assert.Nil(t, err)
+	assert.Len(t, batches, 5)
+	assert.Equal(t, int32(2), maxRunning)
+
+	// 已经预热，不再查询
+	var resps []string
+	missIndexes, err := mcaching.MGet(context.TODO(), &resps, keys[:3], keys[:3])
+	if assert.Nil(t, err) {
+		assert.Empty(t, missIndexes)
+		assert.Equal(t, []string{"echo: 0", "echo: 1", "echo: 2"}, resps)
+	}
+	err = mcaching.Warm(context.TODO(), (*[]string)(nil), keys, keys)
+	assert.Nil(t, err)
+	assert.Len(t, batches, 5)
+}
+
+func TestMCaching_RefreshAhead(t *testing.T) {
+	var mu sync.Mutex
+	var queried []string
+	var version int
+	mcaching := MCaching{
+		MStorage: lrucache.NewLRUCache(1000, 10),
+		MQuery: func(ctx context.Context, destSlicePtr, argsSlice interface{}) (missIndexes []int, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			version++
+			for _, req := range argsSlice.([]string) {
+				queried = append(queried, req)
+				*destSlicePtr.(*[]string) = append(*destSlicePtr.(*[]string), req+" v"+strconv.Itoa(version))
+			}
+			return nil, nil
+		},
+		TTLRange:           [2]time.Duration{time.Second, time.Second},
+		RefreshAheadTopN:   1,
+		RefreshAheadWindow: time.Millisecond * 800,
+	}
+
+	keys := []string{"key_1", "key_2", "key_3"}
+	var resps []string
+	_, err := mcaching.MGet(context.TODO(), &resps, keys, keys)
+	assert.Nil(t, err)
+	// key_1命中最多，key_3没有命中
+	for i := 0; i < 3; i++ {
+		mcaching.MGet(context.TODO(), &resps, []string{"key_1"}, []string{"key_1"})
+	}
+	mcaching.MGet(context.TODO(), &resps, []string{"key_2"}, []string{"key_2"})
+
+	// 还没到刷新时间
+	mcaching.refreshAheadOnce(context.TODO(), mcaching.RefreshAheadWindow)
+	assert.Equal(t, keys, queried)
+
+	// 只刷新命中最多的
+	mcaching.MGet(context.TODO(), &resps, []string{"key_1", "key_2"}, []string{"key_1", "key_2"})
+	time.Sleep(time.Millisecond * 300)
+	mcaching.refreshAheadOnce(context.TODO(), mcaching.RefreshAheadWindow)
+	assert.Equal(t, []string{"key_1", "key_2", "key_3", "key_1"}, queried)
+
+	resps = nil
+	missIndexes, err := mcaching.MGet(context.TODO(), &resps, []string{"key_1"}, []string{"key_1"})
+	if assert.Nil(t, err) {
+		assert.Empty(t, missIndexes)
+		assert.Equal(t, []string{"key_1 v2"}, resps)
+	}
+
+	// 失效的不再刷新
+	err = mcaching.Invalidate(context.TODO(), "key_1")
+	assert.Nil(t, err)
+	mcaching.MGet(context.TODO(), &resps, []string{"key_2"}, []string{"key_2"})
+	mcaching.refreshAheadOnce(context.TODO(), time.Minute)
+	assert.Equal(t, []string{"key_1", "key_2", "key_3", "key_1", "key_2"}, queried)
+}
+
+func TestRefreshAheadTracker(t *testing.T) {
+	var tracker refreshAheadTracker
+	now := time.Now()
+	store := func(key string, expireAt time.Time) {
+		tracker.stored(key, reflect.TypeOf([]string{}), reflect.ValueOf(key), expireAt, 2)
+	}
+
+	// 最多跟踪2个，超过的，不再跟踪命中最少的
+	store("key_1", now.Add(time.Second))
+	store("key_2", now.Add(time.Minute))
+	tracker.hit([]string{"key_1", "key_1", "key_2"})
+	store("key_3", now.Add(time.Second))
+	assert.Len(t, tracker.entries, 2)
+	assert.NotContains(t, tracker.entries, "key_2")
+
+	// 只清零选出的key的命中次数
+	tracker.hit([]string{"key_3"})
+	candidates := tracker.pick(now, time.Second*2, 1)
+	if assert.Len(t, candidates, 1) {
+		assert.Equal(t, "key_1", candidates[0].key)
+	}
+	assert.Equal(t, int64(0), tracker.entries["key_1"].hits)
+	assert.Equal(t, int64(1), tracker.entries["key_3"].hits)
+
+	// 不再跟踪旧世代的key
+	tracker.keepPrefix("ns:v1:g2:")
+	assert.Empty(t, tracker.entries)
+	store("ns:v1:g1:key_1", now.Add(time.Second))
+	store("ns:v1:g2:key_1", now.Add(time.Second))
+	assert.Len(t, tracker.entries, 1)
+	assert.Contains(t, tracker.entries, "ns:v1:g2:key_1")
+}
+
+func TestMCaching_RefreshAheadLocker(t *testing.T) {
+	var queryCount int64
+	mcaching := MCaching{
+		MStorage: lrucache.NewLRUCache(1000, 10),
+		MQuery: func(ctx context.Context, destSlicePtr, argsSlice interface{}) (missIndexes []int, err error) {
+			count := atomic.AddInt64(&queryCount, 1)
+			for _, req := range argsSlice.([]string) {
+				*destSlicePtr.(*[]string) = append(*destSlicePtr.(*[]string), req+" v"+strconv.FormatInt(count, 10))
+			}
+			return nil, nil
+		},
+		TTLRange:         [2]time.Duration{time.Minute, time.Minute},
+		RefreshAheadTopN: 1,
+		Locker:           &MemoryLocker{},
+	}
+
+	var resps []string
+	_, err := mcaching.MGet(context.TODO(), &resps, []string{"key_1"}, []string{"key_1"})
+	assert.Nil(t, err)
+
+	// 存储里的数据还没过期，也重新查询
+	for i := 0; i < 3; i++ {
+		time.Sleep(time.Millisecond * 10) // 等待删除哨兵
+		mcaching.MGet(context.TODO(), &resps, []string{"key_1"}, []string{"key_1"})
+		mcaching.refreshAheadOnce(context.TODO(), time.Hour)
+	}
+	assert.Equal(t, int64(4), atomic.LoadInt64(&queryCount))
+	resps = nil
+	_, err = mcaching.MGet(context.TODO(), &resps, []string{"key_1"}, []string{"key_1"})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"key_1 v4"}, resps)
+	}
+}
+
+func TestMCaching_LockerLoadedNotStored(t *testing.T) {
+	storage := lrucache.NewLRUCache(1000, 10)
+	locker := &MemoryLocker{}
+	mcaching := MCaching{
+		MStorage: storage,
+		MQuery: func(ctx context.Context, destSlicePtr, argsSlice interface{}) (missIndexes []int, err error) {
+			for _, req := range argsSlice.([]string) {
+				*destSlicePtr.(*[]string) = append(*destSlicePtr.(*[]string), req)
+			}
+			return nil, nil
+		},
+		TTLRange:         [2]time.Duration{time.Hour, time.Hour},
+		RefreshAheadTopN: 1,
+		Locker:           locker,
+	}
+
+	// 其它进程持有锁，查询并存储
+	_, locked, err := locker.TryLock(context.TODO(), lockKey("key_1"), time.Second)
+	if !assert.Nil(t, err) || !assert.True(t, locked) {
+		return
+	}
+	time.AfterFunc(time.Millisecond*100, func() {
+		storage.Set(context.TODO(), "key_1", "stored", time.Second*10)
+	})
+
+	var resps []string
+	_, err = mcaching.MGet(context.TODO(), &resps, []string{"key_1"}, []string{"key_1"})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"stored"}, resps)
+	}
+
+	// 加载到的数据不再存储，也不跟踪提前刷新
+	ttls, err := storage.RemainingTTLs(context.TODO(), []string{"key_1"})
+	if assert.Nil(t, err) {
+		assert.InDelta(t, time.Second*10, ttls[0], float64(time.Second))
+	}
+	assert.Empty(t, mcaching.refreshAhead.pick(time.Now(), time.Hour*2, 1))
+}
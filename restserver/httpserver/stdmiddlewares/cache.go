@@ -2,15 +2,16 @@ package stdmiddlewares
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/wencan/fastrest/restcache"
+	"github.com/wencan/fastrest/resterror"
 )
 
 // RequestCacheKeyGenerator 根据http.Request生成缓存key。如果返回空字符串，表示不使用缓存。
@@ -24,7 +25,8 @@ var DefaultRequestCacheKeyGenerator = func(r *http.Request) string {
 		return ""
 	}
 
-	if strings.EqualFold(r.Header.Get("Cache-Control"), "no-Cache") {
+	cc := parseCacheControl(r.Header)
+	if cc.has("no-cache") || cc.has("no-store") {
 		return ""
 	}
 
@@ -45,6 +47,9 @@ type cacheableResponse struct {
 
 	// GenerateTimestamp 响应生成时的时间辍。用于判断缓存是否有效。
 	GenerateTimestamp int64 `json:"generate_ts" msgpack:"generate_ts"`
+
+	// Variant 生成响应的请求的变体标识。由响应的Vary头指定的请求头的值生成。
+	Variant string `json:"variant,omitempty" msgpack:"variant,omitempty"`
 }
 
 // Header 实现http.ResponseWriter接口。
@@ -56,8 +61,12 @@ func (resp *cacheableResponse) Header() http.Header {
 }
 
 // Write 实现http.ResponseWriter接口。
+// 中间件通过responseCapture复制响应，不使用这里。
 func (resp *cacheableResponse) Write(p []byte) (int, error) {
-	resp.Body = append(resp.Body, p...) // 后面优化
+	if resp.StatusCode == 0 {
+		resp.StatusCode = http.StatusOK
+	}
+	resp.Body = append(resp.Body, p...)
 	return len(p), nil
 }
 
@@ -67,39 +76,26 @@ func (resp *cacheableResponse) WriteHeader(statusCode int) {
 }
 
 // IsValidCache 实现restcache的Validatable接口。
+// 过时的响应，在stale-while-revalidate、stale-if-error时间内仍然有效。
 func (resp cacheableResponse) IsValidCache() bool {
-	cacheCtrl := resp.Headers.Get("Cache-Control")
-
-	if strings.EqualFold(cacheCtrl, "no-Store") {
+	cc := parseCacheControl(resp.Headers)
+	if cc.has("no-store") || cc.has("private") {
 		return false
 	}
 
-	// 下面根据时间判断
-	if resp.GenerateTimestamp <= 0 {
-		return true
-	}
-
-	if strings.HasPrefix(cacheCtrl, "max-age=") {
-		maxAge, _ := strconv.Atoi(strings.ReplaceAll(cacheCtrl, "max-age=", ""))
-		age := sinceUnix(resp.GenerateTimestamp)
-		return maxAge >= int(age)
-	}
-	if strings.HasPrefix(cacheCtrl, "s-maxage=") {
-		maxAge, _ := strconv.Atoi(strings.ReplaceAll(cacheCtrl, "s-maxage=", ""))
-		age := sinceUnix(resp.GenerateTimestamp)
-		return maxAge >= int(age)
-	}
+	return resp.freshness() != expired
+}
 
-	expires := resp.Headers.Get("Expires")
-	if expires == "0" {
-		return true
-	}
-	expiresTime, _ := time.Parse(time.RFC1123, expires)
-	if !expiresTime.IsZero() {
-		return expiresTime.Unix() >= getNowUnix()
+// CacheSize 实现lrucache的Sizable接口。用于按字节数限制容量的lru缓存。
+func (resp cacheableResponse) CacheSize() int64 {
+	size := int64(len(resp.Body))
+	for key, values := range resp.Headers {
+		size += int64(len(key))
+		for _, value := range values {
+			size += int64(len(value))
+		}
 	}
-
-	return true
+	return size
 }
 
 // Reset 实现restcache的Resetable接口。
@@ -108,16 +104,41 @@ func (resp *cacheableResponse) Reset() {
 	resp.StatusCode = 0
 	resp.Headers = nil
 	resp.Body = nil
+	resp.Variant = ""
+}
+
+// respond 输出响应。如果是缓存的响应，加上Age头。如果请求的条件表示客户端的响应仍然有效，输出304。
+func (resp *cacheableResponse) respond(w http.ResponseWriter, r *http.Request, cached bool) {
+	if cached && resp.GenerateTimestamp > 0 {
+		age := sinceUnix(resp.GenerateTimestamp)
+		if age < 0 {
+			age = 0
+		}
+		w.Header().Set("Age", strconv.FormatInt(age, 10))
+	}
+
+	if resp.notModified(r) {
+		resp.ApplyNotModified(w)
+		return
+	}
+	resp.Apply(w)
 }
 
 // Apply 输出。
 func (resp *cacheableResponse) Apply(w http.ResponseWriter) {
 	for key, values := range resp.Headers {
+		if key == "Age" && w.Header().Get("Age") != "" { // 以respond计算的为准
+			continue
+		}
 		for _, value := range values {
 			w.Header().Add(key, value)
 		}
 	}
-	w.WriteHeader(resp.StatusCode)
+	statusCode := resp.StatusCode
+	if statusCode == 0 { // 没有调用WriteHeader
+		statusCode = http.StatusOK
+	}
+	w.WriteHeader(statusCode)
 	if resp.Body != nil {
 		w.Write(resp.Body)
 	}
@@ -133,21 +154,103 @@ type handlerQueryArgs struct {
 	request *http.Request
 
 	next http.HandlerFunc
+
+	// writer 客户端的ResponseWriter。可选。
+	// 如果不为nil，执行next时，响应流式输出到客户端。
+	writer http.ResponseWriter
+
+	// stale 重新验证时，过时的响应。
+	stale *cacheableResponse
+
+	// response 执行next得到的响应。
+	// 如果响应不可缓存，查询函数返回没找到，响应不存储，也不共享给同时等待的请求。
+	response *cacheableResponse
+
+	// recovery 执行next发生panic时，recover()的返回值。
+	recovery interface{}
+}
+
+// errRevalidateFailed 重新验证时，执行next得到错误的响应。不删除过时的响应。
+var errRevalidateFailed = errors.New("revalidate failed")
+
+// CacheErrorHandler 缓存出错时的处理函数。比如缓存存储不可用。
+// 如果是执行next发生了panic，同时等待的其它请求的err为resterror.PanicError。
+type CacheErrorHandler func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc, err error)
+
+// DefaultCacheErrorHandler 默认的缓存出错时的处理函数。跳过缓存，直接执行next。可覆盖。
+var DefaultCacheErrorHandler CacheErrorHandler = func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc, err error) {
+	next(w, r)
+}
+
+// CacheMiddlewareFactory 缓存中间件工厂。
+type CacheMiddlewareFactory struct {
+	// Storage 缓存存储器。
+	Storage restcache.Storage
+
+	// TTLRange 缓存生存时间区间。
+	TTLRange [2]time.Duration
+
+	// KeyGenerator 缓存key生成器。默认为：DefaultRequestCacheKeyGenerator。
+	KeyGenerator RequestCacheKeyGenerator
+
+	// CacheableStatus 响应状态码是否可以缓存。默认为：DefaultCacheableStatus。
+	// 带有Set-Cookie头、Vary: *，或者Cache-Control带有no-store、private、no-cache的响应，都不缓存。
+	CacheableStatus func(statusCode int) bool
+
+	// GenerateETag 是否为没有ETag、Last-Modified的200响应生成强ETag和Last-Modified。可选。
+	// 无论是否生成，带有ETag、Last-Modified的响应，都支持If-None-Match、If-Modified-Since条件请求，直接从缓存输出304。
+	// 执行next的请求的响应是流式输出的，不带生成的ETag和Last-Modified。
+	GenerateETag bool
+
+	// MaxBodySize 可缓存的响应体最大字节数。默认为DefaultMaxBodySize。小于0不限制。
+	// 执行next的请求的响应流式输出到客户端，同时复制到缓冲区。超过最大字节数，放弃复制，响应不缓存。
+	MaxBodySize int64
+
+	// Observer 缓存事件观察者。可选。
+	Observer restcache.Observer
+
+	// TrustRequestCacheControl 是否信任请求的缓存控制。可选。
+	// 如果返回true，请求头带有Cache-Control: no-cache或者max-age=0时，重新执行处理，并覆盖缓存。
+	// 一般只信任内部的管理工具。
+	TrustRequestCacheControl func(r *http.Request) bool
+
+	// ErrorHandler 缓存出错时的处理函数。默认为DefaultCacheErrorHandler。
+	// 执行了next的请求，响应已经输出，出错时不再调用ErrorHandler。
+	ErrorHandler CacheErrorHandler
+
+	// OnError 缓存出错时的通知。可选。默认输出到标准错误。
+	// 包括后台重新验证出错。
+	OnError func(r *http.Request, err error)
+}
+
+// reportError 通知错误。
+func (factory CacheMiddlewareFactory) reportError(r *http.Request, err error) {
+	if factory.OnError != nil {
+		factory.OnError(r, err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Error in cache middleware: %s\n", err)
 }
 
 // NewCacheMiddleware 创建http.Handler的缓存中间件。
-// storage 为缓存存储器。
-// ttlRange 为缓存生存时间区间。
-// keyGenerator 为缓存key生成器。默认为：DefaultRequestCacheKeyGenerator。
 // 支持简单的常用的HTTP缓存控制。
-func NewCacheMiddleware(
-	storage restcache.Storage,
-	ttlRange [2]time.Duration,
-	keyGenerator RequestCacheKeyGenerator,
-) func(next http.HandlerFunc) http.HandlerFunc {
+func (factory CacheMiddlewareFactory) NewCacheMiddleware() func(next http.HandlerFunc) http.HandlerFunc {
+	keyGenerator := factory.KeyGenerator
 	if keyGenerator == nil {
 		keyGenerator = DefaultRequestCacheKeyGenerator
 	}
+	cacheableStatus := factory.CacheableStatus
+	if cacheableStatus == nil {
+		cacheableStatus = DefaultCacheableStatus
+	}
+	maxBodySize := factory.MaxBodySize
+	if maxBodySize == 0 {
+		maxBodySize = DefaultMaxBodySize
+	}
+	errorHandler := factory.ErrorHandler
+	if errorHandler == nil {
+		errorHandler = DefaultCacheErrorHandler
+	}
 
 	// 如果没命中，要执行的过程
 	query := func(ctx context.Context, destPtr, args interface{}) (found bool, err error) {
@@ -157,17 +260,61 @@ func NewCacheMiddleware(
 		r := queryArgs.request
 		next := queryArgs.next
 
-		next(w, r)
+		capture := newResponseCapture(queryArgs.writer, w, maxBodySize)
+		defer func() {
+			// next发生panic，作为错误结束哨兵，同时等待的请求不会得到不完整的响应
+			// 执行next的请求，在Get返回后重新panic
+			if recovery := recover(); recovery != nil {
+				capture.releaseBuffer()
+				queryArgs.recovery = recovery
+				found, err = false, resterror.NewPanicError(recovery)
+			}
+		}()
+		next(capture, r)
+		captured := capture.finish()
 		w.GenerateTimestamp = time.Now().Unix() // 用于支持缓存控制
+		if factory.GenerateETag {
+			w.generateValidators()
+		}
+		w.Variant = requestVariant(r, w.varyHeaders())
+		queryArgs.response = w
+
+		if !captured || !w.isCacheable(cacheableStatus) {
+			if queryArgs.stale != nil && w.StatusCode >= http.StatusInternalServerError {
+				// 重新验证出错，保留过时的响应
+				return false, errRevalidateFailed
+			}
+			return false, nil
+		}
 		return true, nil
 	}
 
 	// 缓存中间件
-	caching := restcache.Caching{Storage: storage, Query: query, TTLRange: ttlRange, SentinelTTL: time.Second}
+	caching := &restcache.Caching{
+		Storage:     factory.Storage,
+		Query:       query,
+		TTLRange:    factory.TTLRange,
+		SentinelTTL: time.Second,
+		Observer:    factory.Observer,
+	}
+	revalidate := &revalidator{caching: caching, reportError: factory.reportError}
 
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
-			key := keyGenerator(r)
+			if restcache.IsBypass(r.Context()) { // 跳过缓存
+				next(w, r)
+				return
+			}
+
+			keyRequest := r
+			if factory.TrustRequestCacheControl != nil && requestRequiresRefresh(r) && factory.TrustRequestCacheControl(r) {
+				// 强制刷新。生成key时忽略请求的缓存控制
+				r = r.WithContext(restcache.WithRefresh(r.Context()))
+				keyRequest = r.Clone(r.Context())
+				keyRequest.Header.Del("Cache-Control")
+			}
+
+			key := keyGenerator(keyRequest)
 			if key == "" { // 不需要或者不支持缓存
 				next(w, r)
 				return
@@ -176,16 +323,138 @@ func NewCacheMiddleware(
 			// 执行缓存逻辑
 			// 如果没命中缓存，由缓存中间件去执行next
 			resp := cacheableResponse{}
-			args := &handlerQueryArgs{request: r, next: next}
+			args := &handlerQueryArgs{request: r, next: next, writer: w}
 			found, err := caching.Get(r.Context(), &resp, key, args)
-			if !found || err != nil { // 这里不应该返回not found，或者err != nil
-				fmt.Fprintf(os.Stderr, "Error in cache middleware")
-				w.WriteHeader(http.StatusInternalServerError)
+			if err == nil && found && args.response == nil {
+				// 其它请求得到的响应。如果Vary指定的请求头的值不同，使用变体的缓存
+				if variant := requestVariant(r, resp.varyHeaders()); variant != resp.Variant {
+					key = variantKey(key, variant)
+					resp = cacheableResponse{}
+					args = &handlerQueryArgs{request: r, next: next, writer: w}
+					found, err = caching.Get(r.Context(), &resp, key, args)
+					if err == nil && found && args.response == nil && requestVariant(r, resp.varyHeaders()) != resp.Variant {
+						// 变体的Vary也不同，不使用缓存
+						next(w, r)
+						return
+					}
+				}
+			}
+			if args.recovery != nil {
+				// 本请求执行next发生了panic。哨兵已经结束，重新panic，交给外层处理
+				panic(args.recovery)
+			}
+			if args.response != nil {
+				// 本请求执行了next，响应已经流式输出
+				if err != nil {
+					factory.reportError(r, err)
+				}
 				return
 			}
+			if err != nil {
+				factory.reportError(r, err)
+				errorHandler(w, r, next, err)
+				return
+			}
+			if !found {
+				// 其它请求执行next得到的响应不可缓存，也不可共享
+				next(w, r)
+				return
+			}
+
+			// 缓存的响应
+			switch resp.freshness() {
+			case staleWhileRevalidate:
+				// 照常输出过时的响应，后台重新验证
+				revalidate.inBackground(key, r, next, &resp)
+			case staleIfError:
+				// 重新验证，出错时输出过时的响应
+				revalidated, recovery, err := revalidate.do(r.Context(), key, r, next, &resp)
+				if recovery != nil {
+					panic(recovery)
+				}
+				if err == nil {
+					if revalidated != nil {
+						revalidated.respond(w, r, false)
+					} else {
+						next(w, r)
+					}
+					return
+				}
+			}
 
 			// 输出
-			resp.Apply(w)
+			resp.respond(w, r, true)
 		}
 	}
 }
+
+// revalidator 重新验证过时的缓存的响应。
+type revalidator struct {
+	caching *restcache.Caching
+
+	// reportError 通知后台重新验证的错误。
+	reportError func(r *http.Request, err error)
+
+	// revalidating 正在后台重新验证的key。
+	revalidating sync.Map
+}
+
+// do 重新执行next，覆盖缓存的响应。返回新的响应。
+// 如果新的响应不可缓存且是同时重新验证的其它请求得到的，不可共享，返回nil。
+// 如果执行next发生了panic，返回recover()的返回值。
+func (revalidate *revalidator) do(ctx context.Context, key string, r *http.Request, next http.HandlerFunc, stale *cacheableResponse) (resp *cacheableResponse, recovery interface{}, err error) {
+	resp = &cacheableResponse{}
+	args := &handlerQueryArgs{request: r, next: next, stale: stale}
+	found, err := revalidate.caching.Get(restcache.WithRefresh(ctx), resp, key, args)
+	if err != nil {
+		return nil, args.recovery, err
+	}
+	if !found {
+		return args.response, nil, nil
+	}
+	return resp, nil, nil
+}
+
+// inBackground 在后台重新验证。同一key同时只有一个重新验证。
+func (revalidate *revalidator) inBackground(key string, r *http.Request, next http.HandlerFunc, stale *cacheableResponse) {
+	if _, loaded := revalidate.revalidating.LoadOrStore(key, struct{}{}); loaded {
+		return
+	}
+
+	// 请求结束后，请求的上下文会被取消
+	r = r.Clone(context.Background())
+	go func() {
+		defer revalidate.revalidating.Delete(key)
+
+		// 后台重新验证发生panic，只通知错误
+		_, _, err := revalidate.do(r.Context(), key, r, next, stale)
+		if err != nil && err != errRevalidateFailed {
+			revalidate.reportError(r, err)
+		}
+	}()
+}
+
+// requestRequiresRefresh 请求是否要求重新验证缓存。请求头带有Cache-Control: no-cache或者max-age=0。
+func requestRequiresRefresh(r *http.Request) bool {
+	cc := parseCacheControl(r.Header)
+	maxAge, ok := cc.seconds("max-age")
+	return cc.has("no-cache") || (ok && maxAge == 0)
+}
+
+// NewCacheMiddleware 创建http.Handler的缓存中间件。
+// storage 为缓存存储器。
+// ttlRange 为缓存生存时间区间。
+// keyGenerator 为缓存key生成器。默认为：DefaultRequestCacheKeyGenerator。
+// 支持简单的常用的HTTP缓存控制。
+func NewCacheMiddleware(
+	storage restcache.Storage,
+	ttlRange [2]time.Duration,
+	keyGenerator RequestCacheKeyGenerator,
+) func(next http.HandlerFunc) http.HandlerFunc {
+	factory := CacheMiddlewareFactory{
+		Storage:      storage,
+		TTLRange:     ttlRange,
+		KeyGenerator: keyGenerator,
+	}
+	return factory.NewCacheMiddleware()
+}
@@ -2,6 +2,7 @@ package stdmiddlewares
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
@@ -10,13 +11,16 @@ import (
 	"net/url"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"github.com/wencan/fastrest/restcache"
 	"github.com/wencan/fastrest/restcache/lrucache"
 	"github.com/wencan/fastrest/restcache/mock_restcache"
+	"github.com/wencan/fastrest/resterror"
 	"github.com/wencan/fastrest/restutils"
 )
 
@@ -35,6 +39,7 @@ func TestNewCacheMiddleware(t *testing.T) {
 		statusCode int
 		headers    http.Header
 		body       []byte
+		age        bool
 		error      bool
 	}
 	tests := []struct {
@@ -208,6 +213,7 @@ func TestNewCacheMiddleware(t *testing.T) {
 					"Content-Type":   []string{"text/plain; charset=utf-8"},
 				},
 				body: []byte("有效的缓存"),
+				age:  true,
 			},
 		},
 		{
@@ -274,6 +280,7 @@ func TestNewCacheMiddleware(t *testing.T) {
 					"Content-Type":   []string{"text/plain; charset=utf-8"},
 				},
 				body: []byte("未过期"),
+				age:  true,
 			},
 		},
 		{
@@ -349,12 +356,13 @@ func TestNewCacheMiddleware(t *testing.T) {
 
 					gotHeaders := make(http.Header)
 					for key, values := range resp.Header {
-						if restutils.StringSliceContains([]string{"Date", "Expires"}, key) { // 过滤掉日期时间
+						if restutils.StringSliceContains([]string{"Date", "Expires", "Age"}, key) { // 过滤掉日期时间
 							continue
 						}
 						gotHeaders[key] = values
 					}
 					assert.Equal(t, tt.want.headers, gotHeaders)
+					assert.Equal(t, tt.want.age, resp.Header.Get("Age") != "")
 
 					gotBody, err := io.ReadAll(resp.Body)
 					if assert.Nil(t, err) {
@@ -398,3 +406,373 @@ func TestNewLRUCacheMiddleware(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestCacheMiddlewareFactory_Observer(t *testing.T) {
+	var hits, misses int64
+	factory := CacheMiddlewareFactory{
+		Storage:  lrucache.NewLRUCache(100, 10),
+		TTLRange: [2]time.Duration{time.Minute, time.Minute * 2},
+		Observer: restcache.ObserverFunc(func(ctx context.Context, event restcache.Event) {
+			switch event.Type {
+			case restcache.EventHit:
+				atomic.AddInt64(&hits, 1)
+			case restcache.EventMiss:
+				atomic.AddInt64(&misses, 1)
+			}
+		}),
+	}
+	handler := factory.NewCacheMiddleware()(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("hello"))
+	})
+
+	for i := 0; i < 3; i++ {
+		body := assert.HTTPBody(handler, http.MethodGet, "/hello", nil)
+		assert.Equal(t, "hello", body)
+	}
+	assert.Equal(t, int64(2), atomic.LoadInt64(&hits))
+	assert.Equal(t, int64(1), atomic.LoadInt64(&misses))
+}
+
+func TestCacheMiddlewareFactory_Refresh(t *testing.T) {
+	var count int64
+	factory := CacheMiddlewareFactory{
+		Storage:  lrucache.NewLRUCache(100, 10),
+		TTLRange: [2]time.Duration{time.Minute, time.Minute * 2},
+		TrustRequestCacheControl: func(r *http.Request) bool {
+			return r.Header.Get("X-Admin") == "true"
+		},
+	}
+	handler := factory.NewCacheMiddleware()(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(fmt.Sprintf("hello %d", atomic.AddInt64(&count, 1))))
+	})
+	serve := func(ctx context.Context, header http.Header) string {
+		r := httptest.NewRequest(http.MethodGet, "/hello", nil).WithContext(ctx)
+		for key, values := range header {
+			r.Header[key] = values
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Body.String()
+	}
+
+	assert.Equal(t, "hello 1", serve(context.TODO(), nil))
+	assert.Equal(t, "hello 1", serve(context.TODO(), nil))
+
+	// 不信任的，跳过缓存
+	assert.Equal(t, "hello 2", serve(context.TODO(), http.Header{"Cache-Control": []string{"no-cache"}}))
+	assert.Equal(t, "hello 1", serve(context.TODO(), nil))
+
+	// 信任的，强制刷新
+	assert.Equal(t, "hello 3", serve(context.TODO(), http.Header{"Cache-Control": []string{"max-age=0"}, "X-Admin": []string{"true"}}))
+	assert.Equal(t, "hello 3", serve(context.TODO(), nil))
+
+	// 上下文要求跳过缓存
+	assert.Equal(t, "hello 4", serve(restcache.WithBypass(context.TODO()), nil))
+	assert.Equal(t, "hello 3", serve(context.TODO(), nil))
+
+	// 上下文要求强制刷新
+	assert.Equal(t, "hello 5", serve(restcache.WithRefresh(context.TODO()), nil))
+	assert.Equal(t, "hello 5", serve(context.TODO(), nil))
+}
+
+func TestCacheMiddlewareFactory_Uncacheable(t *testing.T) {
+	var count int64
+	factory := CacheMiddlewareFactory{
+		Storage:  lrucache.NewLRUCache(100, 10),
+		TTLRange: [2]time.Duration{time.Minute, time.Minute * 2},
+	}
+	handler := factory.NewCacheMiddleware()(func(w http.ResponseWriter, r *http.Request) {
+		number := atomic.AddInt64(&count, 1)
+		switch r.URL.Path {
+		case "/error":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/cookie":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: strconv.FormatInt(number, 10)})
+			w.WriteHeader(http.StatusOK)
+		case "/private":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+			w.WriteHeader(http.StatusOK)
+		}
+		w.Write([]byte(fmt.Sprintf("hello %d", number)))
+	})
+
+	// 不可缓存的响应，每次都执行
+	for _, path := range []string{"/error", "/cookie", "/private"} {
+		for i := 0; i < 2; i++ {
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest(http.MethodGet, path, nil))
+			assert.Equal(t, fmt.Sprintf("hello %d", atomic.LoadInt64(&count)), w.Body.String())
+			assert.Empty(t, w.Header().Get("Age"))
+		}
+	}
+	assert.Equal(t, int64(6), atomic.LoadInt64(&count))
+}
+
+func TestCacheMiddlewareFactory_Stale(t *testing.T) {
+	storage := lrucache.NewLRUCache(100, 10)
+	factory := CacheMiddlewareFactory{
+		Storage:  storage,
+		TTLRange: [2]time.Duration{time.Minute, time.Minute * 2},
+	}
+	var statusCode int64 = http.StatusOK
+	revalidated := make(chan struct{}, 1)
+	handler := factory.NewCacheMiddleware()(func(w http.ResponseWriter, r *http.Request) {
+		defer func() { revalidated <- struct{}{} }()
+		w.Header().Set("Cache-Control", r.URL.Query().Get("cc"))
+		w.WriteHeader(int(atomic.LoadInt64(&statusCode)))
+		w.Write([]byte("new"))
+	})
+	serve := func(cc string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/hello?cc="+url.QueryEscape(cc), nil)
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+	store := func(cc string) {
+		r := httptest.NewRequest(http.MethodGet, "/hello?cc="+url.QueryEscape(cc), nil)
+		err := storage.Set(context.TODO(), DefaultRequestCacheKeyGenerator(r), cacheableResponse{
+			StatusCode:        http.StatusOK,
+			Headers:           http.Header{"Cache-Control": []string{cc}},
+			Body:              []byte("stale"),
+			GenerateTimestamp: time.Now().Add(-time.Second * 90).Unix(),
+		}, time.Minute)
+		assert.Nil(t, err)
+	}
+
+	// 过时，在stale-while-revalidate时间内。输出过时的，后台重新验证
+	cc := "max-age=60, stale-while-revalidate=60"
+	store(cc)
+	w := serve(cc)
+	assert.Equal(t, "stale", w.Body.String())
+	assert.Contains(t, []string{"90", "91"}, w.Header().Get("Age"))
+	<-revalidated
+	assert.Eventually(t, func() bool {
+		return serve(cc).Body.String() == "new"
+	}, time.Second, time.Millisecond*10)
+
+	// 过时，在stale-if-error时间内。重新验证出错，输出过时的
+	cc = "max-age=60, stale-if-error=60"
+	store(cc)
+	atomic.StoreInt64(&statusCode, http.StatusServiceUnavailable)
+	w = serve(cc)
+	<-revalidated
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "stale", w.Body.String())
+
+	// 重新验证成功，输出新的
+	atomic.StoreInt64(&statusCode, http.StatusOK)
+	w = serve(cc)
+	<-revalidated
+	assert.Equal(t, "new", w.Body.String())
+	assert.Empty(t, w.Header().Get("Age"))
+
+	// 过时，must-revalidate
+	cc = "max-age=60, must-revalidate, stale-if-error=60"
+	store(cc)
+	atomic.StoreInt64(&statusCode, http.StatusServiceUnavailable)
+	w = serve(cc)
+	<-revalidated
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestCacheMiddlewareFactory_Vary(t *testing.T) {
+	var count int64
+	factory := CacheMiddlewareFactory{
+		Storage:  lrucache.NewLRUCache(100, 10),
+		TTLRange: [2]time.Duration{time.Minute, time.Minute * 2},
+	}
+	handler := factory.NewCacheMiddleware()(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&count, 1)
+		w.Header().Set("Vary", "Accept")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("accept: " + r.Header.Get("Accept")))
+	})
+	serve := func(accept string) string {
+		r := httptest.NewRequest(http.MethodGet, "/hello", nil)
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Body.String()
+	}
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, "accept: application/json", serve("application/json"))
+		assert.Equal(t, "accept: application/x-protobuf", serve("application/x-protobuf"))
+	}
+	assert.Equal(t, int64(2), atomic.LoadInt64(&count))
+}
+
+func TestCacheMiddlewareFactory_Conditional(t *testing.T) {
+	var count int64
+	factory := CacheMiddlewareFactory{
+		Storage:      lrucache.NewLRUCache(100, 10),
+		TTLRange:     [2]time.Duration{time.Minute, time.Minute * 2},
+		GenerateETag: true,
+	}
+	handler := factory.NewCacheMiddleware()(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&count, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("hello"))
+	})
+	serve := func(header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/hello", nil)
+		for key, values := range header {
+			r.Header[key] = values
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	// 执行next的请求，响应流式输出，不带生成的ETag
+	w := serve(nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "hello", w.Body.String())
+	assert.Empty(t, w.Header().Get("ETag"))
+
+	w = serve(nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "hello", w.Body.String())
+	etag, lastModified := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+	assert.NotEmpty(t, etag)
+	assert.NotEmpty(t, lastModified)
+
+	// 从缓存输出304
+	w = serve(http.Header{"If-None-Match": []string{etag}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+	assert.Equal(t, "max-age=60", w.Header().Get("Cache-Control"))
+	assert.NotEmpty(t, w.Header().Get("Age"))
+
+	w = serve(http.Header{"If-Modified-Since": []string{lastModified}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	// 条件不满足，输出完整的响应
+	w = serve(http.Header{"If-None-Match": []string{`"other"`}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "hello", w.Body.String())
+
+	assert.Equal(t, int64(1), atomic.LoadInt64(&count))
+}
+
+func TestCacheMiddlewareFactory_ConditionalFirst(t *testing.T) {
+	factory := CacheMiddlewareFactory{
+		Storage:  lrucache.NewLRUCache(100, 10),
+		TTLRange: [2]time.Duration{time.Minute, time.Minute * 2},
+	}
+	handler := factory.NewCacheMiddleware()(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("hello"))
+	})
+	serve := func(header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/hello", nil)
+		for key, values := range header {
+			r.Header[key] = values
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	// 条件请求先执行next，next输出的304不缓存
+	w := serve(http.Header{"If-None-Match": []string{`"v1"`}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	// 普通请求输出完整的响应，而不是缓存的304
+	for i := 0; i < 2; i++ {
+		w = serve(nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "hello", w.Body.String())
+	}
+}
+
+func TestCacheMiddlewareFactory_Error(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	storage := mock_restcache.NewMockStorage(ctrl)
+	storage.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(false, errors.New("storage is down")).AnyTimes()
+
+	var reported []error
+	factory := CacheMiddlewareFactory{
+		Storage:  storage,
+		TTLRange: [2]time.Duration{time.Minute, time.Minute * 2},
+		OnError: func(r *http.Request, err error) {
+			reported = append(reported, err)
+		},
+	}
+	next := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("hello"))
+	}
+
+	// 默认跳过缓存，执行next
+	w := httptest.NewRecorder()
+	factory.NewCacheMiddleware()(next)(w, httptest.NewRequest(http.MethodGet, "/hello", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "hello", w.Body.String())
+	if assert.Len(t, reported, 1) {
+		assert.EqualError(t, reported[0], "storage is down")
+	}
+
+	// 自定义的处理
+	factory.ErrorHandler = func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc, err error) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w = httptest.NewRecorder()
+	factory.NewCacheMiddleware()(next)(w, httptest.NewRequest(http.MethodGet, "/hello", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Len(t, reported, 2)
+}
+
+func TestCacheMiddlewareFactory_Panic(t *testing.T) {
+	var count int64
+	var reported []error
+	factory := CacheMiddlewareFactory{
+		Storage:  lrucache.NewLRUCache(100, 10),
+		TTLRange: [2]time.Duration{time.Minute, time.Minute * 2},
+		OnError: func(r *http.Request, err error) {
+			reported = append(reported, err)
+		},
+	}
+	handler := factory.NewCacheMiddleware()(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&count, 1) == 1 {
+			panic("oops")
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("hello"))
+	})
+
+	// 执行next的请求，重新panic
+	assert.PanicsWithValue(t, "oops", func() {
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/hello", nil))
+	})
+
+	// 哨兵时间内的请求，得到panic错误，默认执行next
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/hello", nil))
+	assert.Equal(t, "hello", w.Body.String())
+	if assert.Len(t, reported, 1) {
+		_, ok := resterror.AsPanic(reported[0])
+		assert.True(t, ok)
+	}
+
+	// 哨兵结束后，照常缓存
+	time.Sleep(time.Second + time.Millisecond*100)
+	for i := 0; i < 2; i++ {
+		w = httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, "/hello", nil))
+		assert.Equal(t, "hello", w.Body.String())
+	}
+	assert.Equal(t, int64(3), atomic.LoadInt64(&count))
+}
@@ -0,0 +1,164 @@
+package stdmiddlewares
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheControl 解析后的Cache-Control指令。指令名为小写，值去掉了引号。
+type cacheControl map[string]string
+
+// parseCacheControl 解析Cache-Control头的指令列表。重复的指令，以第一个为准。
+func parseCacheControl(header http.Header) cacheControl {
+	cc := make(cacheControl)
+	for _, line := range header.Values("Cache-Control") {
+		for _, directive := range splitDirectives(line) {
+			name, value, _ := strings.Cut(directive, "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if _, ok := cc[name]; ok {
+				continue
+			}
+			cc[name] = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return cc
+}
+
+// splitDirectives 按逗号分割指令。忽略引号内的逗号，比如private="Set-Cookie, Authorization"。
+func splitDirectives(line string) []string {
+	var directives []string
+	var quoted bool
+	var begin int
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				directives = append(directives, line[begin:i])
+				begin = i + 1
+			}
+		}
+	}
+	return append(directives, line[begin:])
+}
+
+// has 是否有指令。
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// seconds 取得秒数形式的指令值。没有指令，或者值不是非负整数，返回false。
+func (cc cacheControl) seconds(name string) (int64, bool) {
+	value, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return seconds, true
+}
+
+// DefaultCacheableStatus 默认的可缓存的响应状态码判断。可覆盖。
+// 只缓存2xx、3xx的响应。没有调用WriteHeader的，状态码为0，等同200。
+// 不缓存304和206：它们是对条件请求和范围请求的响应，没有完整的响应体，不能用于响应其它请求。
+var DefaultCacheableStatus = func(statusCode int) bool {
+	switch statusCode {
+	case 0:
+		return true
+	case http.StatusNotModified, http.StatusPartialContent:
+		return false
+	}
+	return statusCode >= 200 && statusCode < 400
+}
+
+// freshness 缓存的响应的新鲜程度。
+type freshness int
+
+const (
+	// fresh 新鲜。直接使用。
+	fresh freshness = iota
+
+	// staleWhileRevalidate 过时，在stale-while-revalidate时间内。照常使用，同时在后台重新验证。
+	staleWhileRevalidate
+
+	// staleIfError 过时，在stale-if-error时间内。需要重新验证，如果出错，使用过时的响应。
+	staleIfError
+
+	// expired 过期。不能使用。
+	expired
+)
+
+// freshnessLifetime 响应的新鲜时间。优先s-maxage，其次max-age，再次Expires。
+// 如果没有明确的新鲜时间，返回false，新鲜时间由缓存的生存时间决定。
+func (resp cacheableResponse) freshnessLifetime(cc cacheControl) (lifetime int64, ok bool) {
+	if lifetime, ok := cc.seconds("s-maxage"); ok {
+		return lifetime, true
+	}
+	if lifetime, ok := cc.seconds("max-age"); ok {
+		return lifetime, true
+	}
+
+	expires := resp.Headers.Get("Expires")
+	if expires == "" || expires == "0" {
+		return 0, false
+	}
+	expiresTime, err := time.Parse(time.RFC1123, expires)
+	if err != nil {
+		expiresTime, err = http.ParseTime(expires)
+		if err != nil {
+			return 0, false
+		}
+	}
+	return expiresTime.Unix() - resp.GenerateTimestamp, true
+}
+
+// freshness 判断缓存的响应的新鲜程度。
+func (resp cacheableResponse) freshness() freshness {
+	if resp.GenerateTimestamp <= 0 {
+		return fresh
+	}
+
+	cc := parseCacheControl(resp.Headers)
+	lifetime, ok := resp.freshnessLifetime(cc)
+	if !ok {
+		return fresh
+	}
+	age := sinceUnix(resp.GenerateTimestamp)
+	if age <= lifetime {
+		return fresh
+	}
+
+	// 过时了。s-maxage同proxy-revalidate，不允许使用过时的响应
+	if cc.has("must-revalidate") || cc.has("proxy-revalidate") || cc.has("s-maxage") {
+		return expired
+	}
+	staleness := age - lifetime
+	if seconds, ok := cc.seconds("stale-while-revalidate"); ok && staleness <= seconds {
+		return staleWhileRevalidate
+	}
+	if seconds, ok := cc.seconds("stale-if-error"); ok && staleness <= seconds {
+		return staleIfError
+	}
+	return expired
+}
+
+// isCacheable 响应是否可以存储到共享缓存。
+func (resp cacheableResponse) isCacheable(cacheableStatus func(statusCode int) bool) bool {
+	if !cacheableStatus(resp.StatusCode) {
+		return false
+	}
+	if len(resp.Headers.Values("Set-Cookie")) > 0 || resp.varyAny() {
+		return false
+	}
+	cc := parseCacheControl(resp.Headers)
+	return !cc.has("no-store") && !cc.has("private") && !cc.has("no-cache")
+}
@@ -0,0 +1,89 @@
+package stdmiddlewares
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseCacheControl(t *testing.T) {
+	header := http.Header{"Cache-Control": []string{`Max-Age=60, private="Set-Cookie, Authorization"`, "no-cache, max-age=10"}}
+	cc := parseCacheControl(header)
+	assert.Equal(t, cacheControl{
+		"max-age":  "60",
+		"private":  "Set-Cookie, Authorization",
+		"no-cache": "",
+	}, cc)
+
+	seconds, ok := cc.seconds("max-age")
+	if assert.True(t, ok) {
+		assert.Equal(t, int64(60), seconds)
+	}
+	_, ok = cc.seconds("private")
+	assert.False(t, ok)
+	_, ok = cc.seconds("s-maxage")
+	assert.False(t, ok)
+}
+
+func Test_cacheableResponse_freshness(t *testing.T) {
+	tests := []struct {
+		name         string
+		cacheControl string
+		age          time.Duration
+		want         freshness
+	}{
+		{name: "no_lifetime", age: time.Hour, want: fresh},
+		{name: "fresh", cacheControl: "public, max-age=60", age: time.Second * 30, want: fresh},
+		{name: "s-maxage_first", cacheControl: "max-age=10, s-maxage=60", age: time.Second * 30, want: fresh},
+		{name: "expired", cacheControl: "max-age=60", age: time.Second * 90, want: expired},
+		{name: "stale_while_revalidate", cacheControl: "max-age=60, stale-while-revalidate=60", age: time.Second * 90, want: staleWhileRevalidate},
+		{name: "stale_if_error", cacheControl: "max-age=60, stale-if-error=60", age: time.Second * 90, want: staleIfError},
+		{name: "stale_too_long", cacheControl: "max-age=60, stale-while-revalidate=10, stale-if-error=10", age: time.Second * 90, want: expired},
+		{name: "must_revalidate", cacheControl: "max-age=60, must-revalidate, stale-while-revalidate=60", age: time.Second * 90, want: expired},
+		{name: "s-maxage_revalidate", cacheControl: "s-maxage=60, stale-if-error=60", age: time.Second * 90, want: expired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := cacheableResponse{
+				Headers:           http.Header{},
+				GenerateTimestamp: time.Now().Add(-tt.age).Unix(),
+			}
+			if tt.cacheControl != "" {
+				resp.Headers.Set("Cache-Control", tt.cacheControl)
+			}
+			assert.Equal(t, tt.want, resp.freshness())
+		})
+	}
+}
+
+func Test_cacheableResponse_isCacheable(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		headers    http.Header
+		want       bool
+	}{
+		{name: "ok", statusCode: http.StatusOK, want: true},
+		{name: "no_status", statusCode: 0, want: true},
+		{name: "redirect", statusCode: http.StatusMovedPermanently, want: true},
+		{name: "not_modified", statusCode: http.StatusNotModified, want: false},
+		{name: "partial_content", statusCode: http.StatusPartialContent, want: false},
+		{name: "not_found", statusCode: http.StatusNotFound, want: false},
+		{name: "server_error", statusCode: http.StatusInternalServerError, want: false},
+		{name: "set_cookie", statusCode: http.StatusOK, headers: http.Header{"Set-Cookie": []string{"session=1"}}, want: false},
+		{name: "private", statusCode: http.StatusOK, headers: http.Header{"Cache-Control": []string{"private, max-age=60"}}, want: false},
+		{name: "no_store", statusCode: http.StatusOK, headers: http.Header{"Cache-Control": []string{"No-Store"}}, want: false},
+		{name: "public", statusCode: http.StatusOK, headers: http.Header{"Cache-Control": []string{"public, max-age=60"}}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := cacheableResponse{StatusCode: tt.statusCode, Headers: tt.headers}
+			if resp.Headers == nil {
+				resp.Headers = http.Header{}
+			}
+			assert.Equal(t, tt.want, resp.isCacheable(DefaultCacheableStatus))
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package stdmiddlewares
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// DefaultMaxBodySize 默认的可缓存的响应体最大字节数。
+const DefaultMaxBodySize = 1 << 20
+
+// errHijackNotSupported 不支持Hijack。
+var errHijackNotSupported = errors.New("hijack not supported")
+
+var captureBufferPool = sync.Pool{New: func() interface{} {
+	return new(bytes.Buffer)
+}}
+
+// responseCapture 执行next时使用的http.ResponseWriter，复制响应用于缓存。
+// 如果有客户端的ResponseWriter，响应同时流式输出到客户端；否则只复制到缓冲区。
+// 流式输出时，响应体超过最大字节数、或者被Hijack，放弃复制，响应不可缓存。
+type responseCapture struct {
+	// client 客户端的ResponseWriter。可以为nil。
+	client http.ResponseWriter
+
+	// resp 复制的响应。
+	resp *cacheableResponse
+
+	// buffer 复制响应体的缓冲区。来自缓冲池。
+	buffer *bytes.Buffer
+
+	// maxBodySize 可缓存的响应体最大字节数。小于等于0不限制。
+	maxBodySize int64
+
+	wroteHeader bool
+
+	// sentHeader 是否已经输出响应头。
+	sentHeader bool
+
+	// lateHeader 输出响应头之后，Header返回的响应头。对它的修改既不输出，也不缓存。
+	lateHeader http.Header
+
+	hijacked bool
+
+	// uncacheable 响应不可缓存。
+	uncacheable bool
+}
+
+func newResponseCapture(client http.ResponseWriter, resp *cacheableResponse, maxBodySize int64) *responseCapture {
+	buffer := captureBufferPool.Get().(*bytes.Buffer)
+	buffer.Reset()
+	return &responseCapture{
+		client:      client,
+		resp:        resp,
+		buffer:      buffer,
+		maxBodySize: maxBodySize,
+	}
+}
+
+// Header 实现http.ResponseWriter接口。
+// 输出响应头之后，返回一份副本，缓存的响应头同输出的响应头。
+func (capture *responseCapture) Header() http.Header {
+	if capture.sentHeader {
+		if capture.lateHeader == nil {
+			capture.lateHeader = capture.resp.Headers.Clone()
+		}
+		return capture.lateHeader
+	}
+	return capture.resp.Header()
+}
+
+// WriteHeader 实现http.ResponseWriter接口。
+// 响应头延迟到第一次写响应体时输出。在此之前对Header的修改仍然生效。
+func (capture *responseCapture) WriteHeader(statusCode int) {
+	if capture.wroteHeader || capture.hijacked {
+		return
+	}
+	capture.wroteHeader = true
+	capture.resp.StatusCode = statusCode
+}
+
+// sendHeader 输出响应头。之后对Header的修改不再生效，既不输出，也不缓存，同http.ResponseWriter。
+func (capture *responseCapture) sendHeader() {
+	if !capture.wroteHeader {
+		capture.WriteHeader(http.StatusOK)
+	}
+	if capture.sentHeader || capture.hijacked {
+		return
+	}
+	capture.sentHeader = true
+
+	statusCode := capture.resp.StatusCode
+	capture.resp.Headers = capture.resp.Header().Clone()
+	if capture.client != nil {
+		for key, values := range capture.resp.Headers {
+			for _, value := range values {
+				capture.client.Header().Add(key, value)
+			}
+		}
+		capture.client.WriteHeader(statusCode)
+	}
+}
+
+// Write 实现http.ResponseWriter接口。
+func (capture *responseCapture) Write(p []byte) (int, error) {
+	capture.sendHeader()
+
+	if capture.buffer != nil {
+		if capture.maxBodySize > 0 && int64(capture.buffer.Len()+len(p)) > capture.maxBodySize {
+			capture.uncacheable = true
+			if capture.client != nil {
+				// 已经输出到客户端的，不需要再复制
+				capture.releaseBuffer()
+			}
+		}
+		if capture.buffer != nil {
+			capture.buffer.Write(p)
+		}
+	}
+
+	if capture.client != nil {
+		return capture.client.Write(p)
+	}
+	return len(p), nil
+}
+
+// Flush 实现http.Flusher接口。流式输出时，刷新到客户端。
+func (capture *responseCapture) Flush() {
+	capture.sendHeader()
+	if flusher, ok := capture.client.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack 实现http.Hijacker接口。流式输出时，接管客户端的连接。接管后，响应不可缓存。
+func (capture *responseCapture) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := capture.client.(http.Hijacker)
+	if !ok {
+		return nil, nil, errHijackNotSupported
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	capture.hijacked = true
+	capture.uncacheable = true
+	capture.releaseBuffer()
+	return conn, rw, nil
+}
+
+// finish next执行完后调用。复制响应体，归还缓冲区。返回响应是否可以缓存。
+func (capture *responseCapture) finish() (cacheable bool) {
+	// 没有调用WriteHeader的，同http.ResponseWriter，等同200
+	capture.sendHeader()
+
+	if capture.buffer != nil && capture.buffer.Len() > 0 {
+		capture.resp.Body = append(make([]byte, 0, capture.buffer.Len()), capture.buffer.Bytes()...)
+	}
+	capture.releaseBuffer()
+	return !capture.uncacheable
+}
+
+// releaseBuffer 归还缓冲区。
+func (capture *responseCapture) releaseBuffer() {
+	if capture.buffer == nil {
+		return
+	}
+	if capture.buffer.Cap() <= DefaultMaxBodySize*4 { // 不缓存过大的缓冲区
+		captureBufferPool.Put(capture.buffer)
+	}
+	capture.buffer = nil
+}
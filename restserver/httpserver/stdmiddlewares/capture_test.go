@@ -0,0 +1,175 @@
+package stdmiddlewares
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wencan/fastrest/restcache/lrucache"
+)
+
+func TestCacheMiddlewareFactory_Streaming(t *testing.T) {
+	var count int64
+	proceed := make(chan struct{})
+	factory := CacheMiddlewareFactory{
+		Storage:  lrucache.NewLRUCache(100, 10),
+		TTLRange: [2]time.Duration{time.Minute, time.Minute * 2},
+	}
+	handler := factory.NewCacheMiddleware()(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&count, 1) == 1 {
+			w.Write([]byte("hello "))
+			w.(http.Flusher).Flush()
+			<-proceed
+		}
+		w.Write([]byte("world"))
+	})
+	s := httptest.NewServer(handler)
+	defer s.Close()
+
+	// 执行next的请求，响应流式输出
+	resp, err := s.Client().Get(s.URL + "/hello")
+	if assert.Nil(t, err) {
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		reader := bufio.NewReader(resp.Body)
+		part, err := reader.ReadString(' ')
+		if assert.Nil(t, err) {
+			assert.Equal(t, "hello ", part)
+		}
+		close(proceed)
+		rest, err := io.ReadAll(reader)
+		if assert.Nil(t, err) {
+			assert.Equal(t, "world", string(rest))
+		}
+	}
+
+	// 缓存了完整的响应
+	resp, err = s.Client().Get(s.URL + "/hello")
+	if assert.Nil(t, err) {
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if assert.Nil(t, err) {
+			assert.Equal(t, "hello world", string(body))
+		}
+		assert.NotEmpty(t, resp.Header.Get("Age"))
+	}
+	assert.Equal(t, int64(1), atomic.LoadInt64(&count))
+}
+
+func TestCacheMiddlewareFactory_MaxBodySize(t *testing.T) {
+	var count int64
+	factory := CacheMiddlewareFactory{
+		Storage:     lrucache.NewLRUCache(100, 10),
+		TTLRange:    [2]time.Duration{time.Minute, time.Minute * 2},
+		MaxBodySize: 8,
+	}
+	handler := factory.NewCacheMiddleware()(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&count, 1)
+		w.Write([]byte(strings.TrimPrefix(r.URL.Path, "/")))
+	})
+
+	// 超过最大字节数的，照常输出，不缓存
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, "/hello_world", nil))
+		assert.Equal(t, "hello_world", w.Body.String())
+	}
+	assert.Equal(t, int64(2), atomic.LoadInt64(&count))
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, "/hello", nil))
+		assert.Equal(t, "hello", w.Body.String())
+	}
+	assert.Equal(t, int64(3), atomic.LoadInt64(&count))
+}
+
+func TestCacheMiddlewareFactory_Hijack(t *testing.T) {
+	var count int64
+	factory := CacheMiddlewareFactory{
+		Storage:  lrucache.NewLRUCache(100, 10),
+		TTLRange: [2]time.Duration{time.Minute, time.Minute * 2},
+	}
+	handler := factory.NewCacheMiddleware()(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&count, 1)
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\nConnection: close\r\n\r\nhijacked")
+		rw.Flush()
+	})
+	s := httptest.NewServer(handler)
+	defer s.Close()
+
+	// 被接管的，不缓存
+	for i := 0; i < 2; i++ {
+		resp, err := s.Client().Get(s.URL + "/hello")
+		if assert.Nil(t, err) {
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if assert.Nil(t, err) {
+				assert.Equal(t, "hijacked", string(body))
+			}
+		}
+	}
+	assert.Equal(t, int64(2), atomic.LoadInt64(&count))
+}
+
+func TestCacheMiddlewareFactory_ImplicitStatus(t *testing.T) {
+	storage := lrucache.NewLRUCache(100, 10)
+	factory := CacheMiddlewareFactory{
+		Storage:  storage,
+		TTLRange: [2]time.Duration{time.Minute, time.Minute * 2},
+	}
+	handler := factory.NewCacheMiddleware()(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("From", "next")
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	w := httptest.NewRecorder()
+	handler(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "next", w.Header().Get("From"))
+
+	// 没有调用WriteHeader，存储的状态码为200
+	var stored cacheableResponse
+	found, err := storage.Get(context.TODO(), DefaultRequestCacheKeyGenerator(r), &stored)
+	if assert.Nil(t, err) && assert.True(t, found) {
+		assert.Equal(t, http.StatusOK, stored.StatusCode)
+		assert.Equal(t, "next", stored.Headers.Get("From"))
+	}
+}
+
+func TestCacheMiddlewareFactory_LateHeader(t *testing.T) {
+	var count int64
+	factory := CacheMiddlewareFactory{
+		Storage:  lrucache.NewLRUCache(100, 10),
+		TTLRange: [2]time.Duration{time.Minute, time.Minute * 2},
+	}
+	handler := factory.NewCacheMiddleware()(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&count, 1)
+		w.Header().Set("X-Early", "1")
+		w.Write([]byte("hello"))
+		// 输出响应头之后的修改，既不输出，也不缓存
+		w.Header().Set("X-Late", "1")
+		w.Header().Del("X-Early")
+	})
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, "/hello", nil))
+		assert.Equal(t, "hello", w.Body.String())
+		assert.Equal(t, "1", w.Header().Get("X-Early"))
+		assert.Empty(t, w.Header().Get("X-Late"))
+	}
+	assert.Equal(t, int64(1), atomic.LoadInt64(&count))
+}
@@ -0,0 +1,83 @@
+package stdmiddlewares
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// generateValidators 为没有ETag、Last-Modified的200响应生成强ETag和Last-Modified。
+// ETag为响应体的摘要，Last-Modified为响应的生成时间。
+func (resp *cacheableResponse) generateValidators() {
+	if resp.StatusCode != 0 && resp.StatusCode != http.StatusOK {
+		return
+	}
+
+	header := resp.Header()
+	if header.Get("ETag") == "" {
+		sum := sha256.Sum256(resp.Body)
+		header.Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	}
+	if header.Get("Last-Modified") == "" && resp.GenerateTimestamp > 0 {
+		header.Set("Last-Modified", time.Unix(resp.GenerateTimestamp, 0).UTC().Format(http.TimeFormat))
+	}
+}
+
+// notModified 请求的If-None-Match、If-Modified-Since条件是否表示客户端的响应仍然有效，可以输出304。
+// 只用于GET、HEAD请求的200响应。有If-None-Match时，忽略If-Modified-Since。
+func (resp cacheableResponse) notModified(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if resp.StatusCode != 0 && resp.StatusCode != http.StatusOK {
+		return false
+	}
+
+	if ifNoneMatch := strings.Join(r.Header.Values("If-None-Match"), ","); ifNoneMatch != "" {
+		etag := resp.Headers.Get("ETag")
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range splitDirectives(ifNoneMatch) {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || weakETagMatch(candidate, etag) {
+				return true
+			}
+		}
+		return false
+	}
+
+	ifModifiedSince, lastModified := r.Header.Get("If-Modified-Since"), resp.Headers.Get("Last-Modified")
+	if ifModifiedSince == "" || lastModified == "" {
+		return false
+	}
+	ifModifiedSinceTime, err := http.ParseTime(ifModifiedSince)
+	if err != nil {
+		return false
+	}
+	lastModifiedTime, err := http.ParseTime(lastModified)
+	if err != nil {
+		return false
+	}
+	return !lastModifiedTime.After(ifModifiedSinceTime)
+}
+
+// weakETagMatch ETag的弱比较。忽略W/前缀。
+func weakETagMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// notModifiedHeaders 304响应需要的头。
+var notModifiedHeaders = []string{"Cache-Control", "Content-Location", "Date", "ETag", "Expires", "Last-Modified", "Vary"}
+
+// ApplyNotModified 输出304响应。只输出304响应需要的头，不输出响应体。
+func (resp *cacheableResponse) ApplyNotModified(w http.ResponseWriter) {
+	for _, key := range notModifiedHeaders {
+		for _, value := range resp.Headers.Values(key) {
+			w.Header().Add(key, value)
+		}
+	}
+	w.WriteHeader(http.StatusNotModified)
+}
@@ -0,0 +1,74 @@
+package stdmiddlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_cacheableResponse_generateValidators(t *testing.T) {
+	resp := cacheableResponse{StatusCode: http.StatusOK, Body: []byte("hello"), GenerateTimestamp: time.Now().Unix()}
+	resp.generateValidators()
+	etag := resp.Headers.Get("ETag")
+	assert.Regexp(t, `^"[0-9a-f]{32}"$`, etag)
+	assert.NotEmpty(t, resp.Headers.Get("Last-Modified"))
+
+	// 相同的响应体，相同的ETag
+	other := cacheableResponse{StatusCode: http.StatusOK, Body: []byte("hello")}
+	other.generateValidators()
+	assert.Equal(t, etag, other.Headers.Get("ETag"))
+
+	// 不覆盖已有的
+	other = cacheableResponse{StatusCode: http.StatusOK, Headers: http.Header{"Etag": []string{`"v1"`}}}
+	other.generateValidators()
+	assert.Equal(t, `"v1"`, other.Headers.Get("ETag"))
+
+	// 只生成200响应的
+	other = cacheableResponse{StatusCode: http.StatusMovedPermanently}
+	other.generateValidators()
+	assert.Empty(t, other.Headers.Get("ETag"))
+}
+
+func Test_cacheableResponse_notModified(t *testing.T) {
+	lastModified := time.Now().Add(-time.Hour).UTC()
+	resp := cacheableResponse{
+		StatusCode: http.StatusOK,
+		Headers: http.Header{
+			"Etag":          []string{`"v1"`},
+			"Last-Modified": []string{lastModified.Format(http.TimeFormat)},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		headers http.Header
+		want    bool
+	}{
+		{name: "no_condition", want: false},
+		{name: "etag_match", headers: http.Header{"If-None-Match": []string{`"v0", "v1"`}}, want: true},
+		{name: "etag_weak_match", headers: http.Header{"If-None-Match": []string{`W/"v1"`}}, want: true},
+		{name: "etag_any", headers: http.Header{"If-None-Match": []string{"*"}}, want: true},
+		{name: "etag_mismatch", headers: http.Header{"If-None-Match": []string{`"v0"`}}, want: false},
+		{name: "etag_first", headers: http.Header{"If-None-Match": []string{`"v0"`}, "If-Modified-Since": []string{time.Now().UTC().Format(http.TimeFormat)}}, want: false},
+		{name: "not_modified_since", headers: http.Header{"If-Modified-Since": []string{lastModified.Format(http.TimeFormat)}}, want: true},
+		{name: "modified_since", headers: http.Header{"If-Modified-Since": []string{lastModified.Add(-time.Minute).Format(http.TimeFormat)}}, want: false},
+		{name: "post", method: http.MethodPost, headers: http.Header{"If-None-Match": []string{`"v1"`}}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			r := httptest.NewRequest(method, "/", nil)
+			for key, values := range tt.headers {
+				r.Header[key] = values
+			}
+			assert.Equal(t, tt.want, resp.notModified(r))
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package stdmiddlewares
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// DefaultQueryDeny 默认从缓存key去掉的查询参数。一般是不影响响应的跟踪参数。可覆盖。
+// 以*结尾的，匹配前缀。
+var DefaultQueryDeny = []string{"utm_*", "gclid", "fbclid", "msclkid", "_"}
+
+// CachePolicy 路径的缓存策略。可以从JSON加载。
+type CachePolicy struct {
+	// Pattern 路径模式。
+	// 以/结尾的，匹配前缀，同http.ServeMux；包含*、?、[的，按path.Match匹配；其它的，完全匹配。
+	Pattern string `json:"pattern"`
+
+	// NoCache 不缓存匹配的请求。用于在宽泛的模式前排除个别路径。
+	NoCache bool `json:"no_cache,omitempty"`
+
+	// TTLRange 缓存生存时间区间。默认为CacheMiddlewareFactory的TTLRange。
+	// JSON格式为时长字符串，如["4m", "6m"]，或者秒数。
+	TTLRange [2]time.Duration `json:"ttl_range,omitempty"`
+
+	// Methods 缓存的请求方法。默认为GET、HEAD。
+	Methods []string `json:"methods,omitempty"`
+
+	// QueryAllow 缓存key只使用这些查询参数。可选。设置后，QueryDeny无效。
+	QueryAllow []string `json:"query_allow,omitempty"`
+
+	// QueryDeny 缓存key去掉的查询参数。以*结尾的，匹配前缀。
+	// 没有设置QueryAllow时，DefaultQueryDeny也总是去掉；设置了QueryAllow的，只按QueryAllow过滤。
+	QueryDeny []string `json:"query_deny,omitempty"`
+
+	// KeyGenerator 缓存key生成器。可选。
+	// 默认使用请求方法、Host、路径和规范化的查询参数：查询参数按名称排序，按QueryAllow、QueryDeny过滤。
+	KeyGenerator RequestCacheKeyGenerator `json:"-"`
+}
+
+// UnmarshalJSON 实现json.Unmarshaler接口。支持时长字符串格式的TTLRange。
+func (policy *CachePolicy) UnmarshalJSON(data []byte) error {
+	type plainPolicy CachePolicy
+	aux := struct {
+		*plainPolicy
+		TTLRange [2]jsonDuration `json:"ttl_range"`
+	}{plainPolicy: (*plainPolicy)(policy)}
+	err := json.Unmarshal(data, &aux)
+	if err != nil {
+		return err
+	}
+	policy.TTLRange = [2]time.Duration{time.Duration(aux.TTLRange[0]), time.Duration(aux.TTLRange[1])}
+	return nil
+}
+
+// jsonDuration JSON格式的时长。可以是时长字符串，或者秒数。
+type jsonDuration time.Duration
+
+// UnmarshalJSON 实现json.Unmarshaler接口。
+func (d *jsonDuration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return err
+	}
+	switch value := value.(type) {
+	case string:
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*d = jsonDuration(duration)
+	case float64:
+		*d = jsonDuration(value * float64(time.Second))
+	case nil:
+		*d = 0
+	default:
+		return fmt.Errorf("invalid duration: %s", data)
+	}
+	return nil
+}
+
+// LoadCachePolicies 从JSON加载缓存策略。JSON为策略数组。
+func LoadCachePolicies(r io.Reader) ([]CachePolicy, error) {
+	var policies []CachePolicy
+	err := json.NewDecoder(r).Decode(&policies)
+	if err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+// validate 检查策略。
+func (policy CachePolicy) validate() error {
+	if policy.Pattern == "" {
+		return errors.New("empty cache policy pattern")
+	}
+	if _, err := path.Match(policy.Pattern, ""); err != nil {
+		return fmt.Errorf("invalid cache policy pattern [%s]: %w", policy.Pattern, err)
+	}
+	if policy.TTLRange[0] > policy.TTLRange[1] {
+		return fmt.Errorf("invalid cache policy ttl range [%s]: %v", policy.Pattern, policy.TTLRange)
+	}
+	return nil
+}
+
+// match 路径是否匹配策略的模式。
+func (policy CachePolicy) match(urlPath string) bool {
+	switch {
+	case strings.HasSuffix(policy.Pattern, "/"):
+		return strings.HasPrefix(urlPath, policy.Pattern)
+	case strings.ContainsAny(policy.Pattern, "*?["):
+		matched, _ := path.Match(policy.Pattern, urlPath)
+		return matched
+	default:
+		return urlPath == policy.Pattern
+	}
+}
+
+// keyGenerator 策略的缓存key生成器。
+func (policy CachePolicy) keyGenerator() RequestCacheKeyGenerator {
+	if policy.KeyGenerator != nil {
+		return policy.KeyGenerator
+	}
+
+	methods := policy.Methods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodHead}
+	}
+	deny := append(append([]string{}, DefaultQueryDeny...), policy.QueryDeny...)
+	return func(r *http.Request) string {
+		var allowed bool
+		for _, method := range methods {
+			if strings.EqualFold(method, r.Method) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return ""
+		}
+
+		cc := parseCacheControl(r.Header)
+		if cc.has("no-cache") || cc.has("no-store") {
+			return ""
+		}
+
+		key := fmt.Sprintf("%s:%s:%s", r.Method, r.Host, r.URL.EscapedPath())
+		if query := normalizeQuery(r.URL.Query(), policy.QueryAllow, deny); query != "" {
+			key += "?" + query
+		}
+		return key
+	}
+}
+
+// normalizeQuery 规范化查询参数。按名称排序，同名参数保持原来的顺序。
+// 如果allow不为空，只保留allow的参数；否则去掉deny的参数。
+func normalizeQuery(query url.Values, allow, deny []string) string {
+	for name := range query {
+		if len(allow) > 0 {
+			if !matchQueryName(allow, name) {
+				delete(query, name)
+			}
+		} else if matchQueryName(deny, name) {
+			delete(query, name)
+		}
+	}
+	return query.Encode()
+}
+
+// matchQueryName 查询参数名是否匹配。以*结尾的模式，匹配前缀。
+func matchQueryName(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(name, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// NewPolicyCacheMiddleware 创建按路径选择缓存策略的缓存中间件。
+// 请求路径按顺序匹配策略，使用第一个匹配的策略；没有匹配的，不使用缓存。
+// 每个策略使用各自的TTLRange、KeyGenerator，其它配置同factory。
+func (factory CacheMiddlewareFactory) NewPolicyCacheMiddleware(policies ...CachePolicy) (func(next http.HandlerFunc) http.HandlerFunc, error) {
+	middlewares := make([]func(next http.HandlerFunc) http.HandlerFunc, len(policies))
+	for index, policy := range policies {
+		err := policy.validate()
+		if err != nil {
+			return nil, err
+		}
+		if policy.NoCache {
+			continue
+		}
+
+		policyFactory := factory
+		if policy.TTLRange[1] > 0 {
+			policyFactory.TTLRange = policy.TTLRange
+		}
+		policyFactory.KeyGenerator = policy.keyGenerator()
+		middlewares[index] = policyFactory.NewCacheMiddleware()
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		handlers := make([]http.HandlerFunc, len(middlewares))
+		for index, middleware := range middlewares {
+			if middleware != nil {
+				handlers[index] = middleware(next)
+			}
+		}
+
+		return func(w http.ResponseWriter, r *http.Request) {
+			for index, policy := range policies {
+				if !policy.match(r.URL.Path) {
+					continue
+				}
+				if handlers[index] == nil { // 不缓存
+					break
+				}
+				handlers[index](w, r)
+				return
+			}
+			next(w, r)
+		}
+	}, nil
+}
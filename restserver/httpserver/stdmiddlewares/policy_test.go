@@ -0,0 +1,139 @@
+package stdmiddlewares
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wencan/fastrest/restcache/lrucache"
+)
+
+func TestLoadCachePolicies(t *testing.T) {
+	policies, err := LoadCachePolicies(strings.NewReader(`[
+		{"pattern": "/users/admin", "no_cache": true},
+		{"pattern": "/users/", "ttl_range": ["4m", "6m"], "query_allow": ["page"]},
+		{"pattern": "/items/*", "ttl_range": [60, 120], "methods": ["GET"], "query_deny": ["session"]}
+	]`))
+	if assert.Nil(t, err) {
+		assert.Equal(t, []CachePolicy{
+			{Pattern: "/users/admin", NoCache: true},
+			{Pattern: "/users/", TTLRange: [2]time.Duration{time.Minute * 4, time.Minute * 6}, QueryAllow: []string{"page"}},
+			{Pattern: "/items/*", TTLRange: [2]time.Duration{time.Minute, time.Minute * 2}, Methods: []string{"GET"}, QueryDeny: []string{"session"}},
+		}, policies)
+	}
+
+	_, err = LoadCachePolicies(strings.NewReader(`[{"pattern": "/", "ttl_range": ["4x", "6m"]}]`))
+	assert.NotNil(t, err)
+}
+
+func TestCachePolicy_match(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{pattern: "/users", path: "/users", want: true},
+		{pattern: "/users", path: "/users/1", want: false},
+		{pattern: "/users/", path: "/users/1/orders", want: true},
+		{pattern: "/users/*", path: "/users/1", want: true},
+		{pattern: "/users/*", path: "/users/1/orders", want: false},
+		{pattern: "/users/*/orders", path: "/users/1/orders", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+tt.path, func(t *testing.T) {
+			assert.Equal(t, tt.want, CachePolicy{Pattern: tt.pattern}.match(tt.path))
+		})
+	}
+}
+
+func Test_normalizeQuery(t *testing.T) {
+	query, _ := url.ParseQuery("b=2&a=1&utm_source=x&a=0&session=s")
+	assert.Equal(t, "a=1&a=0&b=2&session=s", normalizeQuery(query, nil, DefaultQueryDeny))
+
+	query, _ = url.ParseQuery("b=2&a=1&utm_source=x&session=s")
+	assert.Equal(t, "a=1&b=2", normalizeQuery(query, nil, []string{"utm_*", "session"}))
+
+	query, _ = url.ParseQuery("b=2&a=1&utm_source=x")
+	assert.Equal(t, "b=2", normalizeQuery(query, []string{"b"}, DefaultQueryDeny))
+}
+
+func TestCacheMiddlewareFactory_NewPolicyCacheMiddleware(t *testing.T) {
+	var count int64
+	factory := CacheMiddlewareFactory{
+		Storage:  lrucache.NewLRUCache(100, 10),
+		TTLRange: [2]time.Duration{time.Minute, time.Minute * 2},
+	}
+	middleware, err := factory.NewPolicyCacheMiddleware(
+		CachePolicy{Pattern: "/users/admin", NoCache: true},
+		CachePolicy{Pattern: "/users/", QueryAllow: []string{"page"}},
+		CachePolicy{Pattern: "/items/*", Methods: []string{http.MethodGet, http.MethodPost}},
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+	handler := middleware(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(fmt.Sprintf("%d", atomic.AddInt64(&count, 1))))
+	})
+	serve := func(method, target string) string {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(method, target, nil))
+		return w.Body.String()
+	}
+
+	// 只按允许的查询参数缓存
+	assert.Equal(t, "1", serve(http.MethodGet, "/users/1?page=1&sort=name"))
+	assert.Equal(t, "1", serve(http.MethodGet, "/users/1?sort=age&page=1"))
+	assert.Equal(t, "2", serve(http.MethodGet, "/users/1?page=2"))
+
+	// 排除的路径
+	assert.Equal(t, "3", serve(http.MethodGet, "/users/admin"))
+	assert.Equal(t, "4", serve(http.MethodGet, "/users/admin"))
+
+	// 规范化查询参数，去掉跟踪参数
+	assert.Equal(t, "5", serve(http.MethodGet, "/items/1?b=2&a=1"))
+	assert.Equal(t, "5", serve(http.MethodGet, "/items/1?a=1&b=2&utm_source=mail"))
+
+	// 策略允许的方法
+	assert.Equal(t, "6", serve(http.MethodPost, "/items/2"))
+	assert.Equal(t, "6", serve(http.MethodPost, "/items/2"))
+
+	// 没有匹配的策略
+	assert.Equal(t, "7", serve(http.MethodGet, "/orders/1"))
+	assert.Equal(t, "8", serve(http.MethodGet, "/orders/1"))
+
+	_, err = factory.NewPolicyCacheMiddleware(CachePolicy{Pattern: "/[a"})
+	assert.NotNil(t, err)
+}
+
+func TestCacheMiddlewareFactory_NewPolicyCacheMiddleware_SubSecondTTLRange(t *testing.T) {
+	policies, err := LoadCachePolicies(strings.NewReader(`[{"pattern": "/items/", "ttl_range": ["1s", "1.5s"]}]`))
+	if !assert.Nil(t, err) {
+		return
+	}
+	factory := CacheMiddlewareFactory{
+		Storage: lrucache.NewLRUCache(100, 10),
+	}
+	middleware, err := factory.NewPolicyCacheMiddleware(policies...)
+	if !assert.Nil(t, err) {
+		return
+	}
+	var count int64
+	handler := middleware(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(fmt.Sprintf("%d", atomic.AddInt64(&count, 1))))
+	})
+
+	// TTL区间不足1秒，照常缓存
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, "/items/1", nil))
+		assert.Equal(t, "1", w.Body.String())
+	}
+}
@@ -0,0 +1,57 @@
+package stdmiddlewares
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// varyHeaders 响应的Vary头指定的请求头。按名称排序。
+func (resp cacheableResponse) varyHeaders() []string {
+	var names []string
+	for _, line := range resp.Headers.Values("Vary") {
+		for _, name := range strings.Split(line, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			names = append(names, http.CanonicalHeaderKey(name))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// varyAny 响应的Vary头是否为*。这样的响应不能缓存。
+func (resp cacheableResponse) varyAny() bool {
+	for _, name := range resp.varyHeaders() {
+		if name == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// requestVariant 根据Vary指定的请求头的值，生成请求的变体标识。没有Vary，返回空字符串。
+func requestVariant(r *http.Request, names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	h := sha256.New()
+	for _, name := range names {
+		io.WriteString(h, name)
+		h.Write([]byte{0})
+		io.WriteString(h, strings.Join(r.Header.Values(name), ","))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// variantKey 变体的缓存key。
+func variantKey(key, variant string) string {
+	return key + "#vary:" + variant
+}